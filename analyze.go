@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// windowStats summarizes how much of a short video window is unwatchable.
+type windowStats struct {
+	window float64 // seconds analyzed
+	black  float64 // seconds of black frames
+	frozen float64 // seconds of frozen frames
+}
+
+// deadFraction is the share of the window that is black or frozen. The two
+// often overlap (a held black frame), so the larger one is used.
+func (w windowStats) deadFraction() float64 {
+	if w.window <= 0 {
+		return 0
+	}
+	return maxf(w.black, w.frozen) / w.window
+}
+
+var (
+	blackDurRe    = regexp.MustCompile(`black_duration:\s*([0-9.]+)`)
+	freezeStartRe = regexp.MustCompile(`freeze_start:\s*([0-9.]+)`)
+	freezeDurRe   = regexp.MustCompile(`freeze_duration:\s*([0-9.]+)`)
+)
+
+// analyzeVideoWindow decodes dur seconds of path from start through
+// blackdetect and freezedetect.
+func analyzeVideoWindow(ctx context.Context, path string, start, dur float64) (windowStats, error) {
+	cmd := newCommand(ctx, "ffmpeg",
+		"-hide_banner", "-nostats",
+		"-ss", fmtSec(start), "-t", fmtSec(dur), "-i", path,
+		"-an", "-vf", "blackdetect=d=0.1:pix_th=0.10,freezedetect=n=-60dB:d=0.3",
+		"-f", "null", "-",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return windowStats{}, fmt.Errorf("analyze %s@%s: %w", path, fmtSec(start), err)
+	}
+	st := windowStats{window: dur}
+	for _, m := range blackDurRe.FindAllSubmatch(out, -1) {
+		v, _ := strconv.ParseFloat(string(m[1]), 64)
+		st.black += v
+	}
+	starts := freezeStartRe.FindAllSubmatch(out, -1)
+	durs := freezeDurRe.FindAllSubmatch(out, -1)
+	for _, m := range durs {
+		v, _ := strconv.ParseFloat(string(m[1]), 64)
+		st.frozen += v
+	}
+	if len(starts) > len(durs) { // still frozen when the window ended
+		v, _ := strconv.ParseFloat(string(starts[len(starts)-1][1]), 64)
+		st.frozen += maxf(dur-v, 0)
+	}
+	return st, nil
+}
+
+// checkVideoStart nudges start forward by step while the opening window is
+// mostly black or frozen, analyzing at most tries windows. limit is the latest
+// usable start, or the video length when it loops (the nudge wraps).
+func checkVideoStart(ctx context.Context, path string, start, step, limit float64, loop bool, tries int, debug bool) float64 {
+	const window, deadLimit = 2.0, 0.5
+	for i := 0; i < tries; i++ {
+		st, err := analyzeVideoWindow(ctx, path, start, window)
+		if err != nil {
+			fmt.Printf("start check: %v (keeping videoStart=%.3fs)\n", err, start)
+			return start
+		}
+		if debug {
+			fmt.Printf("start check: videoStart=%.3fs black=%.2fs frozen=%.2fs\n", start, st.black, st.frozen)
+		}
+		if st.deadFraction() <= deadLimit {
+			return start
+		}
+		if i == tries-1 { // the tries are used up; keep where the last nudge landed
+			fmt.Fprintf(os.Stderr, "WARNING: start check: still a dead intro at %.3fs after %d tries; using it\n", start, tries)
+			return start
+		}
+		next := start + step
+		if loop && limit > 0 {
+			next = math.Mod(next, limit)
+		} else if next > limit {
+			fmt.Printf("start check: dead intro at %.3fs but no room to move forward\n", start)
+			return start
+		}
+		fmt.Printf("start check: dead intro at %.3fs, trying %.3fs\n", start, next)
+		start = next
+	}
+	return start
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Caption animation (-subAnim pop): each caption starts scaled down to
+// -subAnimScale percent and grows to full size over -subAnimMs, by \fscx,
+// \fscy and a \t transform added to its leading override block. The
+// transform takes at most a third of the caption's time so a short word is
+// not spent growing. A caption repeating the text of the one just before it
+// (sentence-highlight shows the sentence once per word) does not pop again.
+
+// subAnims are the -subAnim modes other than none.
+var subAnims = map[string]bool{"pop": true}
+
+// popTags returns the override tags that pop a caption of durCS
+// centiseconds from scale percent to the full size sx, sy.
+func popTags(durCS, scale, ms int, sx, sy string) string {
+	ms = min(ms, durCS*10/3)
+	return fmt.Sprintf(`\fscx%d\fscy%d\t(0,%d,\fscx%s\fscy%s)`, scale, scale, ms, sx, sy)
+}
+
+// withLeadingTags adds tags to the first override block of text, or puts
+// them in a new block when the text does not start with one.
+func withLeadingTags(text, tags string) string {
+	if strings.HasPrefix(text, "{") {
+		if j := strings.IndexByte(text, '}'); j >= 0 {
+			return text[:j] + tags + text[j:]
+		}
+	}
+	return "{" + tags + "}" + text
+}
+
+// applyPopAnim pops the captions of the ASS file at path and returns how
+// many it animated. The title card (layer 1) and captions that already
+// scale or transform themselves are left alone.
+func applyPopAnim(path string, scale, ms int) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	n, prev := 0, -1
+	for _, i := range d.dialogues() {
+		ev := &d.events[i]
+		if l := ev.get(d, "Layer"); l != "" && l != "0" {
+			continue
+		}
+		repeat := prev >= 0 && d.events[prev].end == ev.start && plainText(d.events[prev].text) == plainText(ev.text)
+		prev = i
+		lead := leadingTagsRe.FindString(ev.text)
+		if repeat || strings.Contains(lead, `\fsc`) || strings.Contains(lead, `\t(`) || ev.end <= ev.start {
+			continue
+		}
+		style := ev.get(d, "Style")
+		sx, sy := d.styleField(style, "ScaleX"), d.styleField(style, "ScaleY")
+		if _, err := strconv.ParseFloat(sx, 64); err != nil {
+			sx = "100"
+		}
+		if _, err := strconv.ParseFloat(sy, 64); err != nil {
+			sy = "100"
+		}
+		ev.text = withLeadingTags(ev.text, popTags(ev.end-ev.start, scale, ms, sx, sy))
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, writeASS(path, d)
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// assDoc is a parsed ASS script. Only the [Events] section is broken into
+// structured events; everything else is kept verbatim so post-processing
+// never disturbs whatever the generator put in the headers.
+type assDoc struct {
+	head   []string // lines before [Events]
+	format []string // [Events] Format field names
+	events []assEvent
+	tail   []string // lines after [Events]
+}
+
+// assEvent is a Dialogue or Comment line. Start/End are in centiseconds,
+// the native ASS resolution, so shifting and merging never accumulate
+// float rounding.
+type assEvent struct {
+	kind   string   // "Dialogue" or "Comment"
+	fields []string // values in Format order, Text excluded
+	start  int
+	end    int
+	text   string
+}
+
+func readASS(path string) (*assDoc, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := parseASS(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return d, nil
+}
+
+func writeASS(path string, d *assDoc) error {
+	return os.WriteFile(path, d.bytes(), 0o644)
+}
+
+func parseASS(b []byte) (*assDoc, error) {
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	d := &assDoc{}
+	section := ""
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section = strings.ToLower(trimmed)
+			if section == "[events]" {
+				continue
+			}
+		}
+		if section != "[events]" {
+			if d.format == nil {
+				d.head = append(d.head, line)
+			} else {
+				d.tail = append(d.tail, line)
+			}
+			continue
+		}
+		key, val, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		switch key {
+		case "Format":
+			d.format = splitTrim(val, ",", -1)
+		case "Dialogue", "Comment":
+			if d.format == nil {
+				return nil, fmt.Errorf("event before Format line")
+			}
+			ev, err := d.parseEvent(key, val)
+			if err != nil {
+				return nil, err
+			}
+			d.events = append(d.events, ev)
+		}
+	}
+	if d.format == nil {
+		return nil, fmt.Errorf("no [Events] Format line")
+	}
+	for len(d.head) > 0 && strings.TrimSpace(d.head[len(d.head)-1]) == "" {
+		d.head = d.head[:len(d.head)-1]
+	}
+	return d, nil
+}
+
+func (d *assDoc) parseEvent(kind, val string) (assEvent, error) {
+	parts := strings.SplitN(val, ",", len(d.format))
+	if len(parts) != len(d.format) {
+		return assEvent{}, fmt.Errorf("malformed %s: %q", kind, val)
+	}
+	ev := assEvent{kind: kind, fields: parts[:len(parts)-1], text: parts[len(parts)-1]}
+	for i := range ev.fields {
+		ev.fields[i] = strings.TrimSpace(ev.fields[i])
+	}
+	var err error
+	if ev.start, err = parseASSTime(ev.get(d, "Start")); err != nil {
+		return assEvent{}, err
+	}
+	if ev.end, err = parseASSTime(ev.get(d, "End")); err != nil {
+		return assEvent{}, err
+	}
+	return ev, nil
+}
+
+func (d *assDoc) bytes() []byte {
+	var buf bytes.Buffer
+	for _, l := range d.head {
+		buf.WriteString(l + "\n")
+	}
+	buf.WriteString("\n[Events]\n")
+	buf.WriteString("Format: " + strings.Join(d.format, ", ") + "\n")
+	for _, ev := range d.events {
+		ev.set(d, "Start", formatASSTime(ev.start))
+		ev.set(d, "End", formatASSTime(ev.end))
+		buf.WriteString(ev.kind + ": " + strings.Join(ev.fields, ",") + "," + ev.text + "\n")
+	}
+	for _, l := range d.tail {
+		buf.WriteString(l + "\n")
+	}
+	return buf.Bytes()
+}
+
+// fieldIndex returns the position of an event field (Text excluded), or -1.
+func (d *assDoc) fieldIndex(name string) int {
+	for i, f := range d.format[:len(d.format)-1] {
+		if strings.EqualFold(f, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// newEvent returns a Dialogue event with every field defaulted.
+func (d *assDoc) newEvent(start, end int, style, text string) assEvent {
+	ev := assEvent{kind: "Dialogue", fields: make([]string, len(d.format)-1), start: start, end: end, text: text}
+	for i, f := range ev.fields {
+		if f == "" {
+			ev.fields[i] = "0"
+		}
+	}
+	ev.set(d, "Style", style)
+	ev.set(d, "Name", "")
+	ev.set(d, "Effect", "")
+	return ev
+}
+
+func (ev *assEvent) get(d *assDoc, name string) string {
+	if i := d.fieldIndex(name); i >= 0 {
+		return ev.fields[i]
+	}
+	return ""
+}
+
+func (ev *assEvent) set(d *assDoc, name, val string) {
+	if i := d.fieldIndex(name); i >= 0 {
+		ev.fields[i] = val
+	}
+}
+
+// dialogues returns indexes of the Dialogue events, in file order.
+func (d *assDoc) dialogues() []int {
+	var idx []int
+	for i, ev := range d.events {
+		if ev.kind == "Dialogue" {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// shift moves every event by cs centiseconds.
+func (d *assDoc) shift(cs int) {
+	for i := range d.events {
+		d.events[i].start += cs
+		d.events[i].end += cs
+	}
+}
+
+// offset moves every event by cs centiseconds, which may be negative.
+// Starts clamp at 0, and events left ending at or before their start are
+// dropped; it returns how many were.
+func (d *assDoc) offset(cs int) int {
+	kept := d.events[:0]
+	for _, ev := range d.events {
+		ev.start = max(0, ev.start+cs)
+		ev.end += cs
+		if ev.end > ev.start {
+			kept = append(kept, ev)
+		}
+	}
+	n := len(d.events) - len(kept)
+	d.events = kept
+	return n
+}
+
+func offsetASSFile(path string, cs int) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	n := d.offset(cs)
+	return n, writeASS(path, d)
+}
+
+func shiftASSFile(path string, cs int) error {
+	d, err := readASS(path)
+	if err != nil {
+		return err
+	}
+	d.shift(cs)
+	return writeASS(path, d)
+}
+
+// info returns a [Script Info] value such as PlayResX.
+func (d *assDoc) info(key string) string {
+	in := false
+	for _, l := range d.head {
+		t := strings.TrimSpace(l)
+		if strings.HasPrefix(t, "[") {
+			in = strings.EqualFold(t, "[Script Info]")
+			continue
+		}
+		if k, v, ok := strings.Cut(t, ":"); in && ok && strings.EqualFold(k, key) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// setInfo sets a [Script Info] value, adding the line if it is missing.
+func (d *assDoc) setInfo(key, val string) {
+	in, at := false, -1
+	for i, l := range d.head {
+		t := strings.TrimSpace(l)
+		if strings.HasPrefix(t, "[") {
+			in = strings.EqualFold(t, "[Script Info]")
+			if in {
+				at = i + 1
+			}
+			continue
+		}
+		if k, _, ok := strings.Cut(t, ":"); in && ok && strings.EqualFold(k, key) {
+			d.head[i] = key + ": " + val
+			return
+		}
+		if in && t != "" {
+			at = i + 1
+		}
+	}
+	line := key + ": " + val
+	if at < 0 {
+		d.head = append([]string{"[Script Info]", line}, d.head...)
+		return
+	}
+	d.head = append(d.head[:at], append([]string{line}, d.head[at:]...)...)
+}
+
+// playRes returns the script's coordinate space. libass uses 384x288 when
+// neither dimension is given.
+func (d *assDoc) playRes() (int, int) {
+	w, _ := strconv.Atoi(d.info("PlayResX"))
+	h, _ := strconv.Atoi(d.info("PlayResY"))
+	switch {
+	case w <= 0 && h <= 0:
+		return 384, 288
+	case w <= 0:
+		w = h * 4 / 3
+	case h <= 0:
+		h = w * 3 / 4
+	}
+	return w, h
+}
+
+// styleNames returns the names of the styles in [V4+ Styles], in order.
+func (d *assDoc) styleNames() []string {
+	var names []string
+	for _, l := range d.head {
+		if k, v, ok := strings.Cut(strings.TrimSpace(l), ":"); ok && k == "Style" {
+			name, _, _ := strings.Cut(v, ",")
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	return names
+}
+
+// defaultStyle is the style new events are attached to: the captions'
+// style when they use a defined one (a template may define several), else
+// the first.
+func (d *assDoc) defaultStyle() string {
+	names := d.styleNames()
+	for _, i := range d.dialogues() {
+		if st := d.events[i].get(d, "Style"); indexOf(names, st) >= 0 {
+			return st
+		}
+	}
+	if len(names) > 0 {
+		return names[0]
+	}
+	return "Default"
+}
+
+func parseASSTime(s string) (int, error) {
+	h, rest, ok1 := strings.Cut(strings.TrimSpace(s), ":")
+	m, rest, ok2 := strings.Cut(rest, ":")
+	sec, frac, _ := strings.Cut(rest, ".")
+	if !ok1 || !ok2 {
+		return 0, fmt.Errorf("bad ASS time %q", s)
+	}
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	ss, err3 := strconv.Atoi(sec)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, fmt.Errorf("bad ASS time %q", s)
+	}
+	cs := 0
+	if frac != "" {
+		for len(frac) < 2 {
+			frac += "0"
+		}
+		n, err := strconv.Atoi(frac[:2])
+		if err != nil {
+			return 0, fmt.Errorf("bad ASS time %q", s)
+		}
+		cs = n
+	}
+	return ((hh*60+mm)*60+ss)*100 + cs, nil
+}
+
+func formatASSTime(cs int) string {
+	if cs < 0 {
+		cs = 0
+	}
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+// secToCS converts seconds to centiseconds, rounding to nearest.
+func secToCS(sec float64) int {
+	if sec < 0 {
+		return -int(-sec*100 + 0.5)
+	}
+	return int(sec*100 + 0.5)
+}
+
+// assEscapeText makes user text safe as an event's Text: braces would open
+// override blocks and backslashes start escapes such as \N.
+func assEscapeText(s string) string {
+	return strings.NewReplacer("{", "(", "}", ")", `\`, "∖", "\r\n", " ", "\n", " ").Replace(s)
+}
+
+func splitTrim(s, sep string, n int) []string {
+	parts := strings.SplitN(s, sep, n)
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries, so
+// "" is an empty list rather than one blank name.
+func splitList(s string) []string {
+	var out []string
+	for _, p := range splitTrim(s, ",", -1) {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Self-benchmark (avmux bench): a fixed synthetic workload run through the
+// real ffmpeg, so a driver or ffmpeg upgrade that slows renders down shows
+// up the day it happens. The inputs come from lavfi sources and a
+// generated ASS, so no input files are needed. Each run is appended to a
+// history file with the toolchain versions and compared with the last run
+// on the same encoder.
+
+const (
+	benchSeconds = 20 // length of the synthetic video and audio
+	benchHistory = 50 // runs kept in the history file
+)
+
+// benchResult is one run. Rates are higher-is-better.
+type benchResult struct {
+	Time       time.Time `json:"time"`
+	Build      string    `json:"build"`
+	Go         string    `json:"go"`
+	FFmpeg     string    `json:"ffmpeg"`
+	Driver     string    `json:"driver,omitempty"` // NVIDIA driver, with -benchGPU
+	Encoder    string    `json:"encoder"`
+	EncodeFPS  float64   `json:"encode_fps"`
+	BurnFPS    float64   `json:"burn_fps"`    // ass filter alone
+	AudioSpeed float64   `json:"audio_speed"` // seconds of audio mixed per second
+}
+
+type benchMetric struct {
+	name string
+	v    float64
+}
+
+// metrics are the rates compared between runs.
+func (r *benchResult) metrics() []benchMetric {
+	return []benchMetric{{"encode fps", r.EncodeFPS}, {"ass burn fps", r.BurnFPS}, {"audio speed", r.AudioSpeed}}
+}
+
+// defaultBenchFile is the history file in the user's cache directory.
+func defaultBenchFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "avmux", "bench.json")
+}
+
+// benchASS is a caption track like the generator's: one word event every
+// quarter second.
+func benchASS() string {
+	var b strings.Builder
+	b.WriteString("[Script Info]\nScriptType: v4.00+\nPlayResX: 1280\nPlayResY: 720\n\n")
+	b.WriteString("[V4+ Styles]\nFormat: Name, Fontname, Fontsize, PrimaryColour, OutlineColour, Outline, Alignment, MarginV\n")
+	b.WriteString("Style: Default,Arial,64,&H00FFFFFF,&H00000000,3,2,60\n\n")
+	b.WriteString("[Events]\nFormat: Layer, Start, End, Style, Text\n")
+	words := strings.Fields("the quick brown fox jumps over the lazy dog")
+	for cs, i := 0, 0; cs < benchSeconds*100; cs, i = cs+25, i+1 {
+		fmt.Fprintf(&b, "Dialogue: 0,%s,%s,Default,%s\n", formatASSTime(cs), formatASSTime(cs+25), words[i%len(words)])
+	}
+	return b.String()
+}
+
+// timeFFmpeg runs ffmpeg in dir and returns the wall time it took.
+func timeFFmpeg(ctx context.Context, dir string, args []string, to time.Duration) (time.Duration, error) {
+	ctx, cancel := stageContext(ctx, to)
+	defer cancel()
+	cmd := newCommand(ctx, "ffmpeg", append([]string{"-hide_banner", "-nostats", "-v", "error", "-y"}, args...)...)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		return 0, stageError(ctx, "ffmpeg", to, err, "")
+	}
+	return time.Since(start), nil
+}
+
+// runBench runs the workload in work. gpu encodes with NVENC.
+func runBench(ctx context.Context, work string, gpu bool, to time.Duration) (*benchResult, error) {
+	r := &benchResult{Time: time.Now().UTC(), Build: build, Go: runtime.Version(), FFmpeg: ffmpegVersion(), Encoder: "libx264"}
+	if r.Build == "" {
+		r.Build = "dev"
+	}
+	enc := []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "19"}
+	if gpu {
+		if !hasEncoder("h264_nvenc") {
+			return nil, fmt.Errorf("-benchGPU: %s has no h264_nvenc encoder", ffmpegVersion())
+		}
+		r.Encoder = "h264_nvenc"
+		enc = []string{"-c:v", "h264_nvenc", "-preset", "p1"}
+		if out, err := exec.CommandContext(ctx, "nvidia-smi", "--query-gpu=driver_version", "--format=csv,noheader").Output(); err == nil {
+			r.Driver = strings.TrimSpace(string(out))
+		}
+	}
+	frames := float64(benchSeconds * 30)
+	video := fmt.Sprintf("testsrc2=size=1280x720:rate=30:duration=%d", benchSeconds)
+
+	fmt.Println("bench: encode")
+	args := append([]string{"-f", "lavfi", "-i", video}, enc...)
+	d, err := timeFFmpeg(ctx, work, append(args, "-pix_fmt", "yuv420p", "bench-encode.mp4"), to)
+	if err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+	r.EncodeFPS = frames / d.Seconds()
+
+	fmt.Println("bench: ass burn")
+	if !hasFilter("ass") {
+		return nil, fmt.Errorf("%s has no ass filter", ffmpegVersion())
+	}
+	if err := os.WriteFile(filepath.Join(work, "bench.ass"), []byte(benchASS()), 0o644); err != nil {
+		return nil, err
+	}
+	// the null muxer takes raw frames, so only the filter is timed
+	d, err = timeFFmpeg(ctx, work, []string{"-f", "lavfi", "-i", video, "-vf", "ass=bench.ass", "-f", "null", "-"}, to)
+	if err != nil {
+		return nil, fmt.Errorf("ass burn: %w", err)
+	}
+	r.BurnFPS = frames / d.Seconds()
+
+	fmt.Println("bench: audio")
+	secs := benchSeconds * 6
+	d, err = timeFFmpeg(ctx, work, []string{
+		"-f", "lavfi", "-i", fmt.Sprintf("sine=frequency=220:sample_rate=44100:duration=%d", secs),
+		"-f", "lavfi", "-i", fmt.Sprintf("anoisesrc=color=pink:sample_rate=44100:duration=%d", secs),
+		"-filter_complex",
+		"[0:a]volume=1.0,adelay=500|500[v];" +
+			"[1:a]volume=0.3,equalizer=f=3000:t=q:w=1:g=-6[m];" +
+			"[v][m]amix=inputs=2:duration=first:dropout_transition=0,aresample=async=1[aout]",
+		"-map", "[aout]", "-c:a", "aac", "-b:a", "192k", "bench-audio.m4a",
+	}, to)
+	if err != nil {
+		return nil, fmt.Errorf("audio: %w", err)
+	}
+	r.AudioSpeed = float64(secs) / d.Seconds()
+	return r, nil
+}
+
+func readBenchHistory(path string) ([]benchResult, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []benchResult
+	if err := json.Unmarshal(b, &runs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return runs, nil
+}
+
+// saveBenchRun appends r to the history at path, keeping the last
+// benchHistory runs.
+func saveBenchRun(path string, runs []benchResult, r *benchResult) error {
+	runs = append(runs, *r)
+	if len(runs) > benchHistory {
+		runs = runs[len(runs)-benchHistory:]
+	}
+	b, err := json.MarshalIndent(runs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".part"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// lastBenchRun is the latest run in runs with the same encoder, or nil.
+func lastBenchRun(runs []benchResult, encoder string) *benchResult {
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].Encoder == encoder {
+			return &runs[i]
+		}
+	}
+	return nil
+}
+
+// benchRegressions lists the rates of r that fell by more than threshold
+// (a fraction) since prev.
+func benchRegressions(prev, r *benchResult, threshold float64) []string {
+	var bad []string
+	old := prev.metrics()
+	for i, m := range r.metrics() {
+		if o := old[i].v; o > 0 && m.v < o*(1-threshold) {
+			bad = append(bad, fmt.Sprintf("%s %.1f -> %.1f (%+.0f%%)", m.name, o, m.v, (m.v/o-1)*100))
+		}
+	}
+	return bad
+}
+
+// toolchainChanges says which versions differ between prev and r.
+func toolchainChanges(prev, r *benchResult) string {
+	var ch []string
+	for _, c := range []struct{ name, a, b string }{
+		{"avmux", prev.Build, r.Build}, {"go", prev.Go, r.Go}, {"ffmpeg", prev.FFmpeg, r.FFmpeg}, {"driver", prev.Driver, r.Driver},
+	} {
+		if c.a != c.b {
+			ch = append(ch, fmt.Sprintf("%s %q -> %q", c.name, c.a, c.b))
+		}
+	}
+	if len(ch) == 0 {
+		return "no toolchain change"
+	}
+	return strings.Join(ch, "; ")
+}
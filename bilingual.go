@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bilingual captions (-subsSecondary): each caption gets a second line,
+// a translation in the smaller, dimmer Secondary style. The translations
+// come from an .srt/.ass, matched to the captions by time, or from a JSON
+// object or array keyed by caption index (0-based, after grouping; a
+// sentence-highlight sentence counts once). A caption overlapping no
+// translation keeps its one line, and a match whose start or end is off by
+// more than -subsSecondaryTol is reported.
+
+// secondaryStyle is the style of the translation line.
+const secondaryStyle = "Secondary"
+
+// secondaryTag starts the translation line of a caption; the SRT stops
+// there so closed captions carry only the narration.
+const secondaryTag = `\N{\r` + secondaryStyle + `}`
+
+type subCue struct {
+	start, end int // cs
+	text       string
+}
+
+// secondarySubs is a translation source: timed cues or texts by index.
+type secondarySubs struct {
+	timed   []subCue
+	byIndex map[int]string
+}
+
+func readSecondarySubs(path string) (*secondarySubs, error) {
+	if strings.ToLower(filepath.Ext(path)) != ".json" {
+		d, err := readSubsIn(path)
+		if err != nil {
+			return nil, err
+		}
+		s := &secondarySubs{}
+		for _, i := range d.dialogues() {
+			ev := &d.events[i]
+			if t := strings.TrimSpace(plainText(ev.text)); t != "" {
+				s.timed = append(s.timed, subCue{ev.start, ev.end, t})
+			}
+		}
+		sort.SliceStable(s.timed, func(a, b int) bool { return s.timed[a].start < s.timed[b].start })
+		return s, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &secondarySubs{byIndex: map[int]string{}}
+	var list []string
+	var obj map[string]string
+	switch {
+	case json.Unmarshal(b, &list) == nil:
+		for i, t := range list {
+			s.byIndex[i] = t
+		}
+	case json.Unmarshal(b, &obj) == nil:
+		for k, t := range obj {
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("%s: key %q is not a caption index", path, k)
+			}
+			s.byIndex[i] = t
+		}
+	default:
+		return nil, fmt.Errorf("%s: want a JSON array of strings or an object of index -> text", path)
+	}
+	for i, t := range s.byIndex {
+		if t = strings.TrimSpace(t); t == "" {
+			delete(s.byIndex, i)
+		} else {
+			s.byIndex[i] = t
+		}
+	}
+	if len(s.byIndex) == 0 {
+		return nil, fmt.Errorf("%s: no translations", path)
+	}
+	return s, nil
+}
+
+// captionGroups returns the layer-0 captions of d in start order, with
+// contiguous events showing the same text (sentence-highlight) together.
+func captionGroups(d *assDoc) [][]int {
+	var evs []int
+	for _, i := range d.dialogues() {
+		if l := d.events[i].get(d, "Layer"); l == "" || l == "0" {
+			evs = append(evs, i)
+		}
+	}
+	sort.SliceStable(evs, func(a, b int) bool { return d.events[evs[a]].start < d.events[evs[b]].start })
+	var groups [][]int
+	for _, i := range evs {
+		if n := len(groups); n > 0 {
+			last := &d.events[groups[n-1][len(groups[n-1])-1]]
+			if last.end == d.events[i].start && plainText(last.text) == plainText(d.events[i].text) {
+				groups[n-1] = append(groups[n-1], i)
+				continue
+			}
+		}
+		groups = append(groups, []int{i})
+	}
+	return groups
+}
+
+// match returns the translation for caption k spanning start..end, and
+// the timing mismatch to report, if any.
+func (s *secondarySubs) match(k, start, end, tol int) (string, string) {
+	if s.byIndex != nil {
+		return s.byIndex[k], ""
+	}
+	best, overlap := -1, 0
+	for j, c := range s.timed {
+		if o := min(end, c.end) - max(start, c.start); o > overlap {
+			best, overlap = j, o
+		}
+	}
+	if best < 0 {
+		return "", ""
+	}
+	c := s.timed[best]
+	if abs(c.start-start) > tol || abs(c.end-end) > tol {
+		return c.text, fmt.Sprintf("caption %d at %s-%s, translation at %s-%s",
+			k, formatASSTime(start), formatASSTime(end), formatASSTime(c.start), formatASSTime(c.end))
+	}
+	return c.text, ""
+}
+
+// addStyle adds style name as a copy of from with edit applied, unless d
+// already has a style of that name.
+func (d *assDoc) addStyle(from, name string, edit styleEdit) error {
+	for _, n := range d.styleNames() {
+		if n == name {
+			return nil
+		}
+	}
+	var format []string
+	at, line := -1, ""
+	for i, l := range d.head {
+		t := strings.TrimSpace(l)
+		k, v, ok := strings.Cut(t, ":")
+		switch {
+		case !ok:
+		case k == "Format" && format == nil && at < 0:
+			format = splitTrim(v, ",", -1)
+		case k == "Style" && format != nil:
+			fields := splitTrim(v, ",", len(format))
+			if len(fields) != len(format) || fields[0] != from {
+				continue
+			}
+			fields[0] = name
+			for f, val := range edit {
+				j := indexFold(format, f)
+				if j < 0 {
+					return fmt.Errorf("styles have no %s field", f)
+				}
+				fields[j] = val
+			}
+			at, line = i+1, "Style: "+strings.Join(fields, ",")
+		}
+	}
+	if at < 0 {
+		return fmt.Errorf("no style %q to copy", from)
+	}
+	d.head = append(d.head[:at], append([]string{line}, d.head[at:]...)...)
+	return nil
+}
+
+// applySecondary adds the translation lines to the ASS file at path. scale
+// sizes the Secondary style against the default one and color (style
+// &HAABBGGRR form) colours it; tol is in centiseconds. It returns how many
+// captions got a translation and the mismatches to report.
+func applySecondary(path string, s *secondarySubs, scale float64, color string, tol int) (int, []string, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	base := d.defaultStyle()
+	edit := styleEdit{"PrimaryColour": color}
+	if size, err := strconv.ParseFloat(d.styleField(base, "Fontsize"), 64); err == nil {
+		edit["Fontsize"] = styleFieldValue(math.Round(size * scale))
+	}
+	if err := d.addStyle(base, secondaryStyle, edit); err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	n := 0
+	var bad []string
+	for k, g := range captionGroups(d) {
+		first, last := &d.events[g[0]], &d.events[g[len(g)-1]]
+		text, miss := s.match(k, first.start, last.end, tol)
+		if miss != "" {
+			bad = append(bad, miss)
+		}
+		if text == "" {
+			continue
+		}
+		for _, i := range g {
+			if !strings.Contains(d.events[i].text, secondaryTag) {
+				d.events[i].text += secondaryTag + assEscapeText(text)
+			}
+		}
+		n++
+	}
+	return n, bad, writeASS(path, d)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Casting file: -castFile holds rules that give parts of the story their
+// own voice, e.g. quoted dialogue versus narration:
+//
+//	[{"match": "\"[^\"]+\"", "speaker": "p225"},
+//	 {"match": "^Chapter", "scope": "paragraph", "speakerWav": "host.wav", "lang": "en"}]
+//
+// A "match" rule voices each regex match; a "paragraph" rule voices the
+// whole paragraph when the regex matches anywhere in it. Rules are tried
+// in order; text no rule claims keeps the top-level voice.
+
+type castRule struct {
+	Match      string `json:"match"`
+	Scope      string `json:"scope"` // match (default) | paragraph
+	Speaker    string `json:"speaker"`
+	SpeakerWav string `json:"speakerWav"`
+	Lang       string `json:"lang"`
+
+	re *regexp.Regexp
+}
+
+// label names rule i in dialogue lines and -debug output.
+func (r *castRule) label(i int) string { return fmt.Sprintf("cast#%d", i+1) }
+
+// castRuleIndex is the inverse of label; -1 for anything else.
+func castRuleIndex(label string) int {
+	var n int
+	if _, err := fmt.Sscanf(label, "cast#%d", &n); err != nil || n < 1 {
+		return -1
+	}
+	return n - 1
+}
+
+// readCastFile loads and validates the rules; every reference WAV must
+// exist so a typo fails before the first synthesis.
+func readCastFile(path string) ([]castRule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []castRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("%s: no rules", path)
+	}
+	for i := range rules {
+		r := &rules[i]
+		if r.Match == "" {
+			return nil, fmt.Errorf("%s: rule %d has no match", path, i+1)
+		}
+		if r.re, err = regexp.Compile(r.Match); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %w", path, i+1, err)
+		}
+		switch r.Scope {
+		case "":
+			r.Scope = "match"
+		case "match", "paragraph":
+		default:
+			return nil, fmt.Errorf("%s: rule %d: scope must be match|paragraph, got %q", path, i+1, r.Scope)
+		}
+		if r.Speaker == "" && r.SpeakerWav == "" && r.Lang == "" {
+			return nil, fmt.Errorf("%s: rule %d sets no speaker, speakerWav or lang", path, i+1)
+		}
+		if r.SpeakerWav != "" && !pathExists(r.SpeakerWav) {
+			return nil, fmt.Errorf("%s: rule %d: speakerWav not found: %s", path, i+1, r.SpeakerWav)
+		}
+	}
+	return rules, nil
+}
+
+// options returns base re-voiced by the rule; fields it leaves empty keep
+// the top-level values.
+func (r *castRule) options(base *ttsOptions) *ttsOptions {
+	o := *base
+	if r.SpeakerWav != "" {
+		o.speakerWav, o.speaker = r.SpeakerWav, ""
+	}
+	if r.Speaker != "" {
+		o.speaker, o.speakerWav = r.Speaker, r.SpeakerWav
+	}
+	if r.Lang != "" {
+		o.lang = r.Lang
+	}
+	return &o
+}
+
+// castLines splits text into lines voiced by the rules. The speaker of a
+// line is its rule's label, or "" for the top-level voice.
+func castLines(text string, rules []castRule) []dialogueLine {
+	var out []dialogueLine
+	add := func(speaker, s string) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return
+		}
+		if n := len(out); n > 0 && out[n-1].speaker == speaker {
+			if !strings.HasSuffix(out[n-1].text, "\n") {
+				out[n-1].text += " "
+			}
+			out[n-1].text += s
+			return
+		}
+		out = append(out, dialogueLine{speaker: speaker, text: s})
+	}
+	for _, para := range paragraphRe.Split(text, -1) {
+		if i := paragraphRule(para, rules); i >= 0 {
+			add(rules[i].label(i), para)
+			continue
+		}
+		for para != "" {
+			at, end, rule := -1, -1, -1
+			for i := range rules {
+				if rules[i].Scope != "match" {
+					continue
+				}
+				if m := rules[i].re.FindStringIndex(para); m != nil && m[1] > m[0] && (at < 0 || m[0] < at) {
+					at, end, rule = m[0], m[1], i
+				}
+			}
+			if rule < 0 {
+				add("", para)
+				break
+			}
+			add("", para[:at])
+			add(rules[rule].label(rule), para[at:end])
+			para = para[end:]
+		}
+		// keep paragraph breaks for sentence splitting
+		if n := len(out); n > 0 {
+			out[n-1].text += "\n\n"
+		}
+	}
+	for i := range out {
+		out[i].text = strings.TrimSpace(out[i].text)
+	}
+	return out
+}
+
+func paragraphRule(para string, rules []castRule) int {
+	for i := range rules {
+		if rules[i].Scope == "paragraph" && rules[i].re.MatchString(para) {
+			return i
+		}
+	}
+	return -1
+}
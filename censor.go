@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Censoring (-censorList): banned words are masked in the subtitles and
+// silenced or bleeped in the voice. The list has one word per line, or a
+// /regex/ matched against whole words; both ignore case, and punctuation
+// attached to a word never stops it matching. The audio spans come from
+// the word timings of the ASS: a one-word event is cut whole, a word in a
+// longer event gets the share of the event its position in the text
+// suggests.
+
+type censorList struct {
+	words map[string]bool // lowercased
+	res   []*regexp.Regexp
+}
+
+func readCensorList(path string) (*censorList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c := &censorList{words: map[string]bool{}}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if len(line) > 2 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") {
+			re, err := regexp.Compile(`(?i)^(?:` + line[1:len(line)-1] + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+			}
+			c.res = append(c.res, re)
+			continue
+		}
+		if strings.IndexFunc(line, unicode.IsSpace) >= 0 {
+			return nil, fmt.Errorf("%s:%d: entry must be a single word or /regex/", path, n)
+		}
+		c.words[strings.ToLower(line)] = true
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(c.words)+len(c.res) == 0 {
+		return nil, fmt.Errorf("%s: no words", path)
+	}
+	return c, nil
+}
+
+func (c *censorList) match(word string) bool {
+	if c.words[strings.ToLower(word)] {
+		return true
+	}
+	for _, re := range c.res {
+		if re.MatchString(word) {
+			return true
+		}
+	}
+	return false
+}
+
+// censorMasks are the -censorMask styles.
+var censorMasks = map[string]func(rs []rune) string{
+	"inner": func(rs []rune) string { // f**k
+		if len(rs) <= 2 {
+			return strings.Repeat("*", len(rs))
+		}
+		return string(rs[0]) + strings.Repeat("*", len(rs)-2) + string(rs[len(rs)-1])
+	},
+	"first": func(rs []rune) string { // f***
+		return string(rs[0]) + strings.Repeat("*", len(rs)-1)
+	},
+	"all": func(rs []rune) string { // ****
+		return strings.Repeat("*", len(rs))
+	},
+}
+
+// censorSpan is a stretch of the voice to silence, in centiseconds.
+type censorSpan struct{ start, end int }
+
+// voiceCensor is what muxVideoVoiceMusic cuts from the voice.
+type voiceCensor struct {
+	spans []censorSpan
+	bleep bool // fill the spans with a tone instead of silence
+}
+
+// censor masks banned words in an event's text, leaving override blocks
+// and escapes alone. It returns the new text and the position of each
+// masked word as fractions [from, to) of the visible text.
+func (c *censorList) censor(text string, mask func([]rune) string) (string, [][2]float64) {
+	var out strings.Builder
+	var word []rune
+	var hits [][2]int
+	visible := 0
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		if c.match(string(word)) {
+			hits = append(hits, [2]int{visible - len(word), visible})
+			out.WriteString(mask(word))
+		} else {
+			out.WriteString(string(word))
+		}
+		word = word[:0]
+	}
+	rs := []rune(text)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '{':
+			flush()
+			j := i
+			for j < len(rs) && rs[j] != '}' {
+				j++
+			}
+			if j == len(rs) {
+				j--
+			}
+			out.WriteString(string(rs[i : j+1]))
+			i = j
+		case r == '\\' && i+1 < len(rs):
+			flush()
+			out.WriteString(string(rs[i : i+2]))
+			i++
+			visible++
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			word = append(word, r)
+			visible++
+		default:
+			flush()
+			out.WriteRune(r)
+			visible++
+		}
+	}
+	flush()
+	var pos [][2]float64
+	for _, h := range hits {
+		pos = append(pos, [2]float64{float64(h[0]) / float64(visible), float64(h[1]) / float64(visible)})
+	}
+	return out.String(), pos
+}
+
+// applyCensor masks the Dialogue events of the ASS file at path and
+// returns the number of words masked and their spans in the voice.
+func applyCensor(path string, c *censorList, mask func([]rune) string) (int, []censorSpan, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	n := 0
+	var spans []censorSpan
+	for _, i := range d.dialogues() {
+		ev := &d.events[i]
+		single := !strings.ContainsAny(strings.TrimSpace(plainText(ev.text)), " \t")
+		text, pos := c.censor(ev.text, mask)
+		if len(pos) == 0 {
+			continue
+		}
+		ev.text = text
+		n += len(pos)
+		dur := float64(ev.end - ev.start)
+		for _, p := range pos {
+			s, e := ev.start, ev.end
+			if !single {
+				s += int(p[0] * dur)
+				e = ev.start + int(math.Ceil(p[1]*dur))
+			}
+			spans = append(spans, censorSpan{s, e})
+		}
+	}
+	if n == 0 {
+		return 0, nil, nil
+	}
+	return n, mergeCensorSpans(spans), writeASS(path, d)
+}
+
+// mergeCensorSpans sorts spans and joins the ones that overlap or touch.
+func mergeCensorSpans(spans []censorSpan) []censorSpan {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var out []censorSpan
+	for _, s := range spans {
+		if n := len(out); n > 0 && s.start <= out[n-1].end {
+			out[n-1].end = max(out[n-1].end, s.end)
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// filter returns the filter_complex chains that censor the voice in label
+// in and write it to label out: the spans are muted, and with bleep a 1 kHz
+// tone fills them.
+func (vc *voiceCensor) filter(in, out string) string {
+	var terms []string
+	for _, s := range vc.spans {
+		terms = append(terms, fmt.Sprintf("between(t,%s,%s)", fmtSec(float64(s.start)/100), fmtSec(float64(s.end)/100)))
+	}
+	expr := strings.Join(terms, "+")
+	f := fmt.Sprintf("%svolume=0:enable='%s'", in, expr)
+	if !vc.bleep {
+		return f + out + ";"
+	}
+	return f + "[cv];" +
+		fmt.Sprintf("sine=frequency=1000:sample_rate=44100,volume=0.25,volume=0:enable='not(%s)'[bl];", expr) +
+		"[cv][bl]amix=inputs=2:duration=first:normalize=0" + out + ";"
+}
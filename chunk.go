@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TTS models degrade on long inputs, so the story is synthesized in
+// sentence-sized chunks and the WAVs are joined with the concat demuxer.
+// The demuxer copies the audio under the first file's header, so every
+// chunk is first converted to one format: dialogue voices and a fallback
+// engine need not share a sample rate or channel count.
+const (
+	chunkRate     = 44100
+	chunkChannels = 1
+)
+
+// splitSentences breaks text after sentence-ending punctuation (plus any
+// closing quotes/brackets) and at blank lines.
+func splitSentences(text string) []string {
+	var out []string
+	var cur strings.Builder
+	emit := func() {
+		if s := strings.Join(strings.Fields(cur.String()), " "); s != "" {
+			out = append(out, s)
+		}
+		cur.Reset()
+	}
+	rs := []rune(text)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		if r == '\n' && i+1 < len(rs) && rs[i+1] == '\n' {
+			emit()
+			continue
+		}
+		cur.WriteRune(r)
+		if !strings.ContainsRune(".!?…。！？", r) {
+			continue
+		}
+		for i+1 < len(rs) && strings.ContainsRune(".!?…\"'”’)]»", rs[i+1]) {
+			i++
+			cur.WriteRune(rs[i])
+		}
+		if i+1 == len(rs) || unicode.IsSpace(rs[i+1]) || isWideRune(r) {
+			emit()
+		}
+	}
+	emit()
+	return out
+}
+
+// chunkText packs whole sentences into chunks of at most maxChars runes.
+// A longer sentence is split at clause punctuation, then between words; a
+// single word longer than maxChars is kept whole. maxChars <= 0 disables
+// chunking.
+func chunkText(text string, maxChars int) []string {
+	text = strings.TrimSpace(text)
+	if maxChars <= 0 || utf8.RuneCountInString(text) <= maxChars {
+		return []string{text}
+	}
+	var pieces []string
+	for _, s := range splitSentences(text) {
+		if utf8.RuneCountInString(s) <= maxChars {
+			pieces = append(pieces, s)
+			continue
+		}
+		for _, c := range splitClauses(s) {
+			if utf8.RuneCountInString(c) <= maxChars {
+				pieces = append(pieces, c)
+			} else {
+				pieces = append(pieces, packWords(strings.Fields(c), maxChars)...)
+			}
+		}
+	}
+	return packWords(pieces, maxChars)
+}
+
+// splitClauses splits after , ; : and dashes that are followed by a space.
+func splitClauses(s string) []string {
+	var out []string
+	start := 0
+	for i, r := range s {
+		if strings.ContainsRune(",;:—–", r) {
+			end := i + utf8.RuneLen(r)
+			if end < len(s) && s[end] == ' ' {
+				out = append(out, strings.TrimSpace(s[start:end]))
+				start = end
+			}
+		}
+	}
+	if rest := strings.TrimSpace(s[start:]); rest != "" {
+		out = append(out, rest)
+	}
+	return out
+}
+
+// packWords greedily joins items with spaces (none around CJK) while the
+// result stays within maxChars runes.
+func packWords(items []string, maxChars int) []string {
+	var out []string
+	cur := ""
+	for _, it := range items {
+		if cur != "" && utf8.RuneCountInString(cur)+1+utf8.RuneCountInString(it) > maxChars {
+			out = append(out, cur)
+			cur = ""
+		}
+		last, _ := utf8.DecodeLastRuneInString(cur)
+		first, _ := utf8.DecodeRuneInString(it)
+		switch {
+		case cur == "":
+			cur = it
+		case isWideRune(last) || isWideRune(first):
+			cur += it
+		default:
+			cur += " " + it
+		}
+	}
+	if cur != "" {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// storyPart is a stretch of the narration: spoken text, or a cue from a
+// stage direction (silence and/or a sound effect at that point).
+type storyPart struct {
+	text    string
+	pause   float64     // seconds of silence
+	sfx     string      // sound effect file mixed in where the part starts
+	speaker string      // dialogue speaker name, "" for the default voice
+	tts     *ttsOptions // voice for speaker; nil -> the default
+}
+
+// partSpan is where a story part lands on the voice timeline, in seconds.
+type partSpan struct {
+	start, end float64
+}
+
+// synthesizeStory runs TTS per chunk of every text part, renders pauses as
+// silence in the same format, and concatenates everything into outPath. A
+// failing chunk is reported with its text. The returned spans (one per
+// part) are nil when the story was synthesized in a single call.
+func synthesizeStory(ctx context.Context, tts *ttsOptions, parts []storyPart,
+	outPath, work string, maxChars int, to time.Duration) ([]partSpan, error) {
+	if limit := ttsCharLimits[tts.engine]; limit > 0 && (maxChars <= 0 || maxChars > limit) {
+		maxChars = limit
+	}
+	type piece struct {
+		part  int
+		wav   string
+		pause float64
+	}
+	var pieces []piece
+	var chunks []string
+	for i, p := range parts {
+		if p.text == "" {
+			pieces = append(pieces, piece{part: i, pause: p.pause})
+			continue
+		}
+		for _, c := range chunkText(p.text, maxChars) {
+			pieces = append(pieces, piece{part: i, wav: filepath.Join(work, fmt.Sprintf("tts-%03d.wav", len(chunks)))})
+			chunks = append(chunks, c)
+		}
+	}
+	voice := func(part int) *ttsOptions {
+		if parts[part].tts != nil {
+			return parts[part].tts
+		}
+		return tts
+	}
+	if len(chunks) == 1 && len(pieces) == 1 {
+		return nil, runTTS(ctx, voice(pieces[0].part), chunks[0], outPath, to)
+	}
+
+	n := 0
+	for i := range pieces {
+		if pieces[i].wav == "" {
+			continue
+		}
+		c := chunks[n]
+		n++
+		fmt.Printf("tts: chunk %d/%d (%d chars)\n", n, len(chunks), utf8.RuneCountInString(c))
+		raw := strings.TrimSuffix(pieces[i].wav, ".wav") + "-raw.wav"
+		if err := runTTS(ctx, voice(pieces[i].part), c, raw, to); err != nil {
+			return nil, fmt.Errorf("chunk %d/%d %q: %w", n, len(chunks), c, err)
+		}
+		if err := conformChunk(ctx, raw, pieces[i].wav); err != nil {
+			return nil, fmt.Errorf("chunk %d/%d: %w", n, len(chunks), err)
+		}
+		_ = os.Remove(raw)
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("nothing to synthesize")
+	}
+
+	var list strings.Builder
+	spans := make([]partSpan, len(parts))
+	at := 0.0
+	lastPart := -1
+	for i, pc := range pieces {
+		if pc.part != lastPart {
+			spans[pc.part] = partSpan{at, at}
+		}
+		lastPart = pc.part
+		if pc.wav == "" {
+			if pc.pause <= 0 {
+				continue
+			}
+			pieces[i].wav = filepath.Join(work, fmt.Sprintf("pause-%03d.wav", i))
+			if err := writeSilence(ctx, pc.pause, pieces[i].wav); err != nil {
+				return nil, fmt.Errorf("render pause: %w", err)
+			}
+		}
+		d, err := probeDuration(ctx, pieces[i].wav)
+		if err != nil {
+			return nil, err
+		}
+		at += d
+		spans[pc.part].end = at
+		abs, err := filepath.Abs(pieces[i].wav)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(abs, "'", `'\''`))
+	}
+	listPath := filepath.Join(work, "tts-concat.txt")
+	if err := os.WriteFile(listPath, []byte(list.String()), 0o644); err != nil {
+		return nil, err
+	}
+	args := []string{"-y", "-v", "error", "-f", "concat", "-safe", "0", "-i", listPath, "-c", "copy", outPath}
+	if err := runFFmpegErr(ctx, args, to); err != nil {
+		return nil, fmt.Errorf("concat tts chunks: %w", err)
+	}
+	return spans, nil
+}
+
+// conformChunk converts the synthesized src to the chunk format at out.
+func conformChunk(ctx context.Context, src, out string) error {
+	args := []string{"-y", "-v", "error", "-i", src,
+		"-ar", strconv.Itoa(chunkRate), "-ac", strconv.Itoa(chunkChannels), "-c:a", "pcm_s16le", out}
+	if err := runFFmpegErr(ctx, args, 0); err != nil {
+		return fmt.Errorf("convert %s: %w", src, err)
+	}
+	return nil
+}
+
+// writeSilence writes dur seconds of silence in the chunk format.
+func writeSilence(ctx context.Context, dur float64, out string) error {
+	args := []string{"-y", "-v", "error", "-f", "lavfi",
+		"-i", fmt.Sprintf("anullsrc=r=%d", chunkRate), "-ac", strconv.Itoa(chunkChannels),
+		"-t", fmtSec(dur), "-c:a", "pcm_s16le", out}
+	return runFFmpegErr(ctx, args, 0)
+}
+
+// Duration sanity check: a model that silently drops text yields a voice
+// far shorter than the script. The expectation is the word count at the
+// -expectedWPM bounds plus every pause; durationSlack absorbs the
+// silence models add around the speech, and scripts under
+// minCheckWords are too short to judge. Chinese and Japanese have no
+// spaces, so their characters are counted at cjkRunesPerWord to a word.
+const (
+	durationSlack   = 2.0 // seconds
+	minCheckWords   = 20
+	cjkRunesPerWord = 2
+)
+
+// parseWPMRange parses -expectedWPM, "LO-HI" words per minute.
+func parseWPMRange(s string) (lo, hi float64, err error) {
+	a, b, ok := strings.Cut(s, "-")
+	if ok {
+		lo, err = strconv.ParseFloat(strings.TrimSpace(a), 64)
+		if err == nil {
+			hi, err = strconv.ParseFloat(strings.TrimSpace(b), 64)
+		}
+	}
+	if !ok || err != nil || lo <= 0 || hi < lo {
+		return 0, 0, fmt.Errorf("-expectedWPM must be LO-HI words per minute with 0 < LO <= HI, got %q", s)
+	}
+	return lo, hi, nil
+}
+
+// expectedDuration returns the plausible voice duration for parts at
+// loWPM..hiWPM, and the number of words it counted. Pause markers and
+// stage directions are already out of the part text.
+func expectedDuration(parts []storyPart, loWPM, hiWPM float64) (lo, hi float64, words int) {
+	var pause float64
+	for _, p := range parts {
+		words += countWords(p.text)
+		pause += p.pause
+	}
+	lo = max(0, float64(words)/hiWPM*60+pause-durationSlack)
+	hi = float64(words)/loWPM*60 + pause + durationSlack
+	return lo, hi, words
+}
+
+// countWords counts the spaced words of text plus its Han and kana
+// characters, cjkRunesPerWord to a word.
+func countWords(text string) int {
+	words, cjk := 0, 0
+	for _, f := range strings.Fields(text) {
+		spaced := false
+		for _, r := range f {
+			switch {
+			case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana):
+				cjk++
+			case unicode.IsLetter(r) || unicode.IsDigit(r):
+				spaced = true
+			}
+		}
+		if spaced {
+			words++
+		}
+	}
+	return words + (cjk+cjkRunesPerWord-1)/cjkRunesPerWord
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+)
+
+// CJK captions: Japanese and Chinese are written without spaces, so
+// whisper's "words" range from one character to a whole clause and
+// one-word cues look wrong. When the language is CJK, clause-long words
+// are first cut into pieces of at most -subMaxCharsCJK characters, timed
+// by their share of the characters, and cues are then grouped by
+// character count instead of -maxWordsPerCue, joined without spaces
+// (Latin words in between keep theirs). Korean spaces its words and is
+// grouped like any other language.
+
+// cjkFonts maps the whisper codes of CJK languages to the font their
+// captions default to.
+var cjkFonts = map[string]string{
+	"ja":  "Noto Sans CJK JP",
+	"zh":  "Noto Sans CJK SC",
+	"yue": "Noto Sans CJK TC",
+}
+
+// cutClause cuts rs into pieces of at most maxChars runes, preferring to
+// end a piece after punctuation and never starting one with a closing
+// mark.
+func cutClause(rs []rune, maxChars int) [][]rune {
+	var pieces [][]rune
+	for len(rs) > maxChars {
+		n := maxChars
+		for k := maxChars; k > maxChars/2; k-- {
+			if strings.ContainsRune(cueBreakChars, rs[k-1]) {
+				n = k
+				break
+			}
+		}
+		for n < len(rs) && isClosingPunct(rs[n]) {
+			n++
+		}
+		pieces = append(pieces, rs[:n])
+		rs = rs[n:]
+	}
+	if len(rs) > 0 {
+		pieces = append(pieces, rs)
+	}
+	return pieces
+}
+
+// splitLongWords cuts the word events of d longer than maxChars into
+// several and returns how many it cut.
+func splitLongWords(d *assDoc, maxChars int) int {
+	long := map[int]bool{}
+	for _, i := range wordEvents(d) {
+		if len([]rune(strings.TrimSpace(plainText(d.events[i].text)))) > maxChars {
+			long[i] = true
+		}
+	}
+	if len(long) == 0 {
+		return 0
+	}
+	var out []assEvent
+	for i, ev := range d.events {
+		if !long[i] {
+			out = append(out, ev)
+			continue
+		}
+		lead := leadingTagsRe.FindString(strings.TrimSpace(ev.text))
+		rs := []rune(strings.TrimSpace(plainText(ev.text)))
+		start, done := ev.start, 0
+		for _, p := range cutClause(rs, maxChars) {
+			done += len(p)
+			part := ev
+			part.fields = append([]string(nil), ev.fields...)
+			part.start = start
+			part.end = ev.start + (ev.end-ev.start)*done/len(rs)
+			part.text = lead + string(p)
+			out = append(out, part)
+			start = part.end
+		}
+	}
+	d.events = out
+	return len(long)
+}
+
+// applyCJKSplit cuts clause-long word events in the ASS file at path and
+// returns how many it cut.
+func applyCJKSplit(path string, maxChars int) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	n := splitLongWords(d, maxChars)
+	if n == 0 {
+		return 0, nil
+	}
+	return n, writeASS(path, d)
+}
+
+// applyCharGrouping merges the word events of the ASS file at path into
+// cues of at most maxChars characters and returns how many were merged
+// away.
+func applyCharGrouping(path string, maxChars, maxGap int) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	n := mergeCues(d, groupChars(d, maxChars, maxGap, cueBreakChars), joinWords)
+	if n == 0 {
+		return 0, nil
+	}
+	return n, writeASS(path, d)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Caption grouping (-maxWordsPerCue): the generator writes one Dialogue
+// event per word. Consecutive words are merged into cues of a few words,
+// spanning the first word's start to the last word's end. A cue never
+// bridges a pause longer than -cueGapMax or a style change, and a word
+// ending a clause closes its cue so captions break where speech does.
+
+// cueBreakChars end a cue when a word ends with one of them.
+const cueBreakChars = ".,!?;:…、。，！？；："
+
+// wordEvents returns the indexes of the one-word Dialogue events of d in
+// start order.
+func wordEvents(d *assDoc) []int {
+	var words []int
+	for _, i := range d.dialogues() {
+		if t := strings.TrimSpace(plainText(d.events[i].text)); t != "" && !strings.ContainsAny(t, " \t") {
+			words = append(words, i)
+		}
+	}
+	sort.SliceStable(words, func(a, b int) bool { return d.events[words[a]].start < d.events[words[b]].start })
+	return words
+}
+
+// groupWords splits the word events of d into cues of at most maxWords.
+// maxGap is in centiseconds.
+func groupWords(d *assDoc, maxWords, maxGap int) [][]int {
+	var cues [][]int
+	var cur []int
+	for _, i := range wordEvents(d) {
+		if n := len(cur); n > 0 {
+			prev := &d.events[cur[n-1]]
+			if n >= maxWords || d.events[i].start-prev.end > maxGap ||
+				d.events[i].get(d, "Style") != prev.get(d, "Style") {
+				cues = append(cues, cur)
+				cur = nil
+			}
+		}
+		cur = append(cur, i)
+		if w := strings.TrimSpace(plainText(d.events[i].text)); strings.ContainsAny(lastRune(w), cueBreakChars) {
+			cues = append(cues, cur)
+			cur = nil
+		}
+	}
+	if len(cur) > 0 {
+		cues = append(cues, cur)
+	}
+	return cues
+}
+
+func lastRune(s string) string {
+	r := []rune(s)
+	if len(r) == 0 {
+		return ""
+	}
+	return string(r[len(r)-1])
+}
+
+// mergeCues replaces each cue of several words with one event built by
+// join and drops the rest. It returns how many events were merged away.
+func mergeCues(d *assDoc, cues [][]int, join func(d *assDoc, cue []int) string) int {
+	drop := map[int]bool{}
+	for _, cue := range cues {
+		if len(cue) < 2 {
+			continue
+		}
+		first := &d.events[cue[0]]
+		first.text = join(d, cue)
+		for _, i := range cue[1:] {
+			first.end = max(first.end, d.events[i].end)
+			drop[i] = true
+		}
+	}
+	if len(drop) == 0 {
+		return 0
+	}
+	kept := d.events[:0]
+	for i, ev := range d.events {
+		if !drop[i] {
+			kept = append(kept, ev)
+		}
+	}
+	d.events = kept
+	return len(drop)
+}
+
+// joinWords keeps the first word's override tags and appends the other
+// words as plain text.
+func joinWords(d *assDoc, cue []int) string {
+	var b strings.Builder
+	prev := ""
+	for k, i := range cue {
+		w := strings.TrimSpace(plainText(d.events[i].text))
+		if k == 0 {
+			b.WriteString(strings.TrimSpace(d.events[i].text))
+		} else {
+			b.WriteString(wordSep(prev, w) + w)
+		}
+		prev = w
+	}
+	return b.String()
+}
+
+// wordSep is what goes between two words of a caption: a space, or
+// nothing when either side is CJK, which is written without spaces.
+func wordSep(a, b string) string {
+	ra, _ := utf8.DecodeLastRuneInString(a)
+	rb, _ := utf8.DecodeRuneInString(b)
+	if isWideRune(ra) || isWideRune(rb) {
+		return ""
+	}
+	return " "
+}
+
+func applyCueGrouping(path string, maxWords, maxGap int) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	n := mergeCues(d, groupWords(d, maxWords, maxGap), joinWords)
+	if n == 0 {
+		return 0, nil
+	}
+	return n, writeASS(path, d)
+}
+
+// Karaoke captions (-subStyle karaoke): each cue shows the whole phrase
+// and a \k tag per word sweeps the highlight along as it is spoken. A
+// word's \k runs from its start to the next word's start, so the tags sum
+// to the phrase length exactly; times are already whole centiseconds.
+
+// karaokePhraseWords is the phrase length when -maxWordsPerCue is 1.
+const karaokePhraseWords = 6
+
+var leadingTagsRe = regexp.MustCompile(`^(\{[^}]*\})*`)
+
+// karaokeJoin returns a join func that colours sung text with highlight
+// and unsung text with base, both in inline &HBBGGRR& form.
+func karaokeJoin(highlight, base string) func(d *assDoc, cue []int) string {
+	return func(d *assDoc, cue []int) string {
+		first := strings.TrimSpace(d.events[cue[0]].text)
+		var b strings.Builder
+		b.WriteString(leadingTagsRe.FindString(first))
+		fmt.Fprintf(&b, `{\1c%s\2c%s}`, highlight, base)
+		prev := ""
+		for k, i := range cue {
+			ev := &d.events[i]
+			end := ev.end
+			if k+1 < len(cue) {
+				end = d.events[cue[k+1]].start
+			}
+			w := strings.TrimSpace(plainText(ev.text))
+			if k > 0 {
+				b.WriteString(wordSep(prev, w))
+			}
+			fmt.Fprintf(&b, `{\k%d}%s`, max(0, end-ev.start), w)
+			prev = w
+		}
+		return b.String()
+	}
+}
+
+// inlineColor turns a Style colour (&HAABBGGRR) into an override's
+// &HBBGGRR&.
+func inlineColor(style string) string {
+	s := strings.TrimPrefix(strings.TrimSpace(style), "&H")
+	if len(s) > 6 {
+		s = s[len(s)-6:]
+	}
+	return "&H" + s + "&"
+}
+
+// applyKaraoke makes karaoke cues of the word events of the ASS file at
+// path, grouped by group, and returns the number of cues.
+func applyKaraoke(path string, group func(d *assDoc) [][]int, highlight, base string) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	if base == "" {
+		base = d.styleField(d.defaultStyle(), "PrimaryColour")
+		if base == "" {
+			base = "&H00FFFFFF"
+		}
+	}
+	cues := group(d)
+	join := karaokeJoin(inlineColor(highlight), inlineColor(base))
+	for _, cue := range cues {
+		if len(cue) == 1 { // mergeCues leaves single words alone
+			d.events[cue[0]].text = join(d, cue)
+		}
+	}
+	mergeCues(d, cues, join)
+	return len(cues), writeASS(path, d)
+}
+
+// Sentence captions (-subStyle sentence-highlight): the whole sentence
+// stays on screen and the word being spoken is coloured and bold. Each word
+// event keeps its start, shows the full sentence with itself highlighted,
+// and lasts until the next word starts; a sentence's last word keeps its
+// own end, cut short if the next sentence starts sooner.
+
+// sentenceEndChars end a sentence when a word ends with one of them.
+const sentenceEndChars = ".!?…。！？"
+
+// groupSentences splits the word events of d into sentences of at most
+// maxChars characters. Like groupWords, a sentence never bridges a pause
+// longer than maxGap (centiseconds) or a style change.
+func groupSentences(d *assDoc, maxChars, maxGap int) [][]int {
+	return groupChars(d, maxChars, maxGap, sentenceEndChars)
+}
+
+// groupChars splits the word events of d into groups of at most maxChars
+// characters, spaces between words included, closing a group after a word
+// ending with one of endChars.
+func groupChars(d *assDoc, maxChars, maxGap int, endChars string) [][]int {
+	var groups [][]int
+	var cur []int
+	chars, last := 0, ""
+	for _, i := range wordEvents(d) {
+		w := strings.TrimSpace(plainText(d.events[i].text))
+		sep := ""
+		if n := len(cur); n > 0 {
+			prev := &d.events[cur[n-1]]
+			sep = wordSep(last, w)
+			if chars+len(sep)+utf8.RuneCountInString(w) > maxChars || d.events[i].start-prev.end > maxGap ||
+				d.events[i].get(d, "Style") != prev.get(d, "Style") {
+				groups = append(groups, cur)
+				cur, chars, sep = nil, 0, ""
+			}
+		}
+		cur = append(cur, i)
+		chars += len(sep) + utf8.RuneCountInString(w)
+		last = w
+		if strings.ContainsAny(lastRune(w), endChars) {
+			groups = append(groups, cur)
+			cur, chars = nil, 0
+		}
+	}
+	if len(cur) > 0 {
+		groups = append(groups, cur)
+	}
+	return groups
+}
+
+// applySentenceHighlight rewrites the word events of the ASS file at path
+// and returns the number of sentences. highlight is the spoken word's
+// colour; base, if set, the others' (both in style &HAABBGGRR form).
+func applySentenceHighlight(path string, maxChars, maxGap int, highlight, base string) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	style := d.defaultStyle()
+	if base == "" {
+		base = d.styleField(style, "PrimaryColour")
+		if base == "" {
+			base = "&H00FFFFFF"
+		}
+	}
+	bold := "0"
+	if b := d.styleField(style, "Bold"); b != "" && b != "0" {
+		bold = "1"
+	}
+	on := fmt.Sprintf(`{\1c%s\b1}`, inlineColor(highlight))
+	off := fmt.Sprintf(`{\1c%s\b%s}`, inlineColor(base), bold)
+
+	sents := groupSentences(d, maxChars, maxGap)
+	for si, sent := range sents {
+		words := make([]string, len(sent))
+		for k, i := range sent {
+			words[k] = strings.TrimSpace(plainText(d.events[i].text))
+		}
+		lead := leadingTagsRe.FindString(strings.TrimSpace(d.events[sent[0]].text))
+		for k, i := range sent {
+			ev := &d.events[i]
+			var b strings.Builder
+			b.WriteString(lead)
+			for j, w := range words {
+				if j > 0 {
+					b.WriteString(wordSep(words[j-1], w))
+				}
+				if j == k {
+					b.WriteString(on + w + off)
+				} else {
+					b.WriteString(w)
+				}
+			}
+			ev.text = b.String()
+			switch {
+			case k+1 < len(sent):
+				ev.end = d.events[sent[k+1]].start
+			case si+1 < len(sents):
+				ev.end = min(ev.end, d.events[sents[si+1][0]].start)
+			}
+			ev.end = max(ev.end, ev.start+1)
+		}
+	}
+	return len(sents), writeASS(path, d)
+}
+
+// Colour cycling (-subColorCycle): successive word events take the next
+// colour of a palette, or a random one (-subColorRandom) from the run's
+// PRNG, never the same twice in a row. Words that already carry a colour
+// override are left alone and do not advance the cycle.
+
+var colorOverrideRe = regexp.MustCompile(`\{[^}]*\\1?c&H`)
+
+// applyColorCycle colours the word events of the ASS file at path with
+// palette (inline &HBBGGRR& form) and returns how many it coloured. rng
+// nil cycles in order.
+func applyColorCycle(path string, palette []string, rng *rand.Rand) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	n, last := 0, -1
+	for _, i := range wordEvents(d) {
+		ev := &d.events[i]
+		if colorOverrideRe.MatchString(ev.text) {
+			continue
+		}
+		c := n % len(palette)
+		if rng != nil {
+			c = rng.Intn(len(palette))
+			if len(palette) > 1 && c == last {
+				c = (c + 1 + rng.Intn(len(palette)-1)) % len(palette)
+			}
+		}
+		ev.text = `{\1c` + palette[c] + `}` + ev.text
+		last = c
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, writeASS(path, d)
+}
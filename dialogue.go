@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Dialogue mode: paragraphs that start with "NAME:" are spoken by that
+// character's voice from -ttsSpeakerMap. Untagged paragraphs continue the
+// previous speaker; those before the first tag use the default voice.
+
+// speakerTagRe matches a leading "Name:" of up to four words.
+var speakerTagRe = regexp.MustCompile(`^\s*(\p{L}[\p{L}\p{N}'.-]*(?: [\p{L}\p{N}'.-]+){0,3})\s*:\s+`)
+
+var paragraphRe = regexp.MustCompile(`\n[ \t]*\n`)
+
+// dialogueLine is one speaker's consecutive paragraphs.
+type dialogueLine struct {
+	speaker string // canonical (upper-case) name, "" for the default voice
+	text    string
+}
+
+// parseSpeakerMap reads "ALICE=p225,BOB=voices/bob.wav". Names are
+// matched case-insensitively; the returned names keep flag order.
+func parseSpeakerMap(s string) ([]string, map[string]string, error) {
+	var names []string
+	m := map[string]string{}
+	for _, kv := range splitTrim(s, ",", -1) {
+		name, val, ok := strings.Cut(kv, "=")
+		name, val = strings.ToUpper(strings.TrimSpace(name)), strings.TrimSpace(val)
+		if !ok || name == "" || val == "" {
+			return nil, nil, fmt.Errorf("want NAME=speaker, got %q", kv)
+		}
+		if _, dup := m[name]; dup {
+			return nil, nil, fmt.Errorf("speaker %s mapped twice", name)
+		}
+		names = append(names, name)
+		m[name] = val
+	}
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("no speakers")
+	}
+	return names, m, nil
+}
+
+// splitDialogue splits text into per-speaker lines. A tag naming an
+// unmapped speaker is an error when it is written in capitals ("CAROL:");
+// other unknown prefixes ("Note: ...") are ordinary text.
+func splitDialogue(text string, voices map[string]string) ([]dialogueLine, error) {
+	var out []dialogueLine
+	cur := dialogueLine{}
+	for _, para := range paragraphRe.Split(text, -1) {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+		if m := speakerTagRe.FindStringSubmatch(para); m != nil {
+			name := strings.ToUpper(m[1])
+			if _, ok := voices[name]; ok {
+				if cur.text != "" {
+					out = append(out, cur)
+				}
+				cur = dialogueLine{speaker: name}
+				para = strings.TrimSpace(para[len(m[0]):])
+			} else if m[1] == name {
+				return nil, fmt.Errorf("unknown speaker %q (mapped: %s)", m[1], strings.Join(sortedKeys(voices), ", "))
+			}
+		}
+		if para == "" {
+			continue
+		}
+		if cur.text != "" {
+			cur.text += "\n\n"
+		}
+		cur.text += para
+	}
+	if cur.text != "" {
+		out = append(out, cur)
+	}
+	return out, nil
+}
+
+// speakerOptions returns a copy of base voiced by val: a voice name for
+// the remote engines, a .onnx voice for piper, a reference WAV for XTTS,
+// or a speaker id.
+func speakerOptions(base *ttsOptions, val string) *ttsOptions {
+	o := *base
+	switch {
+	case ttsRemote[o.engine]:
+		o.voice = val
+	case o.engine == "piper" && strings.HasSuffix(val, ".onnx"):
+		o.model = val
+	case strings.HasSuffix(strings.ToLower(val), ".wav"):
+		o.speakerWav, o.speaker = val, ""
+	default:
+		o.speaker, o.speakerWav = val, ""
+	}
+	return &o
+}
+
+// speakerPalette colours speakers in -ttsSpeakerMap order (ASS &HBBGGRR&):
+// yellow, cyan, pink, light green, orange, lavender.
+var speakerPalette = []string{"&H00FFFF&", "&HFFFF00&", "&HCB8CFF&", "&H90EE90&", "&H00A5FF&", "&HFAE6E6&"}
+
+// speakerSpan is a stretch of the voice spoken by one character, in
+// centiseconds.
+type speakerSpan struct {
+	start, end int
+	color      string
+}
+
+// speakerSpans turns the part timeline into coloured spans, one colour
+// per name in names order. nil spans means a single part covering the
+// whole voice.
+func speakerSpans(parts []storyPart, spans []partSpan, names []string) []speakerSpan {
+	color := map[string]string{}
+	for i, n := range names {
+		color[n] = speakerPalette[i%len(speakerPalette)]
+	}
+	if spans == nil && len(parts) == 1 {
+		spans = []partSpan{{0, math.MaxInt32 / 100}}
+	}
+	var out []speakerSpan
+	for i, sp := range spans {
+		if c := color[parts[i].speaker]; c != "" {
+			out = append(out, speakerSpan{secToCS(sp.start), secToCS(sp.end), c})
+		}
+	}
+	return out
+}
+
+// colorSpeakers prefixes each dialogue line with the colour of the speaker
+// whose span contains the line's midpoint. It returns the lines changed.
+func colorSpeakers(d *assDoc, spans []speakerSpan) int {
+	n := 0
+	for _, i := range d.dialogues() {
+		ev := &d.events[i]
+		mid := (ev.start + ev.end) / 2
+		for _, s := range spans {
+			if mid >= s.start && mid < s.end {
+				ev.text = `{\1c` + s.color + `}` + ev.text
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+func applySpeakerColors(path string, spans []speakerSpan) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	n := colorSpeakers(d, spans)
+	if n == 0 {
+		return 0, nil
+	}
+	return n, writeASS(path, d)
+}
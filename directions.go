@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Stage directions: LLM-written stories carry notes like "[Narrator
+// pauses]" or "(sound of thunder)" that TTS would read aloud. With
+// -stripDirections they are cut from the spoken text; pause notes become
+// silence and sound cues can pull an effect from -sfxDir. Since they are
+// never spoken, the subtitles never contain them.
+
+var directionRe = regexp.MustCompile(`\[[^\[\]\n]*\]|\([^()\n]*\)`)
+
+var pauseWordRe = regexp.MustCompile(`(?i)\b(pause[sd]?|beat|silence|breath(es)?)\b`)
+
+const (
+	directionPause     = 0.8 // seconds for a plain pause note
+	directionLongPause = 2.0 // "long pause", "silence"
+)
+
+// direction records what happened to one stage direction.
+type direction struct {
+	raw    string
+	action string // "pause 0.8s", "sfx thunder.wav", "dropped"
+}
+
+// parseDirections removes bracketed and parenthesized notes from text and
+// returns the remaining story as parts with cues in between. sfx maps
+// lowercased keywords to effect files (nil -> no effects).
+func parseDirections(text string, sfx map[string]string) ([]storyPart, []direction) {
+	var parts []storyPart
+	var dirs []direction
+	addText := func(s string) { parts = appendText(parts, s) }
+	last := 0
+	for _, m := range directionRe.FindAllStringIndex(text, -1) {
+		addText(text[last:m[0]])
+		last = m[1]
+		raw := text[m[0]:m[1]]
+		inner := strings.ToLower(raw[1 : len(raw)-1])
+		cue := storyPart{}
+		var acts []string
+		if pauseWordRe.MatchString(inner) {
+			cue.pause = directionPause
+			if strings.Contains(inner, "long") || strings.Contains(inner, "silence") {
+				cue.pause = directionLongPause
+			}
+			acts = append(acts, fmt.Sprintf("pause %.1fs", cue.pause))
+		}
+		for _, w := range strings.FieldsFunc(inner, func(r rune) bool { return !isWordRune(r) }) {
+			if f, ok := sfx[w]; ok {
+				cue.sfx = f
+				acts = append(acts, "sfx "+filepath.Base(f))
+				break
+			}
+		}
+		if len(acts) == 0 {
+			dirs = append(dirs, direction{raw, "dropped"})
+			continue
+		}
+		dirs = append(dirs, direction{raw, strings.Join(acts, ", ")})
+		parts = append(parts, cue)
+	}
+	addText(text[last:])
+	return parts, dirs
+}
+
+// appendText adds s as a text part. A piece with no letters or digits is
+// stray punctuation left by a removed marker ("(pause)."), kept with the
+// preceding text instead of being synthesized alone.
+func appendText(parts []storyPart, s string) []storyPart {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return parts
+	}
+	if strings.IndexFunc(s, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+		for i := len(parts) - 1; i >= 0; i-- {
+			if parts[i].text != "" {
+				parts[i].text += s
+				break
+			}
+		}
+		return parts
+	}
+	return append(parts, storyPart{text: s})
+}
+
+// pauseMarkerRe matches explicit pause markers: [pause], [pause 1.5],
+// [pause 1.5s]. Unlike stage directions they are always honoured.
+var pauseMarkerRe = regexp.MustCompile(`(?i)\[\s*pause(?:\s+(\d+(?:\.\d*)?|\.\d+)\s*s?)?\s*\]`)
+
+// maxPause caps a single pause marker.
+const maxPause = 10.0
+
+// splitPauseMarkers cuts pause markers out of text and returns the text
+// around them as parts with silences in between. A bare [pause] lasts
+// def seconds. Longer pauses than maxPause are capped and their markers
+// returned in capped.
+func splitPauseMarkers(text string, def float64) (parts []storyPart, capped []string) {
+	last := 0
+	for _, m := range pauseMarkerRe.FindAllStringSubmatchIndex(text, -1) {
+		parts = appendText(parts, text[last:m[0]])
+		last = m[1]
+		d := def
+		if m[2] >= 0 {
+			d, _ = strconv.ParseFloat(text[m[2]:m[3]], 64)
+		}
+		if d > maxPause {
+			capped = append(capped, text[m[0]:m[1]])
+			d = maxPause
+		}
+		if d > 0 {
+			parts = append(parts, storyPart{pause: d})
+		}
+	}
+	return appendText(parts, text[last:]), capped
+}
+
+func isWordRune(r rune) bool {
+	return r == '-' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127
+}
+
+// loadSFX indexes the audio files in dir by lowercased base name, so
+// thunder.wav answers to "(sound of thunder)".
+func loadSFX(dir string) (map[string]string, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sfx := map[string]string{}
+	for _, e := range ents {
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".wav", ".mp3", ".ogg", ".flac", ".m4a", ".opus":
+		default:
+			continue
+		}
+		if e.IsDir() {
+			continue
+		}
+		sfx[strings.ToLower(strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())))] = filepath.Join(dir, e.Name())
+	}
+	return sfx, nil
+}
+
+// sfxCue is a sound effect placed on the voice timeline.
+type sfxCue struct {
+	path string
+	at   float64 // seconds from the start of the voice
+}
+
+// sfxCues places the effect of every part that has one at the part's
+// start. spans are synthesizeStory's; nil means no timeline, so no cues.
+func sfxCues(parts []storyPart, spans []partSpan) []sfxCue {
+	var cues []sfxCue
+	for i, sp := range spans {
+		if parts[i].sfx != "" {
+			cues = append(cues, sfxCue{path: parts[i].sfx, at: sp.start})
+		}
+	}
+	return cues
+}
+
+// mixSFX lays the cues over voice at vol into out. Effects are padded so
+// amix keeps a constant input count (and therefore constant gain, undone
+// by the final volume) for the whole voice.
+func mixSFX(ctx context.Context, voice string, cues []sfxCue, vol float64, out string, to time.Duration) error {
+	args := []string{"-y", "-v", "error", "-i", voice}
+	var fc strings.Builder
+	labels := "[0:a]"
+	for i, c := range cues {
+		args = append(args, "-i", c.path)
+		fmt.Fprintf(&fc, "[%d:a]volume=%g,adelay=%d:all=1,apad[s%d];", i+1, vol, int(c.at*1000+0.5), i)
+		labels += fmt.Sprintf("[s%d]", i)
+	}
+	n := len(cues) + 1
+	fmt.Fprintf(&fc, "%samix=inputs=%d:duration=first:dropout_transition=0,volume=%d[a]", labels, n, n)
+	args = append(args, "-filter_complex", fc.String(), "-map", "[a]", "-c:a", "pcm_s16le", out)
+	return runFFmpegErr(ctx, args, to)
+}
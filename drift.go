@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Subtitle drift check: whisper sometimes hallucinates a segment past the
+// end of the voice, leaving text over the final frames after the narration
+// has stopped, and a generator that gave up early leaves the end
+// uncaptioned. The last caption's end is compared with the voice length;
+// a late end beyond -subsDriftTolerance is clamped or fails the run as
+// -subsDriftAction says, and an early one is reported.
+
+// subsDriftActions are the -subsDriftAction modes.
+var subsDriftActions = map[string]bool{"clamp": true, "warn": true, "fail": true}
+
+// subsEarlyMin and subsEarlyFrac bound how much of the voice may go
+// uncaptioned at the end before it counts as early: the larger of the two.
+const (
+	subsEarlyMin  = 500 // cs
+	subsEarlyFrac = 0.2
+)
+
+// subsDrift is the result of the drift check; times in centiseconds.
+type subsDrift struct {
+	lastEnd, voice   int
+	tol              int
+	clamped, dropped int
+}
+
+func (s *subsDrift) late() bool { return s.lastEnd-s.voice > s.tol }
+
+func (s *subsDrift) early() bool {
+	return s.voice-s.lastEnd > max(subsEarlyMin, int(float64(s.voice)*subsEarlyFrac))
+}
+
+func (s *subsDrift) String() string {
+	msg := fmt.Sprintf("captions end at %s, voice at %s (%+.2fs)", fmtSec(float64(s.lastEnd)/100), fmtSec(float64(s.voice)/100), float64(s.lastEnd-s.voice)/100)
+	switch {
+	case s.clamped+s.dropped > 0:
+		msg += fmt.Sprintf("; %d cue(s) clamped, %d dropped", s.clamped, s.dropped)
+	case s.late():
+		msg += "; late"
+	case s.early():
+		msg += "; early"
+	default:
+		msg += "; ok"
+	}
+	return msg
+}
+
+// checkSubsDrift compares the captions of the ASS file at path with a
+// voice of voiceCS centiseconds. With clamp, when they end more than tolCS
+// late, captions starting after the voice are dropped and the rest cut at
+// its end.
+func checkSubsDrift(path string, voiceCS, tolCS int, clamp bool) (*subsDrift, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &subsDrift{voice: voiceCS, tol: tolCS}
+	for _, i := range d.dialogues() {
+		s.lastEnd = max(s.lastEnd, d.events[i].end)
+	}
+	if !clamp || !s.late() {
+		return s, nil
+	}
+	kept := d.events[:0]
+	for _, ev := range d.events {
+		switch {
+		case ev.kind != "Dialogue" || ev.end <= voiceCS:
+		case ev.start >= voiceCS:
+			s.dropped++
+			continue
+		default:
+			ev.end = voiceCS
+			s.clamped++
+		}
+		kept = append(kept, ev)
+	}
+	d.events = kept
+	return s, writeASS(path, d)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Custom fonts (-fontsDir): the ass filter is pointed at a directory of
+// font files so libass finds fonts that are not installed. Font families
+// are read from each file's name table, so a -subFont the directory does
+// not provide is reported before libass quietly substitutes another.
+
+// fontExts are the font files libass loads from a fontsdir.
+var fontExts = map[string]bool{".ttf": true, ".otf": true, ".ttc": true, ".otc": true}
+
+// filterArg escapes s for use as an option value inside a filtergraph:
+// first for the option parser (\ ' :), then for the graph parser, which
+// also splits on [ ] , and ;.
+func filterArg(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(s)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(r)
+}
+
+// filterSafe reports whether filterArg can carry path: control characters
+// and invalid UTF-8 do not survive the filtergraph parser, which also
+// trims spaces at either end of a value.
+func filterSafe(path string) bool {
+	if !utf8.ValidString(path) || strings.TrimSpace(path) != path {
+		return false
+	}
+	return !strings.ContainsFunc(path, unicode.IsControl)
+}
+
+// assFilter is the filter that burns the subtitles at path, with fonts
+// from fontsDir when it is not empty.
+func assFilter(path, fontsDir string) string {
+	f := "ass=filename=" + filterArg(path)
+	if fontsDir != "" {
+		f += ":fontsdir=" + filterArg(fontsDir)
+	}
+	return f
+}
+
+// fontFiles lists the font files directly in dir.
+func fontFiles(dir string) ([]string, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range ents {
+		if !e.IsDir() && fontExts[strings.ToLower(filepath.Ext(e.Name()))] {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s has no .ttf/.otf/.ttc fonts", dir)
+	}
+	return files, nil
+}
+
+// fontFamilies returns the family names (name IDs 1 and 16) of the fonts
+// in a .ttf/.otf file, or of every font of a collection.
+func fontFamilies(b []byte) ([]string, error) {
+	if len(b) < 12 {
+		return nil, fmt.Errorf("too short for a font")
+	}
+	if string(b[:4]) != "ttcf" {
+		return sfntFamilies(b, 0)
+	}
+	n := int(binary.BigEndian.Uint32(b[8:]))
+	if len(b) < 12+4*n {
+		return nil, fmt.Errorf("truncated font collection")
+	}
+	var names []string
+	for i := 0; i < n; i++ {
+		fams, err := sfntFamilies(b, int(binary.BigEndian.Uint32(b[12+4*i:])))
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, fams...)
+	}
+	return names, nil
+}
+
+// sfntFamilies reads the family names from the name table of the font
+// whose offset table starts at off.
+func sfntFamilies(b []byte, off int) ([]string, error) {
+	if off < 0 || len(b) < off+12 {
+		return nil, fmt.Errorf("truncated font")
+	}
+	numTables := int(binary.BigEndian.Uint16(b[off+4:]))
+	for i := 0; i < numTables; i++ {
+		rec := off + 12 + 16*i
+		if len(b) < rec+16 {
+			return nil, fmt.Errorf("truncated table directory")
+		}
+		if string(b[rec:rec+4]) != "name" {
+			continue
+		}
+		start := int(binary.BigEndian.Uint32(b[rec+8:]))
+		length := int(binary.BigEndian.Uint32(b[rec+12:]))
+		if start < 0 || length < 6 || len(b) < start+length {
+			return nil, fmt.Errorf("truncated name table")
+		}
+		return nameTableFamilies(b[start : start+length])
+	}
+	return nil, fmt.Errorf("no name table")
+}
+
+func nameTableFamilies(t []byte) ([]string, error) {
+	count := int(binary.BigEndian.Uint16(t[2:]))
+	strs := int(binary.BigEndian.Uint16(t[4:]))
+	seen := map[string]bool{}
+	var names []string
+	for i := 0; i < count; i++ {
+		rec := 6 + 12*i
+		if len(t) < rec+12 {
+			return nil, fmt.Errorf("truncated name record")
+		}
+		platform := binary.BigEndian.Uint16(t[rec:])
+		id := binary.BigEndian.Uint16(t[rec+6:])
+		length := int(binary.BigEndian.Uint16(t[rec+8:]))
+		at := strs + int(binary.BigEndian.Uint16(t[rec+10:]))
+		if (id != 1 && id != 16) || len(t) < at+length {
+			continue
+		}
+		raw := t[at : at+length]
+		var name string
+		switch platform {
+		case 0, 3: // Unicode, Windows: UTF-16BE
+			u := make([]uint16, len(raw)/2)
+			for j := range u {
+				u[j] = binary.BigEndian.Uint16(raw[2*j:])
+			}
+			name = string(utf16.Decode(u))
+		case 1: // Macintosh Roman; family names are ASCII in practice
+			name = string(raw)
+		default:
+			continue
+		}
+		if name = strings.TrimSpace(name); name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// fontsDirFamilies collects the family names of the fonts in files. Files
+// that cannot be read are skipped with a warning; libass would skip them
+// too.
+func fontsDirFamilies(files []string) map[string]bool {
+	fams := map[string]bool{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err == nil {
+			var names []string
+			if names, err = fontFamilies(b); err == nil {
+				for _, n := range names {
+					fams[strings.ToLower(n)] = true
+				}
+				continue
+			}
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: -fontsDir: %s: %v\n", f, err)
+	}
+	return fams
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Hallucination filter: in music-only padding and long pauses whisper
+// invents text ("thanks for watching"). The voice WAV is run through
+// silencedetect and a generated caption lying mostly in silence is dropped,
+// as is any caption made up entirely of a -hallucinationPhrases entry.
+// -keepHallucinations turns the filter off.
+
+const (
+	silenceNoiseDb = -45 // dBFS below which the voice counts as silent
+	silenceMinDur  = 0.6 // seconds; shorter gaps are just between words
+	// hallucinationOverlap is the share of a caption inside silence that
+	// gets it dropped.
+	hallucinationOverlap = 0.8
+	// A single word's end often stretches into the pause after it, so only
+	// its first wordOnsetCS centiseconds, where it is spoken, are checked.
+	wordOnsetCS = 40
+)
+
+// builtinHallucinations are phrases whisper is known to make up over
+// silence, for -hallucinationPhrases builtin.
+var builtinHallucinations = []string{
+	"thanks for watching",
+	"thank you for watching",
+	"please subscribe",
+	"like and subscribe",
+	"don't forget to subscribe",
+	"subtitles by the amara.org community",
+	"transcribed by",
+	"see you in the next video",
+}
+
+// silenceSpan is a silent stretch of the voice, in centiseconds.
+type silenceSpan struct{ start, end int }
+
+var (
+	silenceStartRe = regexp.MustCompile(`silence_start:\s*(-?[0-9.]+)`)
+	silenceEndRe   = regexp.MustCompile(`silence_end:\s*([0-9.]+)`)
+)
+
+// detectSilences returns the silent stretches of the audio at path; one
+// still open at the end runs to endCS.
+func detectSilences(ctx context.Context, path string, endCS int, to time.Duration) ([]silenceSpan, error) {
+	ctx, cancel := stageContext(ctx, to)
+	defer cancel()
+	cmd := newCommand(ctx, "ffmpeg",
+		"-hide_banner", "-nostats", "-i", path,
+		"-af", fmt.Sprintf("silencedetect=n=%ddB:d=%g", silenceNoiseDb, silenceMinDur),
+		"-f", "null", "-",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, stageError(ctx, "silencedetect", to, err, "")
+	}
+	return parseSilences(string(out), endCS), nil
+}
+
+// parseSilences reads silencedetect's log.
+func parseSilences(log string, endCS int) []silenceSpan {
+	var spans []silenceSpan
+	open := -1
+	for _, line := range strings.Split(log, "\n") {
+		if m := silenceStartRe.FindStringSubmatch(line); m != nil {
+			v, _ := strconv.ParseFloat(m[1], 64)
+			open = max(0, secToCS(v))
+		} else if m := silenceEndRe.FindStringSubmatch(line); m != nil && open >= 0 {
+			v, _ := strconv.ParseFloat(m[1], 64)
+			spans = append(spans, silenceSpan{open, secToCS(v)})
+			open = -1
+		}
+	}
+	if open >= 0 && open < endCS {
+		spans = append(spans, silenceSpan{open, endCS})
+	}
+	return spans
+}
+
+// readHallucinationPhrases reads one phrase per line (# starts a comment),
+// or returns the built-in list for "builtin".
+func readHallucinationPhrases(path string) ([][]string, error) {
+	if path == "builtin" {
+		return phraseWords(builtinHallucinations), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	phrases := phraseWords(lines)
+	if len(phrases) == 0 {
+		return nil, fmt.Errorf("%s: no phrases", path)
+	}
+	return phrases, nil
+}
+
+func phraseWords(lines []string) [][]string {
+	var phrases [][]string
+	for _, l := range lines {
+		if ws := matchWords(l); len(ws) > 0 {
+			phrases = append(phrases, ws)
+		}
+	}
+	return phrases
+}
+
+// matchWords lowercases the words of s and trims punctuation from them,
+// so "Thanks," matches "thanks".
+func matchWords(s string) []string {
+	var ws []string
+	for _, f := range strings.Fields(strings.ToLower(s)) {
+		if w := strings.TrimFunc(f, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsNumber(r) }); w != "" {
+			ws = append(ws, w)
+		}
+	}
+	return ws
+}
+
+// droppedCue is a caption the filter removed and why.
+type droppedCue struct {
+	start, end int
+	text, why  string
+}
+
+func (c droppedCue) String() string {
+	return fmt.Sprintf("%s-%s %q (%s)", formatASSTime(c.start), formatASSTime(c.end), c.text, c.why)
+}
+
+// silentShare is the share of [start, end) covered by silences.
+func silentShare(start, end int, silences []silenceSpan) float64 {
+	if end <= start {
+		return 0
+	}
+	quiet := 0
+	for _, s := range silences {
+		quiet += max(0, min(end, s.end)-max(start, s.start))
+	}
+	return float64(quiet) / float64(end-start)
+}
+
+// filterHallucinations drops the Dialogue events of d that lie mostly in
+// silences or consist only of words of a phrase match, and returns them
+// in time order.
+func filterHallucinations(d *assDoc, silences []silenceSpan, phrases [][]string) []droppedCue {
+	idx := d.dialogues()
+	sort.SliceStable(idx, func(a, b int) bool { return d.events[idx[a]].start < d.events[idx[b]].start })
+
+	// the words of all captions in time order, each with its event
+	type token struct {
+		word string
+		ev   int
+	}
+	var tokens []token
+	count := map[int]int{}
+	for _, i := range idx {
+		for _, w := range matchWords(plainText(d.events[i].text)) {
+			tokens = append(tokens, token{w, i})
+			count[i]++
+		}
+	}
+	matched := map[int]int{}
+	for k := range tokens {
+		for _, p := range phrases {
+			if k+len(p) > len(tokens) {
+				continue
+			}
+			ok := true
+			for j, w := range p {
+				if tokens[k+j].word != w {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				for j := range p {
+					matched[tokens[k+j].ev]++
+				}
+			}
+		}
+	}
+
+	drop := map[int]string{}
+	for _, i := range idx {
+		ev := &d.events[i]
+		end := ev.end
+		if count[i] == 1 {
+			end = min(end, ev.start+wordOnsetCS)
+		}
+		switch {
+		case count[i] > 0 && matched[i] >= count[i]:
+			drop[i] = "known phrase"
+		case silentShare(ev.start, end, silences) >= hallucinationOverlap:
+			drop[i] = "voice silent"
+		}
+	}
+	if len(drop) == 0 {
+		return nil
+	}
+	var dropped []droppedCue
+	for _, i := range idx {
+		if why, ok := drop[i]; ok {
+			ev := d.events[i]
+			dropped = append(dropped, droppedCue{ev.start, ev.end, strings.TrimSpace(plainText(ev.text)), why})
+		}
+	}
+	kept := d.events[:0]
+	for i, ev := range d.events {
+		if _, ok := drop[i]; !ok {
+			kept = append(kept, ev)
+		}
+	}
+	d.events = kept
+	return dropped
+}
+
+func applyHallucinationFilter(path string, silences []silenceSpan, phrases [][]string) ([]droppedCue, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return nil, err
+	}
+	dropped := filterHallucinations(d, silences, phrases)
+	if len(dropped) == 0 {
+		return nil, nil
+	}
+	return dropped, writeASS(path, d)
+}
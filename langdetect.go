@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Language detection for XTTS when -ttsLang is empty. Scripts other than
+// Latin decide on their own; Latin-script text is compared by character
+// trigrams against small profiles built from each language's most common
+// words. Between them, langScripts and langSamples cover the XTTS v2
+// languages and nothing else.
+
+// langScripts maps a script to the XTTS language written in it. Japanese
+// text mixes kana with Han, so kana is checked first.
+var langScripts = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh-cn"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Devanagari, "hi"},
+}
+
+// langSamples are the frequent words the Latin-script profiles are built
+// from.
+var langSamples = map[string]string{
+	"en": "the and of to in is that it was for on are with as his they at be this from have or by one had not but what all were when we there can an your which their said if do will each about how up out them then she many some so these would other into has more her two like him see time could no make than first been its who now people my made over did down only way find use may water long little very after words called just where most know",
+	"es": "el la de que y a en un ser se no haber por con su para como estar tener le lo todo pero más hacer o poder decir este ir otro ese si me ya ver porque dar cuando él muy sin vez mucho saber qué sobre mi alguno mismo yo también hasta año dos querer entre así primero desde grande eso ni nos llegar pasar tiempo ella sí día uno bien poco deber entonces poner cosa tanto hombre parecer nuestro tan donde ahora parte después vida quedar siempre creer hablar llevar dejar nada cada seguir menos nuevo encontrar los las del al una es era está hay fue había son tiene hace puede dijo quería estaba casa noche mañana nadie algo usted ellos nosotros otra cómo porque canción",
+	"fr": "le de un être et à il avoir ne je son que se qui ce dans en du elle au pour pas vous par sur faire plus dire me on mon lui nous comme mais pouvoir avec tout y aller voir bien où sans tu ou leur homme si deux moi vouloir te femme venir quand grand celui notre devoir là jour prendre même votre rien petit encore aussi quelque dont trouver donner temps ça peu falloir sous parler alors les des est une était ont été cette",
+	"de": "der die und in den von zu das mit sich des auf für ist im dem nicht ein eine als auch es an werden aus er hat dass sie nach wird bei einer um am sind noch wie einem über einen so zum war haben nur oder aber vor zur bis mehr durch man sein wurde sei ich wir ihr schon wenn kann gegen vom können jetzt immer unter zeit mann frau gehen sehen machen",
+	"it": "il di che e la a un in essere avere non per una si mi ma lo con ci le da come io questo suo anche fare quello tutto più se ne sono del della dei alla molto bene ancora così quando dove perché chi stato fatto cosa tempo sempre prima dopo niente ora qui proprio gli era hanno",
+	"pt": "o de que e a do da em um para é com não uma os no se na por mais as dos como mas foi ao ele das tem à seu sua ou ser quando muito há nos já está eu também só pelo pela até isso ela entre era depois sem mesmo aos ter seus quem nas me esse eles estão você tinha foram essa num nem suas meu às minha têm numa pelos elas havia seja qual será nós tenho lhe deles essas esses pelas este fosse dele então",
+	"pl": "i w nie na się z że do to jest o jak ale co tak za od po już tylko jego ich może jej przez był być bardzo kiedy dla go mnie było też czy także przed pan pani tym jednak które która który więc gdy bo tego tej nawet jestem jeszcze gdzie teraz wszystko",
+	"tr": "ve bir bu da de için ne ile çok daha gibi ama o en kadar sonra var ben sen onun olan olarak değil mi her şey yok ya diye bunu şimdi neden nasıl zaman önce bile büyük iyi yeni ilk oldu olduğu gün benim onu bana geldi",
+	"nl": "de en van ik te dat die in een hij het niet zijn is was op aan met als voor had er maar om hem dan zou of wat mijn men dit zo door over ze zich bij ook tot je mij uit daar haar naar heb hoe heeft hebben deze want nog zal zij nu geen omdat iets worden toch al waren veel meer doen toen moet ben zonder kan hun dus alles onder eens hier wie werd altijd wordt kunnen ons zelf tegen niets iemand geweest andere",
+	"cs": "a se na v je že s to z o do jsem ale jako by k pro tak co jsou jeho jak už ve tím byl po není když nebo jen i od mi jsme bylo jí být které který která ještě také tom před mě při než kde až proto velmi všechno",
+	"hu": "a az és hogy nem is egy de meg van már csak el mint ki még volt azt ha vagy mert ez sem lesz ezt most nagyon itt kell minden amit akkor lehet után neki olyan mindig hol hogyan miért ember idő volna lett",
+}
+
+// langProfiles holds the normalized trigram vector of each sample.
+var langProfiles = func() map[string]map[string]float64 {
+	p := make(map[string]map[string]float64, len(langSamples))
+	for lang, s := range langSamples {
+		p[lang] = trigramVector(s)
+	}
+	return p
+}()
+
+// langLetters are letters only one of the Latin-script languages uses;
+// each occurrence adds langLetterBonus to that language's score.
+var langLetters = map[rune]string{
+	'ě': "cs", 'ř': "cs", 'ů': "cs",
+	'ą': "pl", 'ę': "pl", 'ł': "pl", 'ś': "pl", 'ź': "pl", 'ż': "pl", 'ń': "pl",
+	'ő': "hu", 'ű': "hu",
+	'ğ': "tr", 'ı': "tr", 'ş': "tr",
+	'ñ': "es", 'ã': "pt", 'õ': "pt", 'ß': "de",
+}
+
+const (
+	langLetterBonus = 0.02
+	langMaxBonus    = 0.2
+	langMinLetters  = 20   // less text than this is never confident
+	langMinMargin   = 0.03 // cosine lead the best profile needs over the next
+)
+
+// detectLanguage guesses the XTTS language of text. ok is false when the
+// guess is not confident.
+func detectLanguage(text string) (lang string, ok bool) {
+	counts := map[string]int{}
+	bonus := map[string]float64{}
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if l, ok := langLetters[unicode.ToLower(r)]; ok {
+			bonus[l] = min(bonus[l]+langLetterBonus, langMaxBonus)
+		}
+		for _, sc := range langScripts {
+			if unicode.Is(sc.table, r) {
+				counts[sc.lang]++
+				break
+			}
+		}
+	}
+	if letters < langMinLetters {
+		return "en", false
+	}
+	if counts["ja"] > 0 && counts["ja"]+counts["zh-cn"] > letters/2 {
+		return "ja", true
+	}
+	for _, sc := range langScripts {
+		if counts[sc.lang] > letters/2 {
+			return sc.lang, true
+		}
+	}
+
+	v := trigramVector(text)
+	type score struct {
+		lang string
+		cos  float64
+	}
+	var scores []score
+	for lang, p := range langProfiles {
+		var dot float64
+		for g, w := range v {
+			dot += w * p[g]
+		}
+		scores = append(scores, score{lang, dot + bonus[lang]})
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].cos > scores[j].cos })
+	return scores[0].lang, scores[0].cos-scores[1].cos >= langMinMargin
+}
+
+// trigramVector returns the unit-length trigram counts of s's words, each
+// padded with a space on both sides.
+func trigramVector(s string) map[string]float64 {
+	v := map[string]float64{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		r := []rune(" " + w + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			v[string(r[i:i+3])]++
+		}
+	}
+	var norm float64
+	for _, c := range v {
+		norm += c * c
+	}
+	norm = math.Sqrt(norm)
+	for g := range v {
+		v[g] /= norm
+	}
+	return v
+}
+
+// isXTTSModel reports whether a Coqui model name is an XTTS model.
+func isXTTSModel(model string) bool {
+	return strings.Contains(strings.ToLower(model), "xtts")
+}
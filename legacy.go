@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Legacy flag spellings. When a flag's meaning moves to a new name, the
+// old spelling keeps working: its value is copied to the new flag (unless
+// that was given too) and a deprecation warning names the replacement.
+// -strictFlags turns the warning into an error for CI.
+
+type legacyFlag struct {
+	old, new string
+	// unless names a boolean flag under which the old spelling is still
+	// the right one, e.g. -gpuCQ with -useGPU. The value is still copied
+	// so fallbacks behave as before.
+	unless string
+}
+
+var legacyFlags = []legacyFlag{
+	{old: "gpuCQ", new: "crf", unless: "useGPU"}, // -gpuCQ used to set libx264's -crf too
+}
+
+// applyLegacyFlags maps deprecated spellings onto their replacements after
+// flag.Parse. It returns an error only in strict mode.
+func applyLegacyFlags(strict bool) error {
+	for _, l := range legacyFlags {
+		if !flagSet(l.old) || flagSet(l.new) {
+			continue
+		}
+		val := flag.Lookup(l.old).Value.String()
+		if err := flag.Set(l.new, val); err != nil {
+			return fmt.Errorf("-%s=%s: %v", l.old, val, err)
+		}
+		if l.unless != "" && flag.Lookup(l.unless).Value.String() == "true" {
+			continue
+		}
+		msg := fmt.Sprintf("-%s is deprecated here; use -%s=%s", l.old, l.new, val)
+		if strict {
+			return fmt.Errorf("%s (-strictFlags)", msg)
+		}
+		fmt.Fprintln(os.Stderr, "DEPRECATED:", msg)
+	}
+	return nil
+}
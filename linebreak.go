@@ -0,0 +1,323 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Line breaking (-subMaxChars): a caption wider than a line gets a \N at
+// the best break, preferring a space or CJK mark after punctuation, then
+// any space or any point between CJK characters (which have no spaces)
+// other than before a closing mark, and only then after a hyphen or slash
+// inside a long token such as a URL. Words are never cut. A caption that
+// will not fit in two lines is split in time into several, each shown for
+// its share of the characters.
+//
+// "Characters" approximate width: wide (CJK) characters count double, and
+// a line must also fit across PlayResX between the margins, measured with
+// textEm at the style's font size.
+
+// breakAfterChars end a clause; a space after one is the best break.
+const breakAfterChars = ".,;:!?…"
+
+// noBreakAfter are CJK opening marks that must not end a line; closing
+// marks (isClosingPunct) must not start one.
+const noBreakAfter = "（「『【〔〈《"
+
+// cjkBreakAfter end a CJK clause; a break after one is the best.
+const cjkBreakAfter = "、。，！？；："
+
+type visRune struct {
+	r   rune
+	pos int // byte offset in the event text
+}
+
+// visibleRunes returns the characters of an event's text outside override
+// blocks, with \h as a space.
+func visibleRunes(text string) []visRune {
+	var vr []visRune
+	for i := 0; i < len(text); {
+		switch {
+		case text[i] == '{':
+			if j := strings.IndexByte(text[i:], '}'); j >= 0 {
+				i += j + 1
+				continue
+			}
+		case text[i] == '\\' && i+1 < len(text) && text[i+1] == 'h':
+			vr = append(vr, visRune{' ', i})
+			i += 2
+			continue
+		}
+		r, n := utf8.DecodeRuneInString(text[i:])
+		vr = append(vr, visRune{r, i})
+		i += n
+	}
+	return vr
+}
+
+func runesWidth(vr []visRune) int {
+	w := 0
+	for _, v := range vr {
+		if isWideRune(v.r) {
+			w += 2
+		} else {
+			w++
+		}
+	}
+	return w
+}
+
+// trimVis drops spaces at both ends.
+func trimVis(vr []visRune) []visRune {
+	for len(vr) > 0 && vr[0].r == ' ' {
+		vr = vr[1:]
+	}
+	for len(vr) > 0 && vr[len(vr)-1].r == ' ' {
+		vr = vr[:len(vr)-1]
+	}
+	return vr
+}
+
+// lineBreak is a place to end the first line: it ends before vr[cut] and
+// the second starts at vr[cut+skip].
+type lineBreak struct {
+	cut, skip int
+	rank      int // lower is better
+}
+
+func breakCandidates(vr []visRune) []lineBreak {
+	var cands []lineBreak
+	for i := 1; i < len(vr)-1; i++ {
+		prev, r := vr[i-1].r, vr[i].r
+		switch {
+		case r == ' ' && strings.ContainsRune(breakAfterChars, prev):
+			cands = append(cands, lineBreak{i, 1, 0})
+		case r == ' ':
+			cands = append(cands, lineBreak{i, 1, 1})
+		case (isWideRune(prev) || isWideRune(r)) && prev != ' ' && !isClosingPunct(r) &&
+			!strings.ContainsRune(noBreakAfter, prev):
+			rank := 1
+			if strings.ContainsRune(cjkBreakAfter, prev) {
+				rank = 0
+			}
+			cands = append(cands, lineBreak{i, 0, rank})
+		case (prev == '-' || prev == '/') && unicode.IsLetter(r) && i > 1 && prev != vr[i-2].r:
+			cands = append(cands, lineBreak{i, 0, 2})
+		}
+	}
+	return cands
+}
+
+// lineFit is what a caption line may hold: chars characters (wide ones
+// counting double) and, when the style's font is known, em ems of textEm
+// width.
+type lineFit struct {
+	chars int
+	em    float64 // 0 -> no width limit
+}
+
+// fits reports whether vr, without its outer spaces, fits one line.
+func (f lineFit) fits(vr []visRune) bool {
+	vr = trimVis(vr)
+	if runesWidth(vr) > f.chars {
+		return false
+	}
+	if f.em <= 0 {
+		return true
+	}
+	w := 0.0
+	for _, v := range vr {
+		w += runeEm(v.r)
+	}
+	return w <= f.em
+}
+
+// wideRunes is how many wide characters fit one line.
+func (f lineFit) wideRunes() int {
+	n := f.chars / 2
+	if f.em > 0 {
+		n = min(n, int(f.em)) // a wide character is one em
+	}
+	return max(1, n)
+}
+
+// bestBreak returns the best break leaving both lines within fit, or
+// false.
+func bestBreak(vr []visRune, fit lineFit) (lineBreak, bool) {
+	var best lineBreak
+	bestScore, found := 0, false
+	for _, c := range breakCandidates(vr) {
+		first, second := vr[:c.cut], vr[c.cut+c.skip:]
+		if !fit.fits(first) || !fit.fits(second) {
+			continue
+		}
+		score := c.rank*1000 + abs(runesWidth(trimVis(first))-runesWidth(trimVis(second)))
+		if !found || score < bestScore {
+			best, bestScore, found = c, score, true
+		}
+	}
+	return best, found
+}
+
+// breakText breaks an event's text into at most two lines within fit. ok
+// is false when two lines are not enough.
+func breakText(text string, fit lineFit) (string, bool) {
+	vr := visibleRunes(text)
+	if fit.fits(vr) {
+		return text, true
+	}
+	c, ok := bestBreak(vr, fit)
+	if !ok {
+		return text, false
+	}
+	at := vr[c.cut].pos
+	rest := at
+	if c.skip > 0 {
+		rest = vr[c.cut+c.skip].pos
+		if end := at + len(string(vr[c.cut].r)); end < rest {
+			rest = end // keep override blocks after the space
+		}
+		if text[at] == '\\' {
+			rest = at + 2 // \h
+		}
+	}
+	return text[:at] + `\N` + text[rest:], true
+}
+
+// splitChunks cuts plain text into pieces that each fit two lines within
+// fit, at spaces or between wide characters.
+func splitChunks(text string, fit lineFit) []string {
+	var tokens []string
+	for _, f := range strings.Fields(text) {
+		rs := []rune(f)
+		if fit.fits(visibleRunes(f)) || !isWideRune(rs[0]) {
+			tokens = append(tokens, f)
+			continue
+		}
+		for len(rs) > 0 { // a run of CJK: cut between characters
+			n := min(len(rs), fit.wideRunes())
+			for n < len(rs) && isClosingPunct(rs[n]) {
+				n++
+			}
+			tokens = append(tokens, string(rs[:n]))
+			rs = rs[n:]
+		}
+	}
+	var chunks []string
+	cur := ""
+	for _, t := range tokens {
+		next := t
+		if cur != "" {
+			sep := " "
+			if isWideRune(lastRuneOf(cur)) && isWideRune([]rune(t)[0]) {
+				sep = ""
+			}
+			next = cur + sep + t
+		}
+		if _, ok := breakText(next, fit); ok || cur == "" {
+			cur = next
+			continue
+		}
+		chunks = append(chunks, cur)
+		cur = t
+	}
+	if cur != "" {
+		chunks = append(chunks, cur)
+	}
+	return chunks
+}
+
+func lastRuneOf(s string) rune {
+	rs := []rune(s)
+	return rs[len(rs)-1]
+}
+
+// lineFit is the line limit for events of style: maxChars, and the width
+// between the style's margins in ems of its font size.
+func (d *assDoc) lineFit(style string, maxChars int) lineFit {
+	fit := lineFit{chars: maxChars}
+	w, _ := d.playRes()
+	size, _ := strconv.ParseFloat(d.styleField(style, "Fontsize"), 64)
+	l, _ := strconv.Atoi(d.styleField(style, "MarginL"))
+	r, _ := strconv.Atoi(d.styleField(style, "MarginR"))
+	if size > 0 && w-l-r > 0 {
+		fit.em = float64(w-l-r) / size
+	}
+	return fit
+}
+
+// applyLineBreaks breaks the captions of the ASS file at path and returns
+// how many were broken and how many were split in time. The title card
+// (layer 1) and text already broken with \N are left alone, and only
+// captions with nothing but leading override tags are split in time.
+func applyLineBreaks(path string, maxChars int) (broken, split int, err error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var out []assEvent
+	for _, ev := range d.events {
+		// a -subsSecondary translation line is kept as is; only the
+		// primary text before it is broken, never split in time
+		primary, secondary := ev.text, ""
+		if k := strings.Index(ev.text, secondaryTag); k >= 0 {
+			primary, secondary = ev.text[:k], ev.text[k:]
+		}
+		if ev.kind != "Dialogue" || strings.Contains(primary, `\N`) ||
+			(ev.get(d, "Layer") != "" && ev.get(d, "Layer") != "0") {
+			out = append(out, ev)
+			continue
+		}
+		fit := d.lineFit(ev.get(d, "Style"), maxChars)
+		if secondary != "" {
+			if text, ok := breakText(primary, fit); ok && text != primary {
+				ev.text = text + secondary
+				broken++
+			}
+			out = append(out, ev)
+			continue
+		}
+		text, ok := breakText(ev.text, fit)
+		if ok {
+			if text != ev.text {
+				broken++
+			}
+			ev.text = text
+			out = append(out, ev)
+			continue
+		}
+		lead := leadingTagsRe.FindString(ev.text)
+		body := ev.text[len(lead):]
+		if strings.Contains(body, "{") {
+			out = append(out, ev) // inline tags: cannot be cut safely
+			continue
+		}
+		chunks := splitChunks(strings.ReplaceAll(body, `\h`, " "), fit)
+		total := 0
+		for _, c := range chunks {
+			total += len([]rune(c))
+		}
+		start, done := ev.start, 0
+		for k, c := range chunks {
+			done += len([]rune(c))
+			part := ev
+			part.fields = append([]string(nil), ev.fields...)
+			part.start = start
+			part.end = ev.start + (ev.end-ev.start)*done/total
+			if k == len(chunks)-1 {
+				part.end = ev.end
+			}
+			part.text, _ = breakText(lead+c, fit)
+			out = append(out, part)
+			start = part.end
+		}
+		split++
+	}
+	d.events = out
+	if broken+split == 0 {
+		return 0, 0, nil
+	}
+	return broken, split, writeASS(path, d)
+}
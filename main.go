@@ -1,6 +1,11 @@
 // avmux — synthesize TTS, generate word-level ASS, burn subs, and mux with bgm/video.
 // Adds XTTS support: -ttsLang and -ttsSpeakerWav are forwarded to Coqui TTS CLI.
 //
+// Subcommands:
+//
+//	avmux prefetch [flags]   download the configured TTS/whisper models ahead of time
+//	avmux watermark detect <file>   report the -audioWatermark ID embedded in a file
+//
 // Build: go build -o avmux .
 // Version inject: -ldflags "-X main.build=YYYYMMDDHHMMSS"
 package main
@@ -11,13 +16,20 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
 var build string // injected via -ldflags "-X main.build=YYYYMMDDHHMMSS"
@@ -26,12 +38,20 @@ func main() {
 	// Required I/O
 	video := flag.String("video", "", "background video file (required)")
 	out := flag.String("out", "out.mp4", "output file")
+	publishDir := flag.String("publishDir", "", "stage outputs and move them here together, then write <out>.done (empty -> write in place)")
 
 	// Background music (required)
 	music := flag.String("music", "", "background music file (required)")
 	musicVol := flag.Float64("musicVol", 0.25, "linear gain for music (e.g. 0.25)")
 	voiceVol := flag.Float64("voiceVol", 1.00, "linear gain for voice (e.g. 1.0)")
 	musicLoop := flag.Bool("musicLoop", true, "loop background music to cover voice duration")
+	voiceDelay := flag.Float64("voiceDelay", 0, "seconds of video+music before the narration starts (subtitles shift too)")
+	musicEQ := flag.String("musicEQ", "", "music EQ preset: speechcarve (dip 2-4kHz under the voice) or empty")
+	maskCheck := flag.Bool("maskCheck", true, "measure how much the music masks the voice's 1-4kHz band and advise")
+	maskThreshold := flag.Float64("maskThreshold", 0.35, "masking score (0..1) above which the mix advice is printed")
+	audioWatermark := flag.Bool("audioWatermark", false, "embed an inaudible identifier in the final audio (check with `avmux watermark detect`)")
+	watermarkID := flag.String("watermarkID", "", "identifier to embed (default: fingerprint of story text and -out name)")
+	watermarkKey := flag.String("watermarkKey", wmDefaultKey, "secret the watermark pattern is derived from; detection needs the same key")
 
 	// Randomized offsets
 	videoStart := flag.Float64("videoStart", -1, "video start offset in seconds; -1 -> auto")
@@ -39,6 +59,9 @@ func main() {
 	randVideo := flag.Bool("randVideo", true, "randomize video start when -videoStart < 0")
 	randMusic := flag.Bool("randMusic", true, "randomize music start when -musicStart < 0")
 	seed := flag.Int64("seed", 0, "PRNG seed; 0 -> time-based")
+	startCheck := flag.Bool("startCheck", false, "skip black/frozen openings by nudging the video start forward")
+	startCheckStep := flag.Float64("startCheckStep", 1.5, "seconds to move the video start per failed check")
+	startCheckTries := flag.Int("startCheckTries", 4, "max start checks before accepting the offset")
 
 	timeout := flag.Duration("timeout", 0, "overall timeout (e.g. 5m)")
 
@@ -46,30 +69,262 @@ func main() {
 	useGPU := flag.Bool("useGPU", false, "use NVIDIA NVENC")
 	gpuPreset := flag.String("gpuPreset", "p1", "NVENC preset p1..p7 (p7=slow)")
 	gpuRC := flag.String("gpuRC", "vbr_hq", "NVENC rc: vbr|vbr_hq|constqp")
-	gpuCQ := flag.String("gpuCQ", "19", "quality: vbr/vbr_hq -> -cq, constqp -> -qp (0..51)")
+	gpuCQ := flag.String("gpuCQ", "19", "NVENC quality: vbr/vbr_hq -> -cq, constqp -> -qp (0..51)")
+	crf := flag.String("crf", "19", "libx264 -crf when not encoding on the GPU (0..51)")
+	strictFlags := flag.Bool("strictFlags", false, "treat deprecated flag spellings as errors")
 
 	// Subtitles (always generate + burn)
 	assOut := flag.String("assOut", "", "where to write the generated ASS (default: next to -out)")
+	assTemplateFile := flag.String("assTemplate", "", "house-style ASS whose [Script Info] and styles are kept verbatim, with the captions put into it (replaces the -sub* style flags)")
+	subStyleName := flag.String("subStyleName", "", "with -assTemplate: the template style captions use (empty -> its first)")
+	subSmoothing := flag.String("subSmoothing", "on", "smooth jittery word timings within phrases: on|off")
+	subMinDuration := flag.Float64("subMinDuration", 0.12, "shortest time a caption is shown, in seconds: shorter ones take the following silence or join the next caption (0 -> off)")
+	subFont := flag.String("subFont", "", "subtitle font name (rewrites the ASS styles; unset -> generator default)")
+	fontsDir := flag.String("fontsDir", "", "directory of .ttf/.otf fonts libass loads when burning, for fonts not installed system-wide")
+	subSize := flag.Float64("subSize", 0, "subtitle font size in PlayRes units")
+	subPrimaryColor := flag.String("subPrimaryColor", "", "subtitle text colour #RRGGBB")
+	subOutlineColor := flag.String("subOutlineColor", "", "subtitle outline colour #RRGGBB")
+	subOutline := flag.Float64("subOutline", 0, "subtitle outline width in pixels")
+	subBox := flag.Bool("subBox", false, "draw an opaque box behind the subtitle text instead of an outline")
+	subBoxColor := flag.String("subBoxColor", "#000000", "with -subBox: box colour #RRGGBB")
+	subBoxOpacity := flag.Float64("subBoxOpacity", 0.6, "with -subBox: box opacity, 0 (clear) to 1 (solid)")
+	subBold := flag.Bool("subBold", false, "bold subtitles (unset -> generator default)")
+	subAlign := flag.Int("subAlign", 0, "subtitle alignment, numpad style: 1-3 bottom, 4-6 middle, 7-9 top (0 -> generator default)")
+	subMarginV := flag.Int("subMarginV", 0, "subtitle vertical margin in PlayRes units (unset -> generator default)")
+	subMarginL := flag.Int("subMarginL", 0, "subtitle left margin in PlayRes units (unset -> generator default)")
+	subMarginR := flag.Int("subMarginR", 0, "subtitle right margin in PlayRes units (unset -> generator default)")
+	maxWordsPerCue := flag.Int("maxWordsPerCue", 1, "merge word captions into cues of up to this many words (1 -> one word at a time)")
+	cueGapMax := flag.Float64("cueGapMax", 0.5, "with -maxWordsPerCue: never merge words across a pause longer than this, in seconds")
+	subStyleMode := flag.String("subStyle", "words", "caption style: words (as generated) | karaoke (whole phrase, spoken word highlighted) | sentence-highlight (whole sentence, current word coloured and bold)")
+	subHighlightColor := flag.String("subHighlightColor", "#FFD700", "with -subStyle karaoke or sentence-highlight: colour of the spoken word(s), #RRGGBB")
+	subColorCycle := flag.String("subColorCycle", "", "colour successive words from this comma-separated #RRGGBB palette, e.g. #FFFFFF,#FFD700,#00FF7F (empty -> off)")
+	subColorRandom := flag.Bool("subColorRandom", false, "with -subColorCycle: pick palette colours at random (reproducible with -seed) instead of in order")
+	sentenceMaxChars := flag.Int("sentenceMaxChars", 80, "with -subStyle sentence-highlight: split longer sentences into captions of at most this many characters")
+	subMaxChars := flag.Int("subMaxChars", 0, "break captions wider than this many characters into two lines, at punctuation or spaces, splitting them in time if two lines are not enough; lowered to fit the font size (0 -> off)")
+	subAnim := flag.String("subAnim", "none", "caption animation: none | pop (each caption grows from -subAnimScale to full size)")
+	subAnimScale := flag.Int("subAnimScale", 60, "with -subAnim pop: starting size, percent of full size")
+	subAnimMs := flag.Int("subAnimMs", 80, "with -subAnim pop: length of the grow, in ms (capped to a third of the caption)")
+	subSafeArea := flag.Bool("subSafeArea", false, "fit the captions to the output frame: PlayRes set to the video size with fonts and margins rescaled, bottom captions kept out of the -subSafeBottomPct band")
+	subSafeBottomPct := flag.Float64("subSafeBottomPct", -1, "bottom percent of the frame captions stay above, e.g. under Shorts buttons; implies -subSafeArea (-1 -> 18 on vertical video, 0 otherwise)")
+	subRTL := flag.String("subRTL", "auto", "right-to-left captions (Arabic, Hebrew, ...): on|off|auto (auto -> on when the whisper or TTS language is RTL)")
+	subMaxCharsCJK := flag.Int("subMaxCharsCJK", 16, "Japanese/Chinese captions: group words into cues of at most this many characters instead of -maxWordsPerCue")
+	softSubs := flag.Bool("softSubs", false, "mux the captions as a toggleable subtitle stream instead of burning them (ASS in .mkv, mov_text in .mp4/.mov)")
+	attachSubs := flag.Bool("attachSubs", false, "burn the captions and also mux them as a subtitle stream")
+	subLang := flag.String("subLang", "eng", "with -softSubs/-attachSubs: ISO 639-2 language tag of the subtitle stream")
+	subsIn := flag.String("subsIn", "", "burn this existing .ass or .srt instead of generating subtitles with whisper")
+	subsSecondary := flag.String("subsSecondary", "", "translations shown as a second caption line: an .srt/.ass matched by time, or a JSON array/object of texts by caption index")
+	subsSecondaryTol := flag.Float64("subsSecondaryTol", 0.5, "with -subsSecondary: report translations whose start or end is off from the caption's by more than this, in seconds")
+	subSecondaryScale := flag.Float64("subSecondaryScale", 0.75, "with -subsSecondary: translation font size relative to the caption's")
+	subSecondaryColor := flag.String("subSecondaryColor", "#CCCCCC", "with -subsSecondary: translation colour #RRGGBB")
+	subsTranslate := flag.String("subsTranslate", "", "translate the captions into this language (es, de, ...) as sentence cues; needs -translateCmd or -translateURL")
+	translateCmd := flag.String("translateCmd", "", "with -subsTranslate: command (split at spaces) reading a JSON array of texts on stdin and writing the translations as a JSON array")
+	translateURL := flag.String("translateURL", "", "with -subsTranslate: LibreTranslate-compatible /translate endpoint (API key from $"+translateKeyEnv+")")
+	subsDriftTolerance := flag.Float64("subsDriftTolerance", 1, "seconds the captions may run past the end of the voice before -subsDriftAction applies")
+	subsDriftAction := flag.String("subsDriftAction", "clamp", "captions running past the voice: clamp (cut them at its end) | warn | fail; captions ending early are reported, and fail the run with fail; -subsIn captions are only cut when this is set")
+	keepHallucinations := flag.Bool("keepHallucinations", false, "keep whisper captions that lie in silent stretches of the voice (the filter drops them)")
+	hallucinationPhrases := flag.String("hallucinationPhrases", "", "also drop captions that are only a phrase from this file (one per line), or builtin for \"thanks for watching\" and the like")
+	subsOffset := flag.Float64("subsOffset", 0, "shift every subtitle by this many seconds, e.g. -0.2 when captions land late")
+	censorListPath := flag.String("censorList", "", "file of banned words (one per line, or /regex/) masked in the subtitles and cut from the voice")
+	censorMask := flag.String("censorMask", "inner", "with -censorList: how banned words show: inner (f**k) | first (f***) | all (****)")
+	censorAudio := flag.String("censorAudio", "bleep", "with -censorList: bleep|mute the banned words in the voice, or off")
+	subsCase := flag.String("subsCase", "none", "recase the subtitles: none|upper|lower|title")
+	subsStripPunct := flag.Bool("subsStripPunct", false, "drop the comma or full stop ending each caption (the SRT keeps it)")
+	noSubs := flag.Bool("noSubs", false, "no subtitles: skip whisper and the ASS entirely, just voice and music over the video")
+	srtOut := flag.String("srtOut", "auto", "closed-caption SRT path; auto -> next to -out, none -> no SRT")
+	wordsOut := flag.String("wordsOut", "none", "word timings JSON ({word, start, end} plus duration and language; readable by -wordsJSON); auto -> next to -out, none -> off")
+	subRegion := flag.String("subRegion", "", "centre subtitles on center|lower-third|split-boundary or x,y (0..1); empty -> style default")
+	subDictionary := flag.String("subDictionary", "", "file of canonical spellings (\"Name: Variant, Variant\" per line) applied to the subtitles")
+	assFallback := flag.String("assFallback", "fail", "when ffmpeg lacks the ass filter: fail|sidecar (keep the .ass next to -out, don't burn)")
+	wordsJSON := flag.String("wordsJSON", "", "word timings from a whisper run elsewhere (JSON segments with word start/end); builds the ASS without running whisper")
+	subsEngine := flag.String("subsEngine", "python", "subtitle generator: python (-pyScript with faster-whisper) | whispercpp (whisper.cpp CLI; -whisperModel is a ggml file)")
+	whisperCppBin := flag.String("whisperCppBin", "", "with -subsEngine whispercpp: whisper.cpp CLI (default: whisper-cli or whisper-cpp from PATH)")
 	py := flag.String("python", ".venv/bin/python", "python executable to run the generator")
 	pyScript := flag.String("pyScript", "scripts/make_ass_words.py", "subtitle generator script")
 	whModel := flag.String("whisperModel", "small", "faster-whisper model")
-	whCompute := flag.String("whisperCompute", "float16", "float16|int8_float16|float32")
+	whCompute := flag.String("whisperCompute", "float16", "float16|int8_float16|float32 (int8 on the CPU unless set)")
+	whLang := flag.String("whisperLang", "", "language whisper transcribes (en, de, ja, ...; auto -> detect); default: the TTS language, else detect")
+	whDevice := flag.String("whisperDevice", "cuda", "device for faster-whisper: cuda|cpu|auto (auto -> cuda when nvidia-smi finds a GPU, else cpu)")
+	whFallbacks := flag.String("whisperFallbacks", "compute=int8_float16,model=base,device=cpu", "when the Python generator runs out of GPU memory, retry with each of these changes in turn (none -> fail at once)")
+
+	// Title card (burned over the first seconds)
+	videoMetaPath := flag.String("videoMeta", "", "per-video metadata JSON (title, description, tags, publish_date) for title card and container tags")
+	metaTitle := flag.String("metaTitle", "", "container title tag (default: -videoMeta title)")
+	titleText := flag.String("titleCardText", "", "story title burned at the top during the first seconds (empty -> off)")
+	titleDur := flag.Float64("titleCardDur", 3, "title card duration in seconds")
+	titleFitMin := flag.Int("titleFitMin", 28, "smallest title font size (ASS PlayRes units)")
+	titleFitMax := flag.Int("titleFitMax", 120, "largest title font size (ASS PlayRes units)")
 
 	// TTS (always synthesize from story file)
-	ttsBin := flag.String("ttsBin", "/home/elevenqtwo/TTS/.venv311/bin/tts", "path to `tts` CLI")
-	storyFile := flag.String("storyFile", "", "UTF-8 text file to synthesize (required)")
+	ttsEngine := flag.String("ttsEngine", "coqui", "TTS engine: coqui|edge|elevenlabs|openai|piper")
+	ttsVoice := flag.String("ttsVoice", "", "voice for edge (en-US-AriaNeural) or openai (alloy, onyx, ...)")
+	ttsFormat := flag.String("ttsFormat", "mp3", "openai response format: mp3|opus|aac|flac|wav (transcoded to 44.1kHz WAV)")
+	elevenVoiceID := flag.String("elevenVoiceID", "", "ElevenLabs voice id (API key from $"+elevenKeyEnv+")")
+	ttsServer := flag.String("ttsServer", "", "coqui: synthesize via a running tts-server at this URL (e.g. http://localhost:5002) instead of the CLI")
+	ttsBin := flag.String("ttsBin", "", "path to the engine's CLI (default: coqui `tts` from PATH, ./.venv or ~/TTS/.venv*; `piper`/`edge-tts` in PATH)")
+	var storyFiles stringList
+	flag.Var(&storyFiles, "storyFile", "UTF-8 text file to synthesize, - for stdin (required; repeat, or give a comma/glob list, to join several)")
+	storyGap := flag.Float64("storyGap", 1.0, "seconds of silence between story files")
 	voiceOut := flag.String("voiceOut", "story.wav", "output WAV from TTS (becomes voice track)")
-	ttsModel := flag.String("ttsModel", "tts_models/en/vctk/vits", "Coqui TTS model_name")
+	voiceIn := flag.String("voiceIn", "", "existing narration to use instead of TTS; takes precedence over -storyFile, and -voiceOut is left alone")
+	ttsModel := flag.String("ttsModel", "tts_models/en/vctk/vits", "Coqui TTS model_name, or the .onnx voice path for piper")
 	ttsSpeaker := flag.String("ttsSpeaker", "p376", "speaker id/index or name")
-	ttsSpeakerWav := flag.String("ttsSpeakerWav", "", "reference WAV for XTTS cloning")
+	ttsSpeakers := flag.String("ttsSpeakers", "", "comma-separated speakers; one is picked at random per run (reproducible with -seed)")
+	ttsSpeakerWav := flag.String("ttsSpeakerWav", "", "reference WAV for XTTS cloning; a comma-separated list gives XTTS several clips")
+	ttsSpeakerWavDir := flag.String("ttsSpeakerWavDir", "", "directory of reference clips (.wav/.mp3); one is picked at random per run (reproducible with -seed)")
 	ttsLang := flag.String("ttsLang", "", "language idx for XTTS (en, ru, ja, ...)")
+	ttsSpeakerFallback := flag.String("ttsSpeakerFallback", "", "comma-separated speakers (ids, WAV paths, default) tried when the speaker is unavailable")
+	strictSpeaker := flag.Bool("strictSpeaker", false, "fail when the speaker is unavailable instead of using -ttsSpeakerFallback")
 	ttsCUDA := flag.Bool("ttsCUDA", true, "pass --use_cuda true/false to tts")
+	ttsStallTimeout := flag.Duration("ttsStallTimeout", 3*time.Minute, "kill a tts/piper/edge-tts process that prints nothing and writes nothing for this long (0 -> never); retried like a crash")
+	ttsCPUFallback := flag.Bool("ttsCPUFallback", true, "with -ttsCUDA: rerun a chunk on the CPU when tts runs out of GPU memory")
+	ttsSpeakerMap := flag.String("ttsSpeakerMap", "", "dialogue mode: voices for \"NAME:\" paragraphs, e.g. ALICE=p225,BOB=bob.wav (ids, reference WAVs, or engine voices)")
+	rawText := flag.Bool("rawText", false, "narrate the story as written: skip removing markdown, URLs and emoji")
+	urlText := flag.String("urlText", "", "spoken replacement for URLs in the story (default: drop them)")
+	normalizeTextFlag := flag.Bool("normalizeText", false, "spell out currency, dates, ordinals, units and numbers before TTS (rules for -ttsLang, default en)")
+	abbrevFile := flag.String("abbrevFile", "", "with -normalizeText: file of ABBREV=expansion lines, e.g. Dr.=Doctor")
+	defaultPause := flag.Float64("defaultPause", 0.8, "seconds of silence for a bare [pause] marker; [pause 1.5] sets its own (max 10)")
+	castFile := flag.String("castFile", "", "JSON casting rules [{match, scope, speaker, speakerWav, lang}] voicing regex matches or paragraphs")
+	paragraphGap := flag.Float64("paragraphGap", 0.6, "seconds of silence between paragraphs of a story (0 -> paragraphs run together)")
+	dialogueGap := flag.Float64("dialogueGap", 0.3, "seconds of silence where the dialogue speaker changes")
+	speakerColors := flag.Bool("speakerColors", false, "with -ttsSpeakerMap: colour each speaker's subtitles")
+	stripDirections := flag.Bool("stripDirections", false, "drop [bracketed]/(parenthesized) stage directions from the spoken text; pause notes become silence")
+	sfxDir := flag.String("sfxDir", "", "with -stripDirections: sound effects named by keyword (thunder.wav for \"(thunder)\")")
+	sfxVol := flag.Float64("sfxVol", 0.6, "linear gain for sound effects")
+	ttsSampleRate := flag.Int("ttsSampleRate", 44100, "resample the synthesized voice to this rate in Hz right after TTS (0 keeps the model's rate)")
+	expectedWPM := flag.String("expectedWPM", "100-220", "plausible speaking rate LO-HI in words per minute for -ttsDurationCheck")
+	ttsDurationCheck := flag.String("ttsDurationCheck", "fail", "fail|warn|off when the synthesized voice is implausibly short or long for the script")
+	ttsSpeed := flag.Float64("ttsSpeed", 1.0, "narration tempo after synthesis, pitch kept (1.1 = 10% faster; 0.25..4)")
+	ttsCheck := flag.Bool("ttsCheck", false, "only synthesize a short sample with each configured voice, report, and exit")
+	ttsOnly := flag.Bool("ttsOnly", false, "stop after writing -voiceOut: no whisper, no encode, -video/-music/-out not needed")
+	subsOnly := flag.Bool("subsOnly", false, "stop after writing the ASS (and -srtOut/-wordsOut): no encode, -video/-music not needed, -out only names the files and may be empty with -assOut")
+	ttsPreflight := flag.Bool("ttsPreflight", true, "check the voices with a short sample before synthesizing a long story")
+	ttsFallback := flag.String("ttsFallback", "", "engine:model tried when the primary TTS fails, e.g. piper:/voices/en_US-amy.onnx")
+	ttsRetries := flag.Int("ttsRetries", 2, "retries with exponential backoff when the TTS tool fails transiently")
+	voiceTrim := flag.Bool("voiceTrim", true, "cut leading/trailing silence from the synthesized voice")
+	voiceTrimDb := flag.Float64("voiceTrimDb", -40, "with -voiceTrim: level (dBFS) below which audio counts as silence")
+	voicePitch := flag.Float64("voicePitch", 0, "shift the voice pitch by this many semitones (-12..12), duration kept")
+	voiceEq := flag.String("voiceEq", "", "voice EQ: comma-separated bass:G, treble:G, F:G or F:G:Q (G in dB, F in Hz)")
+	voiceNorm := flag.Bool("voiceNorm", false, "loudness-normalize the synthesized voice (two-pass loudnorm) before mixing")
+	voiceLUFS := flag.Float64("voiceLUFS", -16, "with -voiceNorm: target integrated loudness in LUFS")
+	ttsCache := flag.String("ttsCache", "", "directory caching synthesized voices by text and TTS settings (empty -> off)")
+	ttsCacheBust := flag.Bool("ttsCacheBust", false, "with -ttsCache: synthesize anew and replace the cached voice")
+	ttsMaxChars := flag.Int("ttsMaxChars", 250, "synthesize the story in sentence chunks of at most this many characters (0 -> one call)")
+
+	// QR overlay (e.g. link to the source story)
+	qrURL := flag.String("qr", "", "URL shown as a QR code in a corner near the end (empty -> off)")
+	qrPos := flag.String("qrPos", "br", "QR corner: tl|tr|bl|br")
+	qrSize := flag.Float64("qrSize", 0.16, "QR size as a fraction of the shorter output side")
+	qrDur := flag.Float64("qrDur", 10, "seconds the QR is shown at the end (fades in)")
 
 	// Utility
+	workDir := flag.String("workDir", "", "directory for intermediates (default: temp dir removed on exit)")
+	keepTemp := flag.Bool("keepTemp", false, "keep intermediates in the work dir")
 	debug := flag.Bool("debug", false, "print parsed flags and decisions")
 	version := flag.Bool("version", false, "print version and exit")
+	offline := flag.Bool("offline", false, "reject options that need the network and block HTTP from this process")
+
+	// avmux bench
+	benchGPU := flag.Bool("benchGPU", false, "bench: encode with NVENC instead of libx264")
+	benchFile := flag.String("benchFile", defaultBenchFile(), "bench: history of runs, compared with the last one")
+	benchThreshold := flag.Float64("benchThreshold", 0.2, "bench: fail when a rate drops by more than this fraction since the last run")
+
+	// Subcommand, if any: avmux [prefetch|watermark|bench] [flags]
+	command := ""
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 
 	flag.Parse()
+	defer runCleanups()
+	if err := applyLegacyFlags(*strictFlags); err != nil {
+		fail("%v", err)
+	}
+
+	if _, ok := ttsEngines[*ttsEngine]; !ok {
+		fail("-ttsEngine must be %s, got %q", strings.Join(ttsEngineNames(), "|"), *ttsEngine)
+	}
+	ttsBinSource := "-ttsBin"
+	if !flagSet("ttsBin") {
+		ttsBinSource = "default"
+		switch *ttsEngine {
+		case "piper":
+			*ttsBin = "piper"
+		case "edge":
+			*ttsBin = "edge-tts"
+		case "coqui":
+			// only a render or prefetch runs the CLI; watermark and bench never synthesize
+			if *voiceIn != "" || *version || (command != "" && command != "prefetch") || *ttsServer != "" {
+				break
+			}
+			bin, tried, err := findCoquiTTS()
+			must(err, "%v", err)
+			*ttsBin = bin
+			ttsBinSource = fmt.Sprintf("discovered after %d candidate(s)", len(tried))
+		}
+	}
+	if !flagSet("ttsModel") {
+		switch *ttsEngine {
+		case "elevenlabs":
+			*ttsModel = elevenDefaultModel
+		case "openai":
+			*ttsModel = openaiDefaultModel
+		}
+	}
+	// PRNG: one source for every random choice, so -seed reproduces a run
+	runSeed := *seed
+	if runSeed == 0 {
+		runSeed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(runSeed))
+
+	speakerNote := ""
+	langNote := "-ttsLang" // or "detected"
+	if list := splitList(*ttsSpeakers); len(list) > 0 {
+		if flagSet("ttsSpeaker") {
+			fail("-ttsSpeaker and -ttsSpeakers are exclusive")
+		}
+		*ttsSpeaker = list[rng.Intn(len(list))]
+		if len(list) > 1 {
+			speakerNote = fmt.Sprintf(" (picked from %d in -ttsSpeakers)", len(list))
+		}
+	}
+	if *ttsSpeakerWavDir != "" && *voiceIn == "" {
+		if *ttsSpeakerWav != "" {
+			fmt.Fprintf(os.Stderr, "WARNING: -ttsSpeakerWav set; ignoring -ttsSpeakerWavDir\n")
+		} else {
+			wavs, err := listSpeakerWavs(*ttsSpeakerWavDir)
+			must(err, "-ttsSpeakerWavDir: %v", err)
+			*ttsSpeakerWav = wavs[rng.Intn(len(wavs))]
+			fmt.Printf("tts reference: %s (picked from %d in -ttsSpeakerWavDir)\n", *ttsSpeakerWav, len(wavs))
+		}
+	}
+	if *ttsStallTimeout != 0 && *ttsStallTimeout < minStallLimit {
+		fail("-ttsStallTimeout must be 0 (off) or at least %v, got %v", minStallLimit, *ttsStallTimeout)
+	}
+	tts := &ttsOptions{
+		engine:      *ttsEngine,
+		bin:         *ttsBin,
+		model:       *ttsModel,
+		speaker:     *ttsSpeaker,
+		speakerWav:  *ttsSpeakerWav,
+		lang:        *ttsLang,
+		cuda:        *ttsCUDA,
+		cpuFallback: *ttsCPUFallback,
+		server:      *ttsServer,
+		stall:       *ttsStallTimeout,
+		voice:       *ttsVoice,
+		format:      *ttsFormat,
+		retries:     max(0, *ttsRetries),
+	}
+	if *ttsEngine == "elevenlabs" {
+		tts.voice = *elevenVoiceID
+	}
+
+	// Ctrl-C/SIGTERM cancel the running stage; its process group is killed
+	// and fail() removes temporaries.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	if *version {
 		if build == "" {
@@ -77,168 +332,1719 @@ func main() {
 		} else {
 			fmt.Println("avmux", build)
 		}
-		return
+		return
+	}
+
+	if *offline {
+		installOfflineGuard()
+		bad := offlineViolations(map[string]string{
+			"video":            *video,
+			"music":            *music,
+			"voiceIn":          *voiceIn,
+			"ttsSpeakerWavDir": *ttsSpeakerWavDir,
+			"ttsServer":        *ttsServer,
+		})
+		bad = append(bad, offlineListViolations("storyFile", storyFiles)...)
+		bad = append(bad, offlineListViolations("ttsSpeakerWav", []string{*ttsSpeakerWav})...)
+		if ttsRemote[*ttsEngine] {
+			bad = append(bad, fmt.Sprintf("-ttsEngine=%s (remote API)", *ttsEngine))
+		}
+		if *subsTranslate != "" && *translateURL != "" {
+			bad = append(bad, fmt.Sprintf("-translateURL=%s (remote API)", *translateURL))
+		}
+		if fb, _, _ := strings.Cut(*ttsFallback, ":"); ttsRemote[fb] {
+			bad = append(bad, fmt.Sprintf("-ttsFallback=%s (remote API)", *ttsFallback))
+		}
+		if len(bad) > 0 {
+			fail("-offline: these settings need network access:\n  %s", strings.Join(bad, "\n  "))
+		}
+	}
+
+	must(ensureInPath("ffmpeg"), "ffmpeg not in PATH")
+	must(ensureInPath("ffprobe"), "ffprobe not in PATH")
+
+	work := *workDir
+	if work == "" {
+		dir, err := os.MkdirTemp("", "avmux-")
+		must(err, "create work dir failed: %v", err)
+		work = dir
+		if !*keepTemp {
+			atExit(func() { _ = os.RemoveAll(dir) })
+		}
+	} else {
+		must(os.MkdirAll(work, 0o755), "create work dir %s failed", work)
+	}
+	tts.work = work
+
+	switch command {
+	case "":
+	case "prefetch":
+		if err := checkTTS(tts); err != nil {
+			fail("%v", err)
+		}
+		must(ensureCallable(*py, "--version"), "python not callable: %s", *py)
+		whisper, err := newWhisperOptions(ctx, *whModel, *whCompute, *whDevice, flagSet("whisperCompute"))
+		must(err, "%v", err)
+		if err := runPrefetch(ctx, tts, *py, *pyScript, whisper, work); err != nil {
+			fail("prefetch failed: %v", err)
+		}
+		fmt.Println("prefetch: done")
+		return
+	case "watermark":
+		if flag.NArg() != 2 || flag.Arg(0) != "detect" {
+			fail("usage: avmux watermark [-watermarkKey=K] detect <file>")
+		}
+		if err := runWatermarkDetect(ctx, flag.Arg(1), *watermarkKey); err != nil {
+			fail("watermark detect failed: %v", err)
+		}
+		return
+	case "bench":
+		if *benchThreshold <= 0 || *benchThreshold >= 1 {
+			fail("-benchThreshold must be in (0, 1), got %g", *benchThreshold)
+		}
+		runs, err := readBenchHistory(*benchFile)
+		must(err, "-benchFile: %v", err)
+		r, err := runBench(ctx, work, *benchGPU, *timeout)
+		must(err, "bench failed: %v", err)
+		fmt.Printf("bench: %s: encode %.1f fps, ass burn %.1f fps, audio %.1fx realtime\n", r.Encoder, r.EncodeFPS, r.BurnFPS, r.AudioSpeed)
+		fmt.Printf("bench: %s, %s, avmux %s\n", r.FFmpeg, r.Go, r.Build)
+		must(saveBenchRun(*benchFile, runs, r), "save %s failed", *benchFile)
+		prev := lastBenchRun(runs, r.Encoder)
+		if prev == nil {
+			fmt.Println("bench: first run, saved to", *benchFile)
+			return
+		}
+		if bad := benchRegressions(prev, r, *benchThreshold); len(bad) > 0 {
+			fail("bench: slower than the run of %s (%s):\n  %s", prev.Time.Format(time.RFC3339), toolchainChanges(prev, r), strings.Join(bad, "\n  "))
+		}
+		fmt.Printf("bench: no regression since %s\n", prev.Time.Format(time.RFC3339))
+		return
+	default:
+		fail("unknown command %q (known: prefetch, watermark, bench)", command)
+	}
+
+	// Required inputs present + exist (-ttsCheck and -ttsOnly runs only
+	// need the story, -subsOnly runs the voice)
+	voiceOnly := *ttsCheck || *ttsOnly
+	if *ttsOnly && *voiceIn != "" {
+		fail("-ttsOnly and -voiceIn cannot be combined; there is nothing to synthesize")
+	}
+	if *subsOnly {
+		switch {
+		case voiceOnly:
+			fail("-subsOnly cannot be combined with -ttsCheck or -ttsOnly")
+		case *noSubs:
+			fail("-subsOnly and -noSubs cannot be combined; there would be nothing to write")
+		case *publishDir != "":
+			fail("-subsOnly writes the files in place; it cannot be combined with -publishDir")
+		case *subSafeArea && (*video == "" || !pathExists(*video)):
+			fail("-subsOnly with -subSafeArea needs -video for the frame size")
+		}
+		for _, name := range []string{"softSubs", "attachSubs", "qr", "audioWatermark"} {
+			if flagSet(name) {
+				fmt.Fprintf(os.Stderr, "WARNING: -subsOnly: -%s applies to the encode; ignored\n", name)
+			}
+		}
+		*softSubs, *attachSubs = false, false
+	}
+	if !voiceOnly && !*subsOnly && (*video == "" || !pathExists(*video)) {
+		fail("no background video")
+	}
+	if !voiceOnly && !*subsOnly && (*music == "" || !pathExists(*music)) {
+		fail("no background music")
+	}
+	switch {
+	case voiceOnly || *out != "":
+	case !*subsOnly:
+		fail("output path missing")
+	case *assOut == "":
+		fail("-subsOnly without -out needs -assOut")
+	case *srtOut == "auto" || *wordsOut == "auto":
+		// auto sidecars are named after -out
+		fail("-subsOnly without -out needs -srtOut and -wordsOut to be a path or none")
+	}
+	if *publishDir != "" {
+		// every artifact is published flat into -publishDir under its base name
+		for _, f := range []struct{ name, val string }{{"assOut", *assOut}, {"srtOut", *srtOut}, {"wordsOut", *wordsOut}} {
+			if f.val != "" && f.val != "auto" && f.val != "none" && filepath.Base(f.val) != f.val {
+				fail("-%s=%s: with -publishDir the file goes into %s; give a bare file name", f.name, f.val, *publishDir)
+			}
+		}
+	}
+	storyPaths, err := expandStoryFiles(storyFiles)
+	must(err, "-storyFile: %v", err)
+	switch {
+	case *voiceIn != "":
+		if !pathExists(*voiceIn) {
+			fail("-voiceIn %s not found", *voiceIn)
+		}
+		if len(storyPaths) > 0 {
+			fmt.Fprintln(os.Stderr, "WARNING: -voiceIn given; -storyFile is ignored")
+			storyPaths = nil
+		}
+	case len(storyPaths) == 0:
+		fail("no story text")
+	}
+	if *voiceDelay < 0 {
+		fail("-voiceDelay must be >= 0")
+	}
+	if *paragraphGap < 0 || *paragraphGap > maxPause {
+		fail("-paragraphGap must be in 0..%d seconds, got %g", maxPause, *paragraphGap)
+	}
+	if *ttsSpeed < 0.25 || *ttsSpeed > 4 {
+		fail("-ttsSpeed must be in 0.25..4, got %g", *ttsSpeed)
+	}
+	if r := *ttsSampleRate; r != 0 && (r < 8000 || r > 192000) {
+		fail("-ttsSampleRate must be 0 or in 8000..192000, got %d", *ttsSampleRate)
+	}
+	switch *ttsDurationCheck {
+	case "fail", "warn", "off":
+	default:
+		fail("-ttsDurationCheck must be fail|warn|off, got %q", *ttsDurationCheck)
+	}
+	wpmLo, wpmHi, err := parseWPMRange(*expectedWPM)
+	must(err, "%v", err)
+	if *startCheck && (*startCheckStep <= 0 || *startCheckTries < 1) {
+		fail("-startCheckStep must be > 0 and -startCheckTries >= 1")
+	}
+	var vmeta *videoMeta
+	if *videoMetaPath != "" {
+		var err error
+		vmeta, err = readVideoMeta(*videoMetaPath)
+		must(err, "-videoMeta: %v", err)
+		var used []string
+		if vmeta.Title != "" && !flagSet("metaTitle") {
+			*metaTitle = vmeta.Title
+			used = append(used, "title->metaTitle")
+		}
+		if vmeta.Title != "" && !flagSet("titleCardText") {
+			*titleText = vmeta.Title
+			used = append(used, "title->titleCardText")
+		}
+		if vmeta.Description != "" {
+			used = append(used, "description")
+		}
+		if len(vmeta.Tags) > 0 {
+			used = append(used, "tags")
+		}
+		if vmeta.PublishDate != "" {
+			used = append(used, "publish_date")
+		}
+		fmt.Printf("video meta: %s\n", strings.Join(used, ", "))
+	}
+	if *titleText != "" {
+		if *titleDur <= 0 {
+			fail("-titleCardDur must be > 0")
+		}
+		if *titleFitMin <= 0 || *titleFitMax < *titleFitMin {
+			fail("-titleFitMin/-titleFitMax: need 0 < min <= max, got %d/%d", *titleFitMin, *titleFitMax)
+		}
+	}
+
+	if *noSubs {
+		for _, name := range []string{"assOut", "assTemplate", "softSubs", "attachSubs", "titleCardText", "wordsOut"} {
+			if flagSet(name) {
+				fail("-%s needs subtitles; it cannot be combined with -noSubs", name)
+			}
+		}
+		if flagSet("srtOut") && *srtOut != "none" {
+			fail("-srtOut is made from the subtitles; it cannot be combined with -noSubs")
+		}
+		*srtOut = "none"
+		if *titleText != "" { // from -videoMeta
+			fmt.Fprintln(os.Stderr, "WARNING: -noSubs: the title card is part of the subtitles; not shown")
+			*titleText = ""
+		}
+	}
+
+	if math.Abs(*subsOffset) > 60 {
+		fail("-subsOffset must be within ±60 seconds, got %g", *subsOffset)
+	}
+	if *noSubs && *subsOffset != 0 {
+		fail("-subsOffset cannot be combined with -noSubs")
+	}
+
+	if *subsCase != "none" && !subsCases[*subsCase] {
+		fail("-subsCase must be none|upper|lower|title, got %q", *subsCase)
+	}
+
+	var censor *censorList
+	mask := censorMasks[*censorMask]
+	if *censorListPath != "" {
+		if *noSubs {
+			fail("-censorList finds the words through the subtitles; it cannot be combined with -noSubs")
+		}
+		if mask == nil {
+			fail("-censorMask must be inner|first|all, got %q", *censorMask)
+		}
+		switch *censorAudio {
+		case "bleep", "mute", "off":
+		default:
+			fail("-censorAudio must be bleep|mute|off, got %q", *censorAudio)
+		}
+		var err error
+		censor, err = readCensorList(*censorListPath)
+		must(err, "-censorList: %v", err)
+	}
+
+	var hallucinations [][]string
+	if *hallucinationPhrases != "" {
+		if *keepHallucinations {
+			fail("-hallucinationPhrases is part of the hallucination filter; it cannot be combined with -keepHallucinations")
+		}
+		var err error
+		hallucinations, err = readHallucinationPhrases(*hallucinationPhrases)
+		must(err, "-hallucinationPhrases: %v", err)
+	}
+
+	// Hand-made subtitles replace whisper; load them now so a bad file
+	// fails before TTS and encoding time is spent.
+	var subsInDoc *assDoc
+	if *subsIn != "" && !voiceOnly {
+		if *noSubs {
+			fail("-subsIn and -noSubs cannot be combined")
+		}
+		var err error
+		subsInDoc, err = readSubsIn(*subsIn)
+		must(err, "-subsIn: %v", err)
+		dst := *assOut
+		if dst == "" {
+			dst = strings.TrimSuffix(*out, filepath.Ext(*out)) + ".ass"
+		}
+		dsts := []string{dst}
+		if *publishDir != "" {
+			dsts = append(dsts, filepath.Join(*publishDir, filepath.Base(dst)))
+		}
+		for _, d := range dsts {
+			if samePath(*subsIn, d) {
+				fail("-subsIn %s would be overwritten by the final ASS; set -assOut to another path", *subsIn)
+			}
+		}
+		fmt.Printf("subtitles: %s (%d event(s), whisper skipped)\n", *subsIn, len(subsInDoc.dialogues()))
+	}
+
+	// Translations for a second caption line, also loaded up front.
+	var secondary *secondarySubs
+	var secondaryColor string
+	if *subsSecondary != "" && !voiceOnly {
+		if *noSubs {
+			fail("-subsSecondary and -noSubs cannot be combined")
+		}
+		if *subSecondaryScale <= 0 || *subSecondaryScale > 2 {
+			fail("-subSecondaryScale must be in (0, 2], got %g", *subSecondaryScale)
+		}
+		if *subsSecondaryTol < 0 {
+			fail("-subsSecondaryTol must be >= 0, got %g", *subsSecondaryTol)
+		}
+		var err error
+		secondaryColor, err = assColor(*subSecondaryColor)
+		must(err, "-subSecondaryColor: %v", err)
+		secondary, err = readSecondarySubs(*subsSecondary)
+		must(err, "-subsSecondary: %v", err)
+	}
+
+	// Translated captions need a backend; check it before any work.
+	var translation *translator
+	if *subsTranslate != "" && !voiceOnly {
+		if *noSubs {
+			fail("-subsTranslate and -noSubs cannot be combined")
+		}
+		translation = &translator{to: strings.ToLower(strings.TrimSpace(*subsTranslate)), url: *translateURL}
+		switch {
+		case (*translateCmd == "") == (*translateURL == ""):
+			fail("-subsTranslate needs exactly one of -translateCmd and -translateURL")
+		case *translateCmd != "":
+			translation.cmd = strings.Fields(*translateCmd)
+			_, err := exec.LookPath(translation.cmd[0])
+			must(err, "-translateCmd: %v", err)
+		case !isURL(*translateURL):
+			fail("-translateURL must be an http(s) URL, got %q", *translateURL)
+		}
+		if *subStyleMode != "words" {
+			fmt.Fprintf(os.Stderr, "WARNING: -subsTranslate makes sentence captions; -subStyle %s is ignored\n", *subStyleMode)
+		}
+	}
+
+	// Word timings transcribed elsewhere stand in for whisper's.
+	var wordsDoc *assDoc
+	if *wordsJSON != "" && !voiceOnly {
+		if *noSubs || subsInDoc != nil {
+			fail("-wordsJSON cannot be combined with -noSubs or -subsIn")
+		}
+		var err error
+		wordsDoc, err = readWordsJSON(*wordsJSON)
+		must(err, "-wordsJSON: %v", err)
+		fmt.Printf("subtitles: %s (%d word(s), whisper skipped)\n", *wordsJSON, len(wordsDoc.events))
+	}
+
+	// Whisper runs unless the subtitles come from -subsIn or -wordsJSON or
+	// are off.
+	var whisper *whisperOptions
+	var whisperSteps []whisperStep
+	cppBin := ""
+	if subsInDoc == nil && wordsDoc == nil && !*noSubs && !voiceOnly {
+		var err error
+		whisper, err = newWhisperOptions(ctx, *whModel, *whCompute, *whDevice, flagSet("whisperCompute"))
+		must(err, "%v", err)
+		switch *subsEngine {
+		case "python":
+		case "whispercpp":
+			if !pathExists(*whModel) {
+				fail("-subsEngine whispercpp: -whisperModel must be a ggml model file, got %q", *whModel)
+			}
+			cppBin, err = findWhisperCpp(*whisperCppBin)
+			must(err, "-subsEngine whispercpp: %v", err)
+		default:
+			fail("-subsEngine must be python|whispercpp, got %q", *subsEngine)
+		}
+		whisperSteps, err = parseWhisperFallbacks(*whFallbacks)
+		must(err, "-whisperFallbacks: %v", err)
+		if *whLang != "" && *whLang != "auto" {
+			if !isWhisperLang(*whLang) {
+				fail("-whisperLang %q is not a whisper language code (e.g. en, de, ja, zh)", *whLang)
+			}
+			whisper.lang = *whLang
+		}
+	}
+
+	// Soft subtitles need a container that takes a subtitle stream.
+	var subCodec string
+	if *softSubs && *attachSubs {
+		fail("-softSubs and -attachSubs are exclusive; -attachSubs already muxes the stream")
+	}
+	if (*softSubs || *attachSubs) && !voiceOnly {
+		var err error
+		subCodec, err = subCodecFor(*out)
+		switch {
+		case err != nil && *attachSubs:
+			// the captions are still burned; only the stream is missing
+			fmt.Fprintf(os.Stderr, "WARNING: -attachSubs: %v; the captions are burned but not attached\n", err)
+		case err != nil:
+			fail("-softSubs: %v", err)
+		}
+		if !subLangRe.MatchString(*subLang) {
+			fail("-subLang must be a three-letter ISO 639-2 code (eng, deu, jpn, ...), got %q", *subLang)
+		}
+		if subCodec == "mov_text" {
+			fmt.Fprintf(os.Stderr, "WARNING: %s cannot carry ASS; the subtitle stream is converted to mov_text and loses fonts, colours and positions (use .mkv to keep them)\n", filepath.Ext(*out))
+		}
+	}
+
+	// Burning needs libass in the ffmpeg build; find out now, not after TTS.
+	burnSubs := !*softSubs && !*noSubs && !*subsOnly
+	switch *assFallback {
+	case "fail", "sidecar":
+	default:
+		fail("-assFallback must be fail|sidecar, got %q", *assFallback)
+	}
+	if burnSubs && !hasFilter("ass") {
+		if *assFallback == "fail" {
+			fail("%s has no ass filter (built without libass): install an ffmpeg with --enable-libass, or use -assFallback=sidecar to skip burning", ffmpegVersion())
+		}
+		burnSubs = false
+		fmt.Fprintf(os.Stderr, "WARNING: %s has no ass filter; subtitles will NOT be burned, only written as a sidecar .ass\n", ffmpegVersion())
+	}
+
+	switch *subSmoothing {
+	case "on", "off":
+	default:
+		fail("-subSmoothing must be on|off, got %q", *subSmoothing)
+	}
+	if *subMinDuration < 0 {
+		fail("-subMinDuration must be >= 0")
+	}
+	if *maxWordsPerCue < 1 || *maxWordsPerCue > 20 {
+		fail("-maxWordsPerCue must be in 1..20, got %d", *maxWordsPerCue)
+	}
+	if *cueGapMax < 0 {
+		fail("-cueGapMax must be >= 0")
+	}
+	var subHighlight string
+	switch *subStyleMode {
+	case "words":
+	case "karaoke", "sentence-highlight":
+		var err error
+		subHighlight, err = assColor(*subHighlightColor)
+		must(err, "-subHighlightColor: %v", err)
+	default:
+		fail("-subStyle must be words|karaoke|sentence-highlight, got %q", *subStyleMode)
+	}
+	if *subStyleMode == "sentence-highlight" {
+		if *sentenceMaxChars < 10 {
+			fail("-sentenceMaxChars must be >= 10, got %d", *sentenceMaxChars)
+		}
+		if *maxWordsPerCue > 1 {
+			fmt.Fprintln(os.Stderr, "WARNING: -subStyle sentence-highlight shows whole sentences; -maxWordsPerCue is ignored")
+		}
+	}
+
+	if *subMaxChars != 0 && *subMaxChars < 10 {
+		fail("-subMaxChars must be 0 or >= 10, got %d", *subMaxChars)
+	}
+
+	if !subsDriftActions[*subsDriftAction] {
+		fail("-subsDriftAction must be clamp|warn|fail, got %q", *subsDriftAction)
+	}
+	if *subsDriftTolerance < 0 {
+		fail("-subsDriftTolerance must be >= 0, got %g", *subsDriftTolerance)
+	}
+	if *subMaxCharsCJK < 2 {
+		fail("-subMaxCharsCJK must be >= 2, got %d", *subMaxCharsCJK)
+	}
+	switch *subRTL {
+	case "on", "off", "auto":
+	default:
+		fail("-subRTL must be on|off|auto, got %q", *subRTL)
+	}
+	if flagSet("subSafeBottomPct") {
+		if *subSafeBottomPct < 0 || *subSafeBottomPct >= 50 {
+			fail("-subSafeBottomPct must be 0-50, got %g", *subSafeBottomPct)
+		}
+		*subSafeArea = true
+	}
+	if *subAnim != "none" && !subAnims[*subAnim] {
+		fail("-subAnim must be none|pop, got %q", *subAnim)
+	}
+	if *subAnimScale < 1 || *subAnimScale > 100 {
+		fail("-subAnimScale must be 1-100, got %d", *subAnimScale)
+	}
+	if *subAnimMs < 1 {
+		fail("-subAnimMs must be > 0, got %d", *subAnimMs)
+	}
+
+	var palette []string
+	for _, c := range splitTrim(*subColorCycle, ",", -1) {
+		if c == "" {
+			continue
+		}
+		v, err := assColor(c)
+		must(err, "-subColorCycle: %v", err)
+		palette = append(palette, inlineColor(v))
+	}
+	if len(palette) > 0 && *speakerColors {
+		fmt.Fprintln(os.Stderr, "WARNING: -subColorCycle colours every word; -speakerColors will not show")
+	}
+	if len(palette) > 0 && (*subStyleMode == "karaoke" || *subStyleMode == "sentence-highlight") {
+		// both rebuild the words into phrases coloured by -subHighlightColor
+		fail("-subColorCycle cannot be combined with -subStyle %s", *subStyleMode)
+	}
+
+	var regionX, regionY float64
+	if *subRegion != "" {
+		var err error
+		regionX, regionY, err = parseSubRegion(*subRegion)
+		must(err, "-subRegion: %v", err)
+	}
+
+	subStyle := styleEdit{}
+	if flagSet("subFont") {
+		if strings.TrimSpace(*subFont) == "" || strings.Contains(*subFont, ",") {
+			fail("-subFont must be a font name without commas, got %q", *subFont)
+		}
+		subStyle["Fontname"] = strings.TrimSpace(*subFont)
+	}
+	if *fontsDir != "" {
+		files, err := fontFiles(*fontsDir)
+		must(err, "-fontsDir: %v", err)
+		*fontsDir = absPath(*fontsDir)
+		if !filterSafe(*fontsDir) {
+			fail("-fontsDir %q cannot be passed to the ass filter; use a directory without control characters or spaces at the ends", *fontsDir)
+		}
+		if name := subStyle["Fontname"]; name != "" && !fontsDirFamilies(files)[strings.ToLower(name)] {
+			fmt.Fprintf(os.Stderr, "WARNING: no font in -fontsDir %s provides %q; libass will fall back to a system font\n", *fontsDir, name)
+		}
+	}
+	if flagSet("subSize") {
+		if *subSize <= 0 || *subSize > 500 {
+			fail("-subSize must be in (0, 500], got %g", *subSize)
+		}
+		subStyle["Fontsize"] = styleFieldValue(*subSize)
+	}
+	for name, c := range map[string]*string{"PrimaryColour": subPrimaryColor, "OutlineColour": subOutlineColor} {
+		if *c == "" {
+			continue
+		}
+		v, err := assColor(*c)
+		must(err, "-sub%s: %v", strings.TrimSuffix(name, "Colour")+"Color", err)
+		subStyle[name] = v
+	}
+	if flagSet("subOutline") {
+		if *subOutline < 0 || *subOutline > 20 {
+			fail("-subOutline must be in 0..20, got %g", *subOutline)
+		}
+		subStyle["Outline"] = styleFieldValue(*subOutline)
+	}
+	if flagSet("subBold") {
+		subStyle["Bold"] = assBool(*subBold)
+	}
+	if *subBox {
+		c, err := assAlphaColor(*subBoxColor, *subBoxOpacity)
+		must(err, "-subBoxColor/-subBoxOpacity: %v", err)
+		if *subOutlineColor != "" {
+			fmt.Fprintln(os.Stderr, "WARNING: -subBox paints the box in the outline colour; -subOutlineColor is ignored")
+		}
+		for k, v := range boxStyle(c) {
+			subStyle[k] = v
+		}
+	} else if flagSet("subBoxColor") || flagSet("subBoxOpacity") {
+		fmt.Fprintln(os.Stderr, "WARNING: -subBoxColor and -subBoxOpacity only apply with -subBox")
+	}
+	if *subAlign != 0 {
+		if *subAlign < 1 || *subAlign > 9 {
+			fail("-subAlign must be 1..9, got %d", *subAlign)
+		}
+		if *subRegion != "" {
+			fmt.Fprintln(os.Stderr, "WARNING: -subRegion positions every line itself; -subAlign only affects lines it skips")
+		}
+		subStyle["Alignment"] = strconv.Itoa(*subAlign)
+	}
+	for name, m := range map[string]*int{"MarginV": subMarginV, "MarginL": subMarginL, "MarginR": subMarginR} {
+		if !flagSet("sub" + name) {
+			continue
+		}
+		if *m < 0 || *m > 4000 {
+			fail("-sub%s must be in 0..4000, got %d", name, *m)
+		}
+		subStyle[name] = strconv.Itoa(*m)
+	}
+
+	var tmpl *assTemplate
+	if *assTemplateFile != "" {
+		if len(subStyle) > 0 {
+			var set []string
+			for _, name := range []string{"subFont", "subSize", "subPrimaryColor", "subOutlineColor", "subOutline", "subBold",
+				"subBox", "subAlign", "subMarginV", "subMarginL", "subMarginR"} {
+				if flagSet(name) {
+					set = append(set, "-"+name)
+				}
+			}
+			fail("-assTemplate sets the style; it cannot be combined with %s", strings.Join(set, ", "))
+		}
+		var err error
+		tmpl, err = readASSTemplate(*assTemplateFile, *subStyleName)
+		must(err, "-assTemplate: %v", err)
+		if tmpl.dropped > 0 {
+			fmt.Fprintf(os.Stderr, "WARNING: -assTemplate: the template's %d event(s) are dropped; only its headers and styles are used\n", tmpl.dropped)
+		}
+	} else if *subStyleName != "" {
+		fail("-subStyleName picks a style of -assTemplate; it needs one")
+	}
+
+	var dict *subDict
+	if *subDictionary != "" {
+		var err error
+		dict, err = readSubDict(*subDictionary)
+		must(err, "-subDictionary: %v", err)
+	}
+
+	if math.Abs(*voicePitch) > maxPitchShift {
+		fail("-voicePitch must be within ±%d semitones, got %g", maxPitchShift, *voicePitch)
+	}
+	voiceEQ := ""
+	if *voiceEq != "" {
+		var err error
+		voiceEQ, err = parseVoiceEQ(*voiceEq)
+		must(err, "%v", err)
+	}
+	eqFilter, ok := musicEQFilters[*musicEQ]
+	if !ok {
+		fail("-musicEQ must be speechcarve or empty, got %q", *musicEQ)
+	}
+
+	var qrCodeData *qrCode
+	if *qrURL != "" {
+		switch *qrPos {
+		case "tl", "tr", "bl", "br":
+		default:
+			fail("-qrPos must be tl|tr|bl|br, got %q", *qrPos)
+		}
+		if *qrSize <= 0 || *qrSize > 0.5 || *qrDur <= 0 {
+			fail("-qrSize must be in (0, 0.5] and -qrDur > 0")
+		}
+		var err error
+		qrCodeData, err = encodeQR(*qrURL)
+		must(err, "-qr: %v", err)
+	}
+
+	// Voice: the -voiceIn recording as is, or TTS from the story file(s)
+	var (
+		text, voiceSource string
+		parts             []storyPart
+		spans             []partSpan
+		speakerNames      []string
+		storyFirstPart    []int // part index where each story begins
+		paraFirstPart     []int // part index where each paragraph begins
+		stdinBytes        = -1
+	)
+	voicePath := *voiceOut
+	if *voiceIn != "" {
+		d, err := probeDuration(ctx, *voiceIn)
+		if err != nil || d <= 0 {
+			fail("-voiceIn %s: not a readable, non-empty audio file (%v)", *voiceIn, err)
+		}
+		voicePath, voiceSource = *voiceIn, "-voiceIn"
+	} else {
+		if err := checkTTS(tts); err != nil {
+			fail("%v", err)
+		}
+		if *ttsSpeakerFallback != "" || *strictSpeaker {
+			var chain []string
+			if !*strictSpeaker {
+				chain = splitTrim(*ttsSpeakerFallback, ",", -1)
+			}
+			sub, err := resolveSpeaker(ctx, tts, chain)
+			must(err, "tts speaker: %v", err)
+			if sub != "" {
+				fmt.Fprintf(os.Stderr, "WARNING: speaker substituted: %s\n", sub)
+			}
+		}
+		if tts.speakerWav != "" {
+			err := checkSpeakerWavs(ctx, tts.speakerWav)
+			must(err, "-ttsSpeakerWav: %v", err)
+		}
+		if speakerNote != "" {
+			fmt.Printf("tts speaker: %s%s\n", tts.speaker, speakerNote)
+		}
+		if *ttsFallback != "" {
+			tts.fallback, err = parseTTSFallback(*ttsFallback, tts)
+			must(err, "-ttsFallback: %v", err)
+		}
+		var abbrevs []normRule
+		if *abbrevFile != "" {
+			if !*normalizeTextFlag {
+				fail("-abbrevFile needs -normalizeText")
+			}
+			abbrevs, err = readAbbrevFile(*abbrevFile)
+			must(err, "-abbrevFile: %v", err)
+		}
+		var texts []string
+		for _, p := range storyPaths {
+			t, err := readStory(p, os.Stdin)
+			must(err, "read story file failed: %v", err)
+			if p == "-" {
+				stdinBytes = len(t)
+			}
+			if t == "" {
+				fmt.Fprintf(os.Stderr, "WARNING: story file %s is empty; skipped\n", p)
+				continue
+			}
+			if !*rawText {
+				t = cleanText(t, *urlText)
+			}
+			texts = append(texts, t)
+		}
+		if len(texts) == 0 {
+			fail("no story text")
+		}
+		if tts.engine == "coqui" && tts.lang == "" && isXTTSModel(tts.model) {
+			lang, ok := detectLanguage(strings.Join(texts, "\n\n"))
+			if ok {
+				fmt.Printf("tts language: %s (detected; set -ttsLang to override)\n", lang)
+			} else {
+				fmt.Fprintf(os.Stderr, "WARNING: could not tell the story's language; using %s (set -ttsLang)\n", lang)
+			}
+			tts.lang, langNote = lang, "detected"
+			if fb := tts.fallback; fb != nil && fb.engine == "coqui" && fb.lang == "" && isXTTSModel(fb.model) {
+				fb.lang = lang
+			}
+		}
+		if *normalizeTextFlag {
+			normLang := tts.lang
+			if normLang == "" {
+				normLang = "en"
+			}
+			var normSubs []normSub
+			for i := range texts {
+				var subs []normSub
+				texts[i], subs, err = normalizeText(texts[i], normLang, abbrevs)
+				must(err, "-normalizeText: %v", err)
+				normSubs = append(normSubs, subs...)
+			}
+			fmt.Printf("text normalization: %d substitution(s)\n", len(normSubs))
+			if *debug {
+				for _, sub := range normSubs {
+					fmt.Printf("  - %s\n  + %s\n", sub.from, sub.to)
+				}
+			}
+		}
+		text = strings.Join(texts, "\n\n")
+		if !*rawText || *normalizeTextFlag {
+			narration := filepath.Join(work, "narration.txt")
+			must(os.WriteFile(narration, []byte(text+"\n"), 0o644), "write %s failed", narration)
+			if *debug {
+				fmt.Println("narrated text:", narration)
+			}
+		}
+		voices := map[string]*ttsOptions{}
+		var speakerMap map[string]string
+		if *ttsSpeakerMap != "" {
+			names, m, err := parseSpeakerMap(*ttsSpeakerMap)
+			must(err, "-ttsSpeakerMap: %v", err)
+			for _, n := range names {
+				voices[n] = speakerOptions(tts, m[n])
+				if err := checkTTS(voices[n]); err != nil {
+					fail("-ttsSpeakerMap %s: %v", n, err)
+				}
+				if w := voices[n].speakerWav; w != "" && !pathExists(w) {
+					fail("-ttsSpeakerMap %s: reference WAV not found: %s", n, w)
+				}
+			}
+			speakerNames, speakerMap = names, m
+		}
+		var cast []castRule
+		if *castFile != "" {
+			if *ttsSpeakerMap != "" {
+				fail("-castFile and -ttsSpeakerMap cannot be combined")
+			}
+			cast, err = readCastFile(*castFile)
+			must(err, "-castFile: %v", err)
+			for i := range cast {
+				n := cast[i].label(i)
+				voices[n] = cast[i].options(tts)
+				if err := checkTTS(voices[n]); err != nil {
+					fail("-castFile rule %d: %v", i+1, err)
+				}
+				speakerNames = append(speakerNames, n)
+			}
+		}
+		if *speakerColors && speakerNames == nil {
+			fail("-speakerColors needs -ttsSpeakerMap or -castFile")
+		}
+		// lines of all stories in order; storyStart[k] is story k's first line
+		var lines []dialogueLine
+		var storyStart []int
+		for _, t := range texts {
+			storyStart = append(storyStart, len(lines))
+			switch {
+			case speakerMap != nil:
+				ls, err := splitDialogue(t, speakerMap)
+				must(err, "dialogue: %v", err)
+				lines = append(lines, ls...)
+			case cast != nil:
+				lines = append(lines, castLines(t, cast)...)
+			default:
+				lines = append(lines, dialogueLine{text: t})
+			}
+		}
+		if speakerMap != nil {
+			fmt.Printf("dialogue: %d lines, speakers %s\n", len(lines), strings.Join(speakerNames, ", "))
+		}
+		if cast != nil {
+			fmt.Printf("cast: %d segments, %d rules\n", len(lines), len(cast))
+			if *debug {
+				for _, ln := range lines {
+					who := "default"
+					if i := castRuleIndex(ln.speaker); i >= 0 {
+						who = fmt.Sprintf("%s %q", ln.speaker, cast[i].Match)
+					}
+					fmt.Printf("  %s <- %q\n", who, preview(ln.text, 50))
+				}
+			}
+		}
+		preflight := func() {
+			checks := []*ttsOptions{tts}
+			for _, n := range speakerNames {
+				checks = append(checks, voices[n])
+			}
+			for i, o := range checks {
+				who := "default voice"
+				if i > 0 {
+					who = "speaker " + speakerNames[i-1]
+				}
+				fmt.Printf("tts check: %s\n", who)
+				if err := preflightTTS(ctx, o, work, *timeout); err != nil {
+					fail("tts check failed (%s): %v", who, err)
+				}
+			}
+			fmt.Println("tts check: ok")
+		}
+		if *ttsCheck {
+			preflight()
+			return
+		}
+		var sfx map[string]string
+		if *stripDirections && *sfxDir != "" {
+			sfx, err = loadSFX(*sfxDir)
+			must(err, "-sfxDir: %v", err)
+		}
+		var dirs []direction
+		for i, ln := range lines {
+			switch {
+			case len(storyFirstPart) < len(storyStart) && storyStart[len(storyFirstPart)] == i:
+				if i > 0 && *storyGap > 0 {
+					parts = append(parts, storyPart{pause: *storyGap})
+				}
+				storyFirstPart = append(storyFirstPart, len(parts))
+			case ln.speaker != lines[i-1].speaker && *dialogueGap > 0:
+				parts = append(parts, storyPart{pause: *dialogueGap})
+			}
+			paras := []string{ln.text}
+			if *paragraphGap > 0 {
+				paras = paragraphRe.Split(ln.text, -1)
+			}
+			var lp []storyPart
+			for k, para := range paras {
+				if para = strings.TrimSpace(para); para == "" {
+					continue
+				}
+				if k > 0 && len(lp) > 0 {
+					lp = append(lp, storyPart{pause: *paragraphGap})
+				}
+				paraFirstPart = append(paraFirstPart, len(parts)+len(lp))
+				pp, capped := splitPauseMarkers(para, *defaultPause)
+				for _, c := range capped {
+					fmt.Fprintf(os.Stderr, "WARNING: %s capped at %gs\n", c, maxPause)
+				}
+				lp = append(lp, pp...)
+			}
+			if *stripDirections {
+				var sp []storyPart
+				for _, p := range lp {
+					if p.text == "" {
+						sp = append(sp, p)
+						continue
+					}
+					pd, d := parseDirections(p.text, sfx)
+					sp = append(sp, pd...)
+					dirs = append(dirs, d...)
+				}
+				lp = sp
+			}
+			for j := range lp {
+				lp[j].speaker, lp[j].tts = ln.speaker, voices[ln.speaker]
+			}
+			parts = append(parts, lp...)
+		}
+		if *stripDirections {
+			fmt.Printf("stage directions: %d removed\n", len(dirs))
+			if *debug {
+				for _, d := range dirs {
+					fmt.Printf("  %s -> %s\n", d.raw, d.action)
+				}
+			}
+		}
+		voiceSource = "synthesized"
+		cacheKey, cached := "", false
+		if *ttsCache != "" {
+			post := fmt.Sprintf("rate=%d speed=%g trim=%v/%g pitch=%g eq=%s norm=%v/%g",
+				*ttsSampleRate, *ttsSpeed, *voiceTrim, *voiceTrimDb, *voicePitch, voiceEQ, *voiceNorm, *voiceLUFS)
+			cacheKey, err = ttsCacheKey(tts, parts, *ttsMaxChars, post)
+			must(err, "-ttsCache: %v", err)
+			if !*ttsCacheBust {
+				spans, cached, err = loadTTSCache(*ttsCache, cacheKey, *voiceOut)
+				must(err, "-ttsCache: %v", err)
+			}
+		}
+		if cached {
+			voiceSource = "cache " + cacheKey
+			fmt.Println("tts: voice from cache")
+		} else {
+			if *ttsPreflight && utf8.RuneCountInString(text) > preflightChars {
+				preflight()
+			}
+			_ = os.Remove(*voiceOut) // ensure fresh synth
+			spans, err = synthesizeStory(ctx, tts, parts, *voiceOut, work, *ttsMaxChars, *timeout)
+			if err != nil {
+				_ = os.Remove(*voiceOut)
+				fail("tts failed: %v", err)
+			}
+			if *ttsSampleRate > 0 {
+				done, err := resampleVoice(ctx, *voiceOut, *ttsSampleRate, work, *timeout)
+				must(err, "-ttsSampleRate: resample voice failed: %v", err)
+				if done && *debug {
+					fmt.Printf("voice resampled to %d Hz\n", *ttsSampleRate)
+				}
+			}
+			if *ttsDurationCheck != "off" {
+				lo, hi, words := expectedDuration(parts, wpmLo, wpmHi)
+				d, err := probeDuration(ctx, *voiceOut)
+				must(err, "probe voice duration failed")
+				if *debug {
+					fmt.Printf("voice duration: %.1fs for %d words, expected %.1f..%.1fs\n", d, words, lo, hi)
+				}
+				if words >= minCheckWords && (d < lo || d > hi) {
+					msg := fmt.Sprintf("synthesized voice is %.1fs but %d words at %s wpm should take %.1f..%.1fs; the tts may have dropped or repeated text",
+						d, words, *expectedWPM, lo, hi)
+					if *ttsDurationCheck == "warn" {
+						fmt.Fprintf(os.Stderr, "WARNING: %s\n", msg)
+					} else {
+						_ = os.Remove(*voiceOut)
+						fail("%s (-ttsDurationCheck=warn to continue)", msg)
+					}
+				}
+			}
+			if *voiceTrim {
+				if *debug {
+					must(copyFile(*voiceOut, filepath.Join(work, "voice-untrimmed.wav")), "keep untrimmed voice failed")
+				}
+				lead, err := trimSilence(ctx, *voiceOut, *voiceTrimDb, work, *timeout)
+				must(err, "-voiceTrim: %v", err)
+				for i := range spans {
+					spans[i].start = max(0, spans[i].start-lead)
+					spans[i].end = max(0, spans[i].end-lead)
+				}
+				if *debug {
+					fmt.Printf("voice trim: %.3fs cut from the start\n", lead)
+				}
+			}
+			if *ttsSpeed != 1.0 {
+				must(changeSpeed(ctx, *voiceOut, *ttsSpeed, work, *timeout), "-ttsSpeed: retime voice failed")
+				for i := range spans {
+					spans[i].start /= *ttsSpeed
+					spans[i].end /= *ttsSpeed
+				}
+			}
+			if *voicePitch != 0 || voiceEQ != "" {
+				must(shapeVoice(ctx, *voiceOut, *voicePitch, voiceEQ, work, *timeout), "voice pitch/EQ failed")
+			}
+			if *voiceNorm {
+				st, err := normalizeLoudness(ctx, *voiceOut, *voiceLUFS, work, *timeout)
+				must(err, "-voiceNorm: %v", err)
+				if *debug {
+					fmt.Printf("voice loudness: measured I=%s LUFS TP=%s dBTP LRA=%s LU -> target %g LUFS\n",
+						st.InputI, st.InputTP, st.InputLRA, *voiceLUFS)
+				}
+			}
+			if cacheKey != "" {
+				if ttsFallbackUses.Load() > 0 {
+					fmt.Fprintf(os.Stderr, "WARNING: -ttsCache: not caching a voice made partly by -ttsFallback\n")
+				} else if err := storeTTSCache(*ttsCache, cacheKey, *voiceOut, spans); err != nil {
+					fmt.Fprintf(os.Stderr, "WARNING: -ttsCache: store failed: %v\n", err)
+				} else {
+					voiceSource = "synthesized, cached as " + cacheKey
+				}
+			}
+		}
+	}
+	if *ttsOnly {
+		d, err := probeDuration(ctx, voicePath)
+		must(err, "probe voice duration failed")
+		if *debug {
+			fmt.Printf("  -ttsOnly: voice=%s parts=%d\n", voiceSource, len(parts))
+		}
+		fmt.Printf("voice: %s (%.2fs)\n", voicePath, d)
+		return
+	}
+	muxVoice := voicePath // voice plus any sound effects; whisper gets the clean voice
+	if cues := sfxCues(parts, spans); len(cues) > 0 {
+		muxVoice = filepath.Join(work, "voice-sfx.wav")
+		must(mixSFX(ctx, voicePath, cues, *sfxVol, muxVoice, *timeout), "mix sound effects failed")
+	}
+
+	// durations
+	audDur, err := probeDuration(ctx, voicePath)
+	must(err, "probe voice duration failed")
+	outDur := *voiceDelay + audDur // video and music must cover the delay too
+	// -subsOnly has no video or music to probe
+	vidDur, musicDur := outDur, outDur
+	if !*subsOnly {
+		vidDur, err = probeDuration(ctx, *video)
+		must(err, "probe video duration failed")
+		musicDur, err = probeDuration(ctx, *music)
+		must(err, "probe music duration failed")
+	}
+	if wordsDoc != nil {
+		if last := float64(wordsDoc.lastEnd()) / 100; last > audDur+wordsJSONSlack {
+			fail("-wordsJSON: last word ends at %.2fs but the voice is %.2fs long; timings are for another recording?", last, audDur)
+		}
+	}
+
+	// Decide randomized starts
+	vStart := *videoStart
+	if vStart < 0 {
+		if *randVideo {
+			if outDur <= vidDur {
+				vStart = randRange(rng, 0, maxf(vidDur-outDur, 0))
+			} else {
+				vStart = randRange(rng, 0, vidDur) // will loop
+			}
+		} else {
+			vStart = 0
+		}
+	}
+	if *startCheck && !*subsOnly {
+		loop := outDur > vidDur
+		limit := vidDur
+		if !loop {
+			limit = vidDur - outDur
+		}
+		vStart = checkVideoStart(ctx, *video, vStart, *startCheckStep, limit, loop, *startCheckTries, *debug)
+	}
+	mStart := *musicStart
+	if mStart < 0 {
+		if *randMusic {
+			if *musicLoop && outDur > musicDur {
+				mStart = randRange(rng, 0, musicDur) // will loop
+			} else {
+				mStart = randRange(rng, 0, maxf(musicDur-outDur, 0))
+			}
+		} else {
+			mStart = 0
+		}
+	}
+
+	// Whisper transcribes in the narration's language unless -whisperLang
+	// says otherwise; tts.lang may have been detected from the story.
+	if whisper != nil && !flagSet("whisperLang") {
+		whisper.lang = whisperLangFor(tts.lang)
+	}
+	// Captions in an RTL language get embedded lines and, unless -subFont
+	// says otherwise, a font with the script's glyphs.
+	subsLang := whisperLangFor(tts.lang)
+	if whisper != nil {
+		subsLang = whisper.lang
+	}
+	if translation != nil {
+		translation.from = subsLang
+		subsLang, _, _ = strings.Cut(translation.to, "-")
+	}
+	rtl := *subRTL == "on" || (*subRTL == "auto" && rtlFonts[subsLang] != "")
+	if font := rtlFonts[subsLang]; rtl && font != "" && subStyle["Fontname"] == "" && tmpl == nil {
+		subStyle["Fontname"] = font
+	}
+	// Japanese and Chinese captions are grouped by characters.
+	cjk := cjkFonts[subsLang] != ""
+	if cjk && subStyle["Fontname"] == "" && tmpl == nil {
+		subStyle["Fontname"] = cjkFonts[subsLang]
+	}
+
+	if *debug {
+		fmt.Println("== parsed flags ==")
+		fmt.Printf("  -video=%q\n", *video)
+		fmt.Printf("  -music=%q\n", *music)
+		fmt.Printf("  -musicVol=%.3f -voiceVol=%.3f -musicLoop=%v\n", *musicVol, *voiceVol, *musicLoop)
+		fmt.Printf("  -musicEQ=%q -maskCheck=%v -maskThreshold=%.2f\n", *musicEQ, *maskCheck, *maskThreshold)
+		fmt.Printf("  -out=%q -publishDir=%q -srtOut=%q -wordsOut=%q\n", *out, *publishDir, *srtOut, *wordsOut)
+		fmt.Printf("  -useGPU=%v -gpuCQ=%s -crf=%s\n", *useGPU, *gpuCQ, *crf)
+		fmt.Printf("  -assOut=%q -assTemplate=%q -subStyleName=%q\n", *assOut, *assTemplateFile, *subStyleName)
+		fmt.Printf("  -subDictionary=%q -subRegion=%q\n", *subDictionary, *subRegion)
+		fmt.Printf("  subtitle style: %v\n", subStyle)
+		fmt.Printf("  -subSmoothing=%s -subMinDuration=%.2f\n", *subSmoothing, *subMinDuration)
+		fmt.Printf("  -maxWordsPerCue=%d -cueGapMax=%.2f\n", *maxWordsPerCue, *cueGapMax)
+		fmt.Printf("  -subColorCycle=%q -subColorRandom=%v (palette %s)\n", *subColorCycle, *subColorRandom, strings.Join(palette, " "))
+		fmt.Printf("  -subStyle=%s -subHighlightColor=%s -sentenceMaxChars=%d -subMaxChars=%d\n", *subStyleMode, *subHighlightColor, *sentenceMaxChars, *subMaxChars)
+		fmt.Printf("  -fontsDir=%q -subRTL=%s (rtl=%v, language %q) -subMaxCharsCJK=%d (cjk=%v)\n", *fontsDir, *subRTL, rtl, subsLang, *subMaxCharsCJK, cjk)
+		fmt.Printf("  -subsDriftTolerance=%g -subsDriftAction=%s\n", *subsDriftTolerance, *subsDriftAction)
+		fmt.Printf("  -keepHallucinations=%v -hallucinationPhrases=%q\n", *keepHallucinations, *hallucinationPhrases)
+		fmt.Printf("  -subsTranslate=%q -translateCmd=%q -translateURL=%q\n", *subsTranslate, *translateCmd, *translateURL)
+		fmt.Printf("  -subsSecondary=%q -subsSecondaryTol=%g -subSecondaryScale=%g -subSecondaryColor=%s\n", *subsSecondary, *subsSecondaryTol, *subSecondaryScale, *subSecondaryColor)
+		fmt.Printf("  -subSafeArea=%v -subSafeBottomPct=%g\n", *subSafeArea, *subSafeBottomPct)
+		fmt.Printf("  -subAnim=%s -subAnimScale=%d -subAnimMs=%d\n", *subAnim, *subAnimScale, *subAnimMs)
+		fmt.Printf("  -subsIn=%q -wordsJSON=%q -subsOffset=%.2f\n", *subsIn, *wordsJSON, *subsOffset)
+		fmt.Printf("  -subsCase=%s -subsStripPunct=%v\n", *subsCase, *subsStripPunct)
+		fmt.Printf("  -censorList=%q -censorMask=%s -censorAudio=%s\n", *censorListPath, *censorMask, *censorAudio)
+		fmt.Printf("  -noSubs=%v -subsOnly=%v -assFallback=%s burn=%v\n", *noSubs, *subsOnly, *assFallback, burnSubs)
+		fmt.Printf("  -softSubs=%v -attachSubs=%v -subLang=%s (codec %q)\n", *softSubs, *attachSubs, *subLang, subCodec)
+		fmt.Printf("  -python=%q\n", *py)
+		fmt.Printf("  -pyScript=%q\n", *pyScript)
+		fmt.Printf("  -whisperModel=%q\n", *whModel)
+		fmt.Printf("  -whisperCompute=%q\n", *whCompute)
+		fmt.Printf("  -whisperFallbacks=%q\n", *whFallbacks)
+		if whisper != nil {
+			fmt.Printf("  -subsEngine=%s -whisperCppBin=%q (using %q)\n", *subsEngine, *whisperCppBin, cppBin)
+			fmt.Printf("  -whisperDevice=%s (using %s, compute %s)\n", *whDevice, whisper.device, whisper.compute)
+			fmt.Printf("  -whisperLang=%q (using %q; empty -> detect)\n", *whLang, whisper.lang)
+		} else {
+			fmt.Printf("  -whisperDevice=%s (whisper not run)\n", *whDevice)
+		}
+		fmt.Printf("  -videoMeta=%q -metaTitle=%q\n", *videoMetaPath, *metaTitle)
+		fmt.Printf("  -titleCardText=%q -titleCardDur=%.3f -titleFit=%d..%d\n", *titleText, *titleDur, *titleFitMin, *titleFitMax)
+		fmt.Printf("  -ttsEngine=%s -ttsBin=%q (%s) -ttsVoice=%q -ttsFormat=%s -elevenVoiceID=%q\n", *ttsEngine, *ttsBin, ttsBinSource, *ttsVoice, *ttsFormat, *elevenVoiceID)
+		fmt.Printf("  -ttsServer=%q -ttsStallTimeout=%v\n", *ttsServer, *ttsStallTimeout)
+		fmt.Printf("  -ttsModel=%q\n", *ttsModel)
+		fmt.Printf("  -ttsSpeaker=%q\n", *ttsSpeaker)
+		fmt.Printf("  -ttsSpeakerWav=%q -ttsSpeakerWavDir=%q\n", *ttsSpeakerWav, *ttsSpeakerWavDir)
+		for i, w := range speakerWavs(tts.speakerWav) {
+			fmt.Printf("    reference %d: %s\n", i+1, w)
+		}
+		for i, p := range storyPaths {
+			if p == "-" {
+				p = fmt.Sprintf("- (stdin, %d bytes)", stdinBytes)
+			}
+			fmt.Printf("  -storyFile[%d]=%s\n", i, p)
+		}
+		if len(storyFirstPart) > 1 {
+			fmt.Printf("  -storyGap=%.2f story offsets: %s\n", *storyGap, fmtSecs(storyOffsets(spans, storyFirstPart)))
+		}
+		fmt.Printf("  -ttsSpeakerFallback=%q -strictSpeaker=%v (using speaker=%q wav=%q)\n", *ttsSpeakerFallback, *strictSpeaker, tts.speaker, tts.speakerWav)
+		fmt.Printf("  -ttsLang=%q (using %q, %s)\n", *ttsLang, tts.lang, langNote)
+		fmt.Printf("  -ttsCUDA=%v -ttsCPUFallback=%v -ttsMaxChars=%d -ttsRetries=%d -ttsSpeed=%g\n", *ttsCUDA, *ttsCPUFallback, *ttsMaxChars, *ttsRetries, *ttsSpeed)
+		fmt.Printf("  -ttsFallback=%q\n", *ttsFallback)
+		fmt.Printf("  -ttsSampleRate=%d\n", *ttsSampleRate)
+		fmt.Printf("  -rawText=%v -urlText=%q\n", *rawText, *urlText)
+		fmt.Printf("  -normalizeText=%v -abbrevFile=%q\n", *normalizeTextFlag, *abbrevFile)
+		fmt.Printf("  -ttsDurationCheck=%s -expectedWPM=%s\n", *ttsDurationCheck, *expectedWPM)
+		fmt.Printf("  -voiceTrim=%v -voiceTrimDb=%g\n", *voiceTrim, *voiceTrimDb)
+		fmt.Printf("  -voicePitch=%g -voiceEq=%q\n", *voicePitch, *voiceEq)
+		fmt.Printf("  -voiceNorm=%v -voiceLUFS=%g\n", *voiceNorm, *voiceLUFS)
+		fmt.Printf("  -ttsCache=%q -ttsCacheBust=%v (voice: %s)\n", *ttsCache, *ttsCacheBust, voiceSource)
+		fmt.Printf("  -defaultPause=%.2f\n", *defaultPause)
+		fmt.Printf("  -castFile=%q\n", *castFile)
+		fmt.Printf("  -paragraphGap=%.2f (%d paragraph(s))\n", *paragraphGap, len(paraFirstPart))
+		fmt.Printf("  -ttsSpeakerMap=%q -dialogueGap=%.2f -speakerColors=%v\n", *ttsSpeakerMap, *dialogueGap, *speakerColors)
+		fmt.Printf("  -stripDirections=%v -sfxDir=%q -sfxVol=%.2f\n", *stripDirections, *sfxDir, *sfxVol)
+		fmt.Printf("  -timeout=%q\n", *timeout)
+		fmt.Printf("  -offline=%v\n", *offline)
+		fmt.Printf("  -qr=%q -qrPos=%s -qrSize=%.2f -qrDur=%.1f\n", *qrURL, *qrPos, *qrSize, *qrDur)
+		fmt.Printf("  work dir: %s (keep=%v)\n", work, *keepTemp)
+		fmt.Printf("  voice: %.3fs (+%.3fs delay), video: %.3fs, music: %.3fs\n", audDur, *voiceDelay, vidDur, musicDur)
+		fmt.Printf("  seeds: seed=%d (effective %d) randVideo=%v randMusic=%v\n", *seed, runSeed, *randVideo, *randMusic)
+		fmt.Printf("  -startCheck=%v step=%.2fs tries=%d\n", *startCheck, *startCheckStep, *startCheckTries)
+		fmt.Printf("  chosen offsets: videoStart=%.3fs musicStart=%.3fs\n", vStart, mStart)
+		fmt.Println("===================")
+	}
+
+	// Advisory: warn when the music sits on top of the speech band
+	maskScore := -1.0
+	if *maskCheck && !*subsOnly {
+		maskScore, err = analyzeMasking(ctx, voicePath, *music, *voiceDelay, outDur, musicDur, mStart,
+			*musicLoop, *voiceVol, *musicVol, eqFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: masking check skipped: %v\n", err)
+			maskScore = -1
+		} else if *debug {
+			fmt.Printf("masking score: %.2f (threshold %.2f)\n", maskScore, *maskThreshold)
+		}
+	}
+
+	// Decide ASS path (generated unless -noSubs)
+	finalASS := *assOut
+	if finalASS == "" && !*noSubs {
+		outDir := filepath.Dir(*out)
+		outBase := strings.TrimSuffix(filepath.Base(*out), filepath.Ext(*out))
+		finalASS = filepath.Join(outDir, outBase+".ass")
+	}
+
+	srtPath := *srtOut
+	switch srtPath {
+	case "none":
+		srtPath = ""
+	case "auto":
+		srtPath = strings.TrimSuffix(*out, filepath.Ext(*out)) + ".srt"
+	}
+	wordsPath := *wordsOut
+	switch wordsPath {
+	case "none":
+		wordsPath = ""
+	case "auto":
+		wordsPath = strings.TrimSuffix(*out, filepath.Ext(*out)) + ".words.json"
+	}
+	if wordsPath != "" && *wordsJSON != "" && samePath(wordsPath, *wordsJSON) {
+		fail("-wordsOut %s would overwrite -wordsJSON; write it elsewhere", wordsPath)
+	}
+
+	// Publishing: produce everything in staging, move into -publishDir at the end
+	outPath := *out
+	staging := ""
+	if *publishDir != "" {
+		_ = os.Remove(donePath(*publishDir, *out)) // stale marker from a previous run
+		staging, err = newStaging(*publishDir)
+		must(err, "create staging dir failed: %v", err)
+		atExit(func() { _ = os.RemoveAll(staging) })
+		outPath = filepath.Join(staging, filepath.Base(*out))
+		if finalASS != "" {
+			finalASS = filepath.Join(staging, filepath.Base(finalASS))
+		}
+		if srtPath != "" {
+			srtPath = filepath.Join(staging, filepath.Base(srtPath))
+		}
+		if wordsPath != "" {
+			wordsPath = filepath.Join(staging, filepath.Base(wordsPath))
+		}
+	}
+
+	offsetDropped, censored, translated, colored := 0, 0, 0, 0
+	var drift *subsDrift
+	var voiceCut *voiceCensor
+	whisperFallback, hallucinated := "", 0
+	if finalASS != "" {
+		// Built under a directory of this run's own and moved into place
+		// once complete: concurrent runs sharing an output or work
+		// directory cannot swap files, and a failed run leaves the previous
+		// ASS alone.
+		subsDir, err := os.MkdirTemp(work, "subs-")
+		must(err, "create subtitle dir failed: %v", err)
+		if !*keepTemp {
+			atExit(func() { _ = os.RemoveAll(subsDir) })
+		}
+		buildASS := filepath.Join(subsDir, filepath.Base(finalASS))
+		if subsInDoc != nil {
+			// -subsIn: copied (SRT converted) as the final ASS
+			must(writeASS(buildASS, subsInDoc), "write %s failed", buildASS)
+		} else if wordsDoc != nil {
+			must(writeASS(buildASS, wordsDoc), "write %s failed", buildASS)
+		} else if cppBin != "" {
+			if err := runWhisperCpp(ctx, cppBin, voicePath, subsDir, buildASS, whisper, *timeout); err != nil {
+				failCode(exitSubs, "unable to generate subtitles: %v", err)
+			}
+		} else {
+			// Generate word-level ASS from voice, bounded by -timeout; a
+			// partial file is kept under -keepTemp
+			must(ensureCallable(*py, "--version"), "python not callable: %s", *py)
+			used, retries, err := runWhisperLadder(ctx, whisper, whisperSteps, func(wo *whisperOptions) error {
+				_ = os.Remove(buildASS)
+				return runSubsGenerator(ctx, *py, *pyScript, voicePath, subsDir, buildASS, wo, *timeout)
+			})
+			if retries > 0 {
+				whisperFallback = fmt.Sprintf("%v (after %d retry(s) out of GPU memory)", used, retries)
+			}
+			if err != nil {
+				if *keepTemp && pathExists(buildASS) {
+					fmt.Fprintln(os.Stderr, "partial subtitles kept:", buildASS)
+				}
+				failCode(exitSubs, "unable to generate subtitles: %v", err)
+			}
+		}
+		// only whisper makes things up; hand-made and -wordsJSON timings
+		// are kept
+		if !*keepHallucinations && subsInDoc == nil && wordsDoc == nil {
+			silences, err := detectSilences(ctx, voicePath, secToCS(audDur), *timeout)
+			var dropped []droppedCue
+			if err == nil {
+				dropped, err = applyHallucinationFilter(buildASS, silences, hallucinations)
+			}
+			if err != nil {
+				// the captions are still usable unfiltered
+				fmt.Fprintf(os.Stderr, "WARNING: hallucination filter skipped: %v\n", err)
+			}
+			for _, c := range dropped {
+				fmt.Println("hallucination dropped:", c)
+			}
+			hallucinated = len(dropped)
+		}
+		// word timings are relative to the voice, so before -subsOffset
+		if wordsPath != "" {
+			lang := subsLang
+			if translation != nil {
+				lang = translation.from
+			}
+			n, err := writeWordsOut(buildASS, wordsPath, lang, audDur, *voiceDelay)
+			must(err, "write %s failed: %v", wordsPath, err)
+			if n == 0 {
+				fmt.Fprintf(os.Stderr, "WARNING: -wordsOut: the subtitles have no word-level events; %s lists no words\n", wordsPath)
+			}
+		}
+		if *subsOffset != 0 {
+			n, err := offsetASSFile(buildASS, secToCS(*subsOffset))
+			must(err, "subtitle offset failed: %v", err)
+			offsetDropped = n
+		}
+		// checked as shown, after -subsOffset; a -subsIn file is the user's
+		// own, so it is only cut with an explicit -subsDriftAction=clamp
+		driftAction := *subsDriftAction
+		if subsInDoc != nil && !flagSet("subsDriftAction") {
+			driftAction = "warn"
+		}
+		drift, err = checkSubsDrift(buildASS, secToCS(audDur), secToCS(*subsDriftTolerance), driftAction == "clamp")
+		must(err, "subtitle drift check failed: %v", err)
+		switch {
+		case drift.late() && driftAction == "fail":
+			fail("subtitles run past the voice: %v, over -subsDriftTolerance %gs; whisper may have hallucinated the end (-subsDriftAction=clamp cuts it)", drift, *subsDriftTolerance)
+		case drift.late() && driftAction == "warn":
+			fmt.Fprintf(os.Stderr, "WARNING: subtitles run past the voice: %v\n", drift)
+		case drift.early() && driftAction == "fail":
+			fail("subtitles end early: %v; was only part of the voice transcribed?", drift)
+		case drift.early():
+			fmt.Fprintf(os.Stderr, "WARNING: subtitles end early: %v; was only part of the voice transcribed?\n", drift)
+		}
+		if tmpl != nil {
+			n, err := applyASSTemplate(buildASS, tmpl)
+			must(err, "-assTemplate failed: %v", err)
+			if *debug {
+				fmt.Printf("subtitle template: %d event(s) in style %q\n", n, tmpl.style)
+			}
+		}
+		if dict != nil {
+			changes, err := applySubDict(buildASS, dict)
+			must(err, "subtitle dictionary failed: %v", err)
+			fmt.Printf("subtitle dictionary: %d replacement(s)\n", len(changes))
+			if *debug {
+				for _, c := range changes {
+					fmt.Println("  " + c)
+				}
+			}
+		}
+		if censor != nil {
+			n, spans, err := applyCensor(buildASS, censor, mask)
+			must(err, "censoring failed: %v", err)
+			censored = n
+			if len(spans) > 0 && *censorAudio != "off" {
+				voiceCut = &voiceCensor{spans: spans, bleep: *censorAudio == "bleep"}
+			}
+			if *debug {
+				fmt.Printf("censor: %d word(s) in %d span(s)\n", n, len(spans))
+			}
+		}
+		if translation != nil {
+			n, warn, err := applyTranslation(ctx, buildASS, translation, *sentenceMaxChars, secToCS(*cueGapMax), *timeout)
+			must(err, "subtitle translation failed: %v", err)
+			translated = n
+			for _, w := range warn {
+				fmt.Fprintln(os.Stderr, "WARNING: translate:", w)
+			}
+		}
+		if *subsCase != "none" {
+			n, err := applySubsCase(buildASS, *subsCase)
+			must(err, "subtitle case failed: %v", err)
+			if *debug {
+				fmt.Printf("subtitle case: %d event(s) recased\n", n)
+			}
+		}
+		// Only captions shown one at a time flicker; phrases and sentences
+		// are on screen for longer than any of their words. Hand-made
+		// timings are kept unless -subMinDuration is given.
+		oneAtATime := translation != nil || (*subStyleMode == "words" && !cjk && *maxWordsPerCue <= 1)
+		if *subMinDuration > 0 && oneAtATime && (subsInDoc == nil || flagSet("subMinDuration")) {
+			extended, merged, err := applyMinDuration(buildASS, secToCS(*subMinDuration), secToCS(audDur))
+			must(err, "subtitle minimum duration failed: %v", err)
+			if *debug {
+				fmt.Printf("subtitle minimum duration: %d caption(s) extended, %d merged into the next\n", extended, merged)
+			}
+		}
+		if len(subStyle) > 0 {
+			n, err := applyStyleFile(buildASS, subStyle)
+			must(err, "subtitle style failed: %v", err)
+			if *debug {
+				fmt.Printf("subtitle style: %d style(s) rewritten\n", n)
+			}
+		}
+		if *subSafeArea {
+			w, h, err := probeVideoSize(ctx, *video)
+			must(err, "probe video size failed: %v", err)
+			pct := safeBottomPct(*subSafeBottomPct, w, h)
+			rescaled, lifted, err := applySafeArea(buildASS, w, h, pct)
+			must(err, "subtitle safe area failed: %v", err)
+			if *debug {
+				fmt.Printf("subtitle safe area: %dx%d, bottom %g%% (PlayRes rescaled=%v, %d style(s) lifted)\n", w, h, pct, rescaled, lifted)
+			}
+		}
+		// hand-made timings are kept unless smoothing is asked for
+		if *subSmoothing == "on" && (subsInDoc == nil || flagSet("subSmoothing")) {
+			n, err := applyWordSmoothing(buildASS, secToCS(*subMinDuration))
+			must(err, "subtitle smoothing failed: %v", err)
+			if *debug {
+				fmt.Printf("subtitle smoothing: %d phrase(s)\n", n)
+			}
+		}
+		if cjk {
+			n, err := applyCJKSplit(buildASS, *subMaxCharsCJK)
+			must(err, "CJK word split failed: %v", err)
+			if *debug {
+				fmt.Printf("CJK captions: %d long word(s) split\n", n)
+			}
+		}
+		if len(palette) > 0 {
+			var pick *rand.Rand
+			if *subColorRandom {
+				pick = rng
+			}
+			n, err := applyColorCycle(buildASS, palette, pick)
+			must(err, "subtitle colour cycle failed: %v", err)
+			colored = n
+		}
+		switch {
+		case translation != nil:
+			// already sentence cues
+		case *subStyleMode == "sentence-highlight":
+			n, err := applySentenceHighlight(buildASS, *sentenceMaxChars, secToCS(*cueGapMax), subHighlight, subStyle["PrimaryColour"])
+			must(err, "sentence captions failed: %v", err)
+			if *debug {
+				fmt.Printf("sentence captions: %d sentence(s)\n", n)
+			}
+		case *subStyleMode == "karaoke":
+			words := *maxWordsPerCue
+			if words == 1 {
+				words = karaokePhraseWords
+			}
+			group := func(d *assDoc) [][]int { return groupWords(d, words, secToCS(*cueGapMax)) }
+			if cjk {
+				group = func(d *assDoc) [][]int { return groupChars(d, *subMaxCharsCJK, secToCS(*cueGapMax), cueBreakChars) }
+			}
+			n, err := applyKaraoke(buildASS, group, subHighlight, subStyle["PrimaryColour"])
+			must(err, "karaoke captions failed: %v", err)
+			if *debug {
+				fmt.Printf("karaoke captions: %d phrase(s)\n", n)
+			}
+		case cjk:
+			n, err := applyCharGrouping(buildASS, *subMaxCharsCJK, secToCS(*cueGapMax))
+			must(err, "caption grouping failed: %v", err)
+			if *debug {
+				fmt.Printf("caption grouping: %d word event(s) merged by characters\n", n)
+			}
+		case *maxWordsPerCue > 1:
+			n, err := applyCueGrouping(buildASS, *maxWordsPerCue, secToCS(*cueGapMax))
+			must(err, "caption grouping failed: %v", err)
+			if *debug {
+				fmt.Printf("caption grouping: %d word event(s) merged\n", n)
+			}
+		}
+		if secondary != nil {
+			n, bad, err := applySecondary(buildASS, secondary, *subSecondaryScale, secondaryColor, secToCS(*subsSecondaryTol))
+			must(err, "bilingual captions failed: %v", err)
+			if len(bad) > 0 {
+				fmt.Fprintf(os.Stderr, "WARNING: %d translation(s) from %s are off by more than %gs:\n", len(bad), *subsSecondary, *subsSecondaryTol)
+				for _, b := range bad[:min(len(bad), 5)] {
+					fmt.Fprintln(os.Stderr, "  "+b)
+				}
+			}
+			if *debug {
+				fmt.Printf("bilingual captions: %d caption(s) translated\n", n)
+			}
+		}
+		if *subMaxChars > 0 {
+			broken, split, err := applyLineBreaks(buildASS, *subMaxChars)
+			must(err, "caption line breaking failed: %v", err)
+			if *debug {
+				fmt.Printf("line breaking: %d caption(s) broken, %d split in time\n", broken, split)
+			}
+		}
+		if *subAnim == "pop" {
+			n, err := applyPopAnim(buildASS, *subAnimScale, *subAnimMs)
+			must(err, "caption animation failed: %v", err)
+			if *debug {
+				fmt.Printf("caption animation: %d caption(s) pop in\n", n)
+			}
+		}
+		if *subRegion != "" {
+			n, err := applySubRegion(buildASS, regionX, regionY)
+			must(err, "subtitle region failed: %v", err)
+			if *debug {
+				fmt.Printf("subtitle region: %d event(s) placed at %.3f,%.3f\n", n, regionX, regionY)
+			}
+		}
+		if *speakerColors {
+			n, err := applySpeakerColors(buildASS, speakerSpans(parts, spans, speakerNames))
+			must(err, "speaker colours failed: %v", err)
+			if *debug {
+				fmt.Printf("speaker colours: %d event(s)\n", n)
+			}
+		}
+		if *titleText != "" {
+			size, err := applyTitleCard(buildASS, *titleText, *titleDur, *titleFitMin, *titleFitMax)
+			must(err, "title card failed: %v", err)
+			if *debug {
+				fmt.Printf("title card: font size %d\n", size)
+			}
+		}
+		if *voiceDelay > 0 {
+			must(shiftASSFile(buildASS, secToCS(*voiceDelay)), "shift subtitles failed")
+		}
+		if srtPath != "" {
+			n, err := writeSRTFromASS(buildASS, srtPath)
+			must(err, "write SRT failed: %v", err)
+			if *debug {
+				fmt.Printf("srt: %d caption block(s)\n", n)
+			}
+		}
+		if *subsStripPunct {
+			n, err := applyStripPunct(buildASS)
+			must(err, "subtitle punctuation failed: %v", err)
+			if *debug {
+				fmt.Printf("subtitle punctuation: %d caption(s) trimmed\n", n)
+			}
+		}
+		if rtl {
+			n, err := applyRTL(buildASS)
+			must(err, "right-to-left captions failed: %v", err)
+			if *debug {
+				fmt.Printf("right-to-left captions: %d event(s) embedded\n", n)
+			}
+		}
+		must(moveFile(buildASS, finalASS), "move subtitles to %s failed", finalASS)
 	}
-
-	must(ensureInPath("ffmpeg"), "ffmpeg not in PATH")
-	must(ensureInPath("ffprobe"), "ffprobe not in PATH")
-
-	// Required inputs present + exist
-	if *video == "" || !pathExists(*video) {
-		fail("no background video")
+	absAss := ""
+	if finalASS != "" {
+		absAss, _ = filepath.Abs(finalASS)
 	}
-	if *music == "" || !pathExists(*music) {
-		fail("no background music")
+	if *subsOnly {
+		fmt.Println("subtitles:", absAss)
+		if srtPath != "" {
+			fmt.Println("srt:", srtPath)
+		}
+		if wordsPath != "" {
+			fmt.Println("words:", wordsPath)
+		}
+		if whisperFallback != "" {
+			fmt.Println("whisper:", whisperFallback)
+		}
+		if hallucinated > 0 {
+			fmt.Printf("hallucinations: %d caption(s) dropped\n", hallucinated)
+		}
+		if drift != nil {
+			fmt.Println("subtitle timing:", drift)
+		}
+		if translation != nil {
+			fmt.Printf("subtitles translated: %s -> %s via %s (%d caption(s))\n", translation.source(), translation.to, translation.backend(), translated)
+		}
+		if colored > 0 {
+			fmt.Printf("subtitle colours: %d word(s) from %s\n", colored, *subColorCycle)
+		}
+		return
 	}
-	if *out == "" {
-		fail("output path missing")
+	assPath := absAss
+	if !burnSubs {
+		assPath = ""
 	}
-	if *storyFile == "" || !pathExists(*storyFile) {
-		fail("no story text")
+	if assPath != "" && !filterSafe(assPath) {
+		// the filter cannot name this path; burn a copy under work instead
+		safe := filepath.Join(work, "burn.ass")
+		err := copyFile(assPath, safe)
+		must(err, "copy %s for the ass filter failed: %v", assPath, err)
+		assPath = safe
 	}
 
-	// TTS: always synthesize from story file
-	if _, err := os.Stat(*ttsBin); err != nil {
-		fail("tts not found at %s: %v", *ttsBin, err)
+	var qr *qrOverlay
+	if qrCodeData != nil {
+		w, h, err := probeVideoSize(ctx, *video)
+		must(err, "probe video size failed: %v", err)
+		qr, err = prepareQR(qrCodeData, *qrURL, work, *qrPos, *qrSize, *qrDur, outDur, safeBottomPct(*subSafeBottomPct, w, h), w, h)
+		must(err, "render QR failed: %v", err)
 	}
-	b, err := os.ReadFile(*storyFile)
-	must(err, "read story file failed: %v", err)
-	text := strings.TrimSpace(string(b))
-	if text == "" {
-		fail("no story text")
+
+	var subs *subStream
+	if subCodec != "" {
+		subs = &subStream{path: absAss, codec: subCodec, lang: *subLang}
 	}
-	_ = os.Remove(*voiceOut) // ensure fresh synth
-	if err := runTTS(*ttsBin, text, *ttsModel, *ttsSpeaker, *ttsSpeakerWav, *ttsLang, *ttsCUDA, *voiceOut, *timeout); err != nil {
-		fail("unable to merge video+speech")
+	// Nothing is drawn on soft-subtitled or unsubtitled video without a QR
+	// code, so a video stream the container accepts is copied instead of
+	// re-encoded. A copy can only start on a keyframe, which would put the
+	// picture out of step with the voice and captions, so only a video
+	// used from its start is copied.
+	copyVideo := false
+	if (*softSubs || *noSubs) && qr == nil && vStart == 0 {
+		kind, err := subCodecFor(*out)
+		codec, ok := videoCopyable(ctx, *video, kind)
+		copyVideo = ok && err == nil
+		if *debug {
+			fmt.Printf("video stream: %s (copy=%v)\n", codec, copyVideo)
+		}
 	}
-	voicePath := *voiceOut
 
-	// durations
-	audDur, err := probeDuration(voicePath)
-	must(err, "probe voice duration failed")
-	vidDur, err := probeDuration(*video)
-	must(err, "probe video duration failed")
-	musicDur, err := probeDuration(*music)
-	must(err, "probe music duration failed")
-
-	// PRNG
-	if *seed != 0 {
-		rand.Seed(*seed)
-	} else {
-		rand.Seed(time.Now().UnixNano())
+	// Single-pass final mux with randomized offsets. With a watermark the
+	// mix goes to Matroska with PCM audio first, so the AAC is encoded only
+	// once, after the watermark is added.
+	muxOut := outPath
+	if *audioWatermark {
+		muxOut = filepath.Join(work, "mixed.mkv")
+	}
+	if err := muxVideoVoiceMusic(
+		ctx, *video, muxVoice, *music, assPath, *fontsDir, muxOut, *timeout,
+		*useGPU, *gpuPreset, *gpuRC, *gpuCQ, *crf,
+		*voiceDelay, outDur, vidDur, musicDur,
+		*musicVol, *voiceVol, *musicLoop, eqFilter,
+		vStart, mStart, qr, subs, copyVideo, *audioWatermark, voiceCut, vmeta.containerTags(*metaTitle),
+	); err != nil {
+		_ = os.Remove(muxOut) // partial output
+		if errors.Is(err, context.Canceled) {
+			fail("%v", err)
+		}
+		fail("unable to merge video+background music")
+	}
+	if *audioWatermark {
+		id := *watermarkID
+		if id == "" {
+			id = jobFingerprint(text+*voiceIn, *out)
+		}
+		code := watermarkCode(id)
+		if err := applyAudioWatermark(ctx, muxOut, outPath, subCodec, *watermarkKey, code, wmDefaultStrength, *timeout); err != nil {
+			_ = os.Remove(outPath)
+			fail("audio watermark failed: %v", err)
+		}
+		fmt.Printf("watermark: id=%s code=%08x\n", id, code)
 	}
 
-	// Decide randomized starts
-	vStart := *videoStart
-	if vStart < 0 {
-		if *randVideo {
-			if audDur <= vidDur {
-				vStart = randRange(0, maxf(vidDur-audDur, 0))
-			} else {
-				vStart = randRange(0, vidDur) // will loop
+	if staging != "" {
+		done := donePath(*publishDir, *out)
+		arts := []*string{&outPath}
+		for _, p := range []*string{&absAss, &srtPath, &wordsPath} {
+			if *p != "" {
+				arts = append(arts, p)
 			}
-		} else {
-			vStart = 0
 		}
+		srcs := make([]string, len(arts))
+		for i, p := range arts {
+			srcs[i] = *p
+		}
+		dsts, err := publishArtifacts(*publishDir, srcs, done)
+		must(err, "publish failed: %v", err)
+		for i, p := range arts {
+			*p = dsts[i]
+		}
+		fmt.Println("published:", done)
 	}
-	mStart := *musicStart
-	if mStart < 0 {
-		if *randMusic {
-			if *musicLoop && audDur > musicDur {
-				mStart = randRange(0, musicDur) // will loop
+
+	fmt.Println("done:", outPath)
+	if srtPath != "" {
+		fmt.Println("srt:", srtPath)
+	}
+	if wordsPath != "" {
+		fmt.Println("words:", wordsPath)
+	}
+	if langNote == "detected" {
+		fmt.Println("tts language:", tts.lang, "(detected)")
+	}
+	if *ttsSpeakerWavDir != "" && tts.speakerWav != "" && *voiceIn == "" {
+		fmt.Println("tts reference:", tts.speakerWav)
+	}
+	if n := ttsFallbackUses.Load(); n > 0 {
+		fmt.Printf("tts fallback: %d chunk(s) voiced by %s; re-render for the intended voice\n", n, *ttsFallback)
+	}
+	if maskScore >= 0 {
+		fmt.Printf("masking score: %.2f\n", maskScore)
+		if maskScore > *maskThreshold {
+			if *musicEQ == "" {
+				fmt.Println("advice: music overlaps speech band heavily; consider -musicEQ=speechcarve or lowering -musicVol")
 			} else {
-				mStart = randRange(0, maxf(musicDur-audDur, 0))
+				fmt.Println("advice: music still overlaps speech band heavily; consider lowering -musicVol")
 			}
-		} else {
-			mStart = 0
 		}
 	}
-
-	if *debug {
-		fmt.Println("== parsed flags ==")
-		fmt.Printf("  -video=%q\n", *video)
-		fmt.Printf("  -music=%q\n", *music)
-		fmt.Printf("  -musicVol=%.3f -voiceVol=%.3f -musicLoop=%v\n", *musicVol, *voiceVol, *musicLoop)
-		fmt.Printf("  -out=%q\n", *out)
-		fmt.Printf("  -assOut=%q\n", *assOut)
-		fmt.Printf("  -python=%q\n", *py)
-		fmt.Printf("  -pyScript=%q\n", *pyScript)
-		fmt.Printf("  -whisperModel=%q\n", *whModel)
-		fmt.Printf("  -whisperCompute=%q\n", *whCompute)
-		fmt.Printf("  -ttsBin=%q\n", *ttsBin)
-		fmt.Printf("  -ttsModel=%q\n", *ttsModel)
-		fmt.Printf("  -ttsSpeaker=%q\n", *ttsSpeaker)
-		fmt.Printf("  -ttsSpeakerWav=%q\n", *ttsSpeakerWav)
-		fmt.Printf("  -ttsLang=%q\n", *ttsLang)
-		fmt.Printf("  -ttsCUDA=%v\n", *ttsCUDA)
-		fmt.Printf("  -timeout=%q\n", *timeout)
-		fmt.Printf("  voice: %.3fs, video: %.3fs, music: %.3fs\n", audDur, vidDur, musicDur)
-		fmt.Printf("  seeds: seed=%d randVideo=%v randMusic=%v\n", *seed, *randVideo, *randMusic)
-		fmt.Printf("  chosen offsets: videoStart=%.3fs musicStart=%.3fs\n", vStart, mStart)
-		fmt.Println("===================")
+	if censor != nil {
+		fmt.Printf("censored: %d word(s) (audio: %s)\n", censored, *censorAudio)
 	}
-
-	// Decide ASS path (always generate + burn)
-	finalASS := *assOut
-	if finalASS == "" {
-		outDir := filepath.Dir(*out)
-		outBase := strings.TrimSuffix(filepath.Base(*out), filepath.Ext(*out))
-		finalASS = filepath.Join(outDir, outBase+".ass")
+	if whisperFallback != "" {
+		fmt.Println("whisper:", whisperFallback)
 	}
-
-	// Generate word-level ASS from voice; device always cuda
-	must(ensureCallable(*py, "--version"), "python not callable: %s", *py)
-	assDir := filepath.Dir(finalASS)
-	tmpName := "subs.ass"
-	tmpASS := filepath.Join(assDir, tmpName)
-	_ = os.Remove(tmpASS)
-	_ = os.Remove(finalASS)
-
-	env := append(os.Environ(),
-		"WHISPER_MODEL="+*whModel,
-		"WHISPER_COMPUTE="+*whCompute,
-		"DEVICE=cuda",
-	)
-	cmd := exec.Command(*py, *pyScript, voicePath)
-	cmd.Env = env
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Dir = assDir // script writes subs.ass in its CWD
-	if err := cmd.Run(); err != nil {
-		fail("unable to generate subtitles")
+	if hallucinated > 0 {
+		fmt.Printf("hallucinations: %d caption(s) dropped\n", hallucinated)
 	}
-	if !pathExists(tmpASS) {
-		fail("unable to generate subtitles")
+	if drift != nil {
+		fmt.Println("subtitle timing:", drift)
 	}
-	must(os.Rename(tmpASS, finalASS), "rename %s -> %s failed", tmpASS, finalASS)
-	absAss, _ := filepath.Abs(finalASS)
-	assPath := absAss
-
-	// Single-pass final mux with randomized offsets
-	if err := muxVideoVoiceMusic(
-		*video, voicePath, *music, assPath, *out, *timeout,
-		*useGPU, *gpuPreset, *gpuRC, *gpuCQ,
-		audDur, vidDur, musicDur,
-		*musicVol, *voiceVol, *musicLoop,
-		vStart, mStart,
-	); err != nil {
-		fail("unable to merge video+background music")
+	if translation != nil {
+		fmt.Printf("subtitles translated: %s -> %s via %s (%d caption(s))\n", translation.source(), translation.to, translation.backend(), translated)
+	}
+	if colored > 0 {
+		fmt.Printf("subtitle colours: %d word(s) from %s\n", colored, *subColorCycle)
+	}
+	if *subsOffset != 0 {
+		fmt.Printf("subtitles offset: %+.2fs (%d cue(s) dropped)\n", float64(secToCS(*subsOffset))/100, offsetDropped)
+	}
+	switch {
+	case *noSubs:
+		fmt.Println("subtitles: none (-noSubs)")
+	case *softSubs:
+		fmt.Printf("subtitles: soft %s stream (%s), not burned\n", subCodec, *subLang)
+	case !burnSubs:
+		fmt.Println("subtitles: sidecar only (ffmpeg lacks the ass filter):", absAss)
+	case *attachSubs && subCodec != "":
+		fmt.Printf("subtitles: burned, and attached as a %s stream (%s)\n", subCodec, *subLang)
 	}
-
-	fmt.Println("done:", *out)
 }
 
 func muxVideoVoiceMusic(
-	video, voice, music, ass, out string, to time.Duration,
-	useGPU bool, gpuPreset, gpuRC, gpuCQ string,
-	audDur, vidDur, musicDur float64,
-	musicVol, voiceVol float64, musicLoop bool,
+	ctx context.Context,
+	video, voice, music, ass, fontsDir, out string, to time.Duration,
+	useGPU bool, gpuPreset, gpuRC, gpuCQ, crf string,
+	voiceDelay, outDur, vidDur, musicDur float64,
+	musicVol, voiceVol float64, musicLoop bool, musicEQ string,
 	videoStart, musicStart float64,
+	qr *qrOverlay, subs *subStream, copyVideo, pcmAudio bool, censor *voiceCensor, meta map[string]string,
 ) error {
 	args := []string{"-y"}
 
 	// Video input (seek + optional loop)
-	if audDur > vidDur {
+	if outDur > vidDur {
 		args = append(args, "-stream_loop", "-1") // applies to next input (video)
 	}
 	args = append(args, "-ss", fmtSec(videoStart), "-i", video)
@@ -247,114 +2053,161 @@ func muxVideoVoiceMusic(
 	args = append(args, "-i", voice)
 
 	// Music input (optional loop + seek)
-	if musicLoop && audDur > musicDur {
+	if musicLoop && outDur > musicDur {
 		args = append(args, "-stream_loop", "-1")
 	}
 	args = append(args, "-ss", fmtSec(musicStart), "-i", music)
 
-	// burn ASS
-	args = append(args, "-vf", "ass="+ass)
+	// QR image input (looped still)
+	if qr != nil {
+		args = append(args, "-loop", "1", "-i", qr.png)
+	}
+
+	// Subtitle file input, muxed as a stream
+	subInput := 3
+	if qr != nil {
+		subInput = 4
+	}
+	if subs != nil {
+		args = append(args, "-i", subs.path)
+	}
 
-	// limit to voice length
-	args = append(args, "-t", fmtSec(audDur))
+	// burn ASS (ass == "" -> no burn, e.g. soft subtitles or sidecar-only fallback)
+	vf := "null"
+	if ass != "" {
+		vf = assFilter(ass, fontsDir)
+		if qr == nil {
+			args = append(args, "-vf", vf)
+		}
+	}
+
+	// limit to delay + voice length
+	args = append(args, "-t", fmtSec(outDur))
 
 	// audio mixing
-	af := fmt.Sprintf(
-		"[1:a]volume=%g,aresample=async=1:first_pts=0,aformat=sample_rates=44100:channel_layouts=stereo[v];"+
-			"[2:a]volume=%g,aresample=async=1:first_pts=0,aformat=sample_rates=44100:channel_layouts=stereo[m];"+
+	if musicEQ != "" {
+		musicEQ += ","
+	}
+	delay := ""
+	if voiceDelay > 0 {
+		delay = fmt.Sprintf("adelay=%d:all=1,", int(math.Round(voiceDelay*1000)))
+	}
+	voiceIn, pre := "[1:a]", ""
+	if censor != nil {
+		voiceIn, pre = "[vcen]", censor.filter("[1:a]", "[vcen]")
+	}
+	af := pre + fmt.Sprintf(
+		"%s%svolume=%g,aresample=async=1:first_pts=0,aformat=sample_rates=44100:channel_layouts=stereo[v];"+
+			"[2:a]volume=%g,%saresample=async=1:first_pts=0,aformat=sample_rates=44100:channel_layouts=stereo[m];"+
 			"[v][m]amix=inputs=2:duration=first:dropout_transition=0,aresample=async=1[aout]",
-		voiceVol, musicVol,
+		voiceIn, delay, voiceVol, musicVol, musicEQ,
 	)
-	args = append(args, "-filter_complex", af, "-map", "0:v:0", "-map", "[aout]")
+	if qr != nil {
+		vg := fmt.Sprintf(
+			"[0:v]%s[vs];[3:v]format=rgba,fade=t=in:st=%s:d=0.5:alpha=1[qr];"+
+				"[vs][qr]overlay=x=%s:y=%s:enable='gte(t,%s)'[vout];",
+			vf, fmtSec(qr.start), qr.x, qr.y, fmtSec(qr.start),
+		)
+		args = append(args, "-filter_complex", vg+af, "-map", "[vout]", "-map", "[aout]")
+		args = append(args, "-metadata", "comment=Source: "+qr.url)
+	} else {
+		args = append(args, "-filter_complex", af, "-map", "0:v:0", "-map", "[aout]")
+	}
+
+	if subs != nil {
+		codec := subs.codec
+		if pcmAudio {
+			codec = "ass" // Matroska; converted with the audio
+		}
+		args = append(args, "-map", fmt.Sprintf("%d:s:0", subInput), "-c:s", codec,
+			"-metadata:s:s:0", "language="+subs.lang)
+	}
 
 	// encoder
-	if useGPU && hasEncoder("h264_nvenc") {
-		args = append(args, "-c:v", "h264_nvenc", "-preset", *gpuPreset, "-pix_fmt", "yuv420p")
-		switch strings.ToLower(*gpuRC) {
+	if copyVideo {
+		args = append(args, "-c:v", "copy")
+	} else if useGPU && hasEncoder("h264_nvenc") {
+		args = append(args, "-c:v", "h264_nvenc", "-preset", gpuPreset, "-pix_fmt", "yuv420p")
+		switch strings.ToLower(gpuRC) {
 		case "constqp":
-			args = append(args, "-rc", "constqp", "-qp", *gpuCQ)
+			args = append(args, "-rc", "constqp", "-qp", gpuCQ)
 		case "vbr":
-			args = append(args, "-rc", "vbr", "-cq", *gpuCQ, "-b:v", "0")
+			args = append(args, "-rc", "vbr", "-cq", gpuCQ, "-b:v", "0")
 		default:
-			args = append(args, "-rc", "vbr_hq", "-cq", *gpuCQ, "-b:v", "0", "-tune", "hq")
+			args = append(args, "-rc", "vbr_hq", "-cq", gpuCQ, "-b:v", "0", "-tune", "hq")
 		}
 	} else {
-		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", *gpuCQ, "-pix_fmt", "yuv420p")
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", crf, "-pix_fmt", "yuv420p")
+	}
+
+	for _, k := range sortedKeys(meta) {
+		args = append(args, "-metadata", k+"="+meta[k])
 	}
 
-	// audio + container flags
-	args = append(args, "-c:a", "aac", "-b:a", "192k", "-movflags", "+faststart", out)
+	// audio + container flags; pcmAudio leaves the AAC to the watermark pass
+	if pcmAudio {
+		args = append(args, "-c:a", "pcm_f32le", out)
+	} else {
+		args = append(args, "-c:a", "aac", "-b:a", "192k", "-movflags", "+faststart", out)
+	}
 
-	return runFFmpegErr(args, to)
+	return runFFmpegErr(ctx, args, to)
 }
 
 // --- helpers ---
 
-func runTTS(ttsBin, text, model, speaker, speakerWav, lang string, useCUDA bool, outPath string, to time.Duration) error {
-	args := []string{
-		"--text", text,
-		"--model_name", model,
-		"--out_path", outPath,
-	}
-	if speaker != "" {
-		args = append(args, "--speaker_idx", speaker)
-	}
-	if speakerWav != "" {
-		args = append(args, "--speaker_wav", speakerWav)
-	}
-	if lang != "" {
-		args = append(args, "--language_idx", lang)
-	}
-	if useCUDA {
-		args = append(args, "--use_cuda", "true")
-	} else {
-		args = append(args, "--use_cuda", "false")
-	}
-
-	fmt.Printf("running: %s %s\n", ttsBin, strings.Join(quote(args), " "))
-	var ctx context.Context
-	var cancel func()
-	if to > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), to)
-	} else {
-		ctx, cancel = context.WithCancel(context.Background())
-	}
+// runSubsGenerator runs the Python word-level ASS generator on voice and
+// checks that it wrote out. The script gets out as its second argument and
+// as $SUBS_OUT; scripts from before that wrote subs.ass into their CWD,
+// dir, which is still picked up. Relative paths are made absolute first.
+// A timeout kills the generator's process group.
+func runSubsGenerator(ctx context.Context, py, script, voice, dir, out string, wo *whisperOptions, to time.Duration) error {
+	ctx, cancel := stageContext(ctx, to)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, ttsBin, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
+	if strings.ContainsRune(py, filepath.Separator) {
+		py = absPath(py)
+	}
+	out = absPath(out)
+	legacy := filepath.Join(dir, "subs.ass")
+	_ = os.Remove(legacy)
+	cmd := newCommand(ctx, py, absPath(script), absPath(voice), out)
+	cmd.Env = append(append(os.Environ(), wo.env()...), "SUBS_OUT="+out)
+	var dl atomic.Bool
+	stderr := &tailBuffer{max: 16 << 10}
+	cmd.Stdout = &downloadWatch{w: os.Stdout, seen: &dl}
+	cmd.Stderr = &downloadWatch{w: io.MultiWriter(os.Stderr, stderr), seen: &dl}
+	cmd.Dir = dir
 	if err := cmd.Run(); err != nil {
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			return fmt.Errorf("tts timed out after %v", to)
+		if ctx.Err() != nil {
+			return stageError(ctx, "subtitle generator", to, err, downloadHint(&dl))
+		}
+		err = subsToolError(err, stderr.String())
+		if cudaOOMRe.MatchString(stderr.String()) {
+			return fmt.Errorf("%w: %w", errWhisperOOM, err)
 		}
 		return err
 	}
-	if _, err := os.Stat(outPath); err != nil {
-		return fmt.Errorf("tts did not produce %s", outPath)
+	if pathExists(out) {
+		return nil
 	}
-	return nil
+	if !pathExists(legacy) {
+		return fmt.Errorf("subtitle generator exited cleanly but wrote no %s (nor subs.ass in %s, which holds: %s)",
+			out, dir, dirListing(dir, 20))
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: %s wrote subs.ass into its working directory, which is deprecated; write the path given as the second argument (or $SUBS_OUT)\n", script)
+	return moveFile(legacy, out)
 }
 
-func runFFmpegErr(args []string, to time.Duration) error {
+func runFFmpegErr(ctx context.Context, args []string, to time.Duration) error {
 	fmt.Printf("running: ffmpeg %s\n", strings.Join(quote(args), " "))
-	var ctx context.Context
-	var cancel func()
-	if to > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), to)
-	} else {
-		ctx, cancel = context.WithCancel(context.Background())
-	}
+	ctx, cancel := stageContext(ctx, to)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd := newCommand(ctx, "ffmpeg", args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			return fmt.Errorf("ffmpeg timed out after %v", to)
-		}
-		return err
+		return stageError(ctx, "ffmpeg", to, err, "")
 	}
 	return nil
 }
@@ -379,8 +2232,8 @@ func ensureCallable(bin string, arg string) error {
 	return nil
 }
 
-func probeDuration(path string) (float64, error) {
-	cmd := exec.Command("ffprobe",
+func probeDuration(ctx context.Context, path string) (float64, error) {
+	cmd := newCommand(ctx, "ffprobe",
 		"-v", "error",
 		"-show_entries", "format=duration",
 		"-of", "default=noprint_wrappers=1:nokey=1",
@@ -401,10 +2254,32 @@ func probeDuration(path string) (float64, error) {
 	return sec, nil
 }
 
+func probeVideoSize(ctx context.Context, path string) (int, int, error) {
+	cmd := newCommand(ctx, "ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height",
+		"-of", "csv=s=x:p=0",
+		path,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, 0, err
+	}
+	s := strings.TrimSpace(string(out))
+	ws, hs, ok := strings.Cut(s, "x")
+	w, err1 := strconv.Atoi(ws)
+	h, err2 := strconv.Atoi(strings.TrimRight(hs, "x"))
+	if !ok || err1 != nil || err2 != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("parse video size %q", s)
+	}
+	return w, h, nil
+}
+
 func quote(s []string) []string {
 	res := make([]string, len(s))
 	for i, v := range s {
-		if strings.ContainsAny(v, " \t\"'") {
+		if strings.ContainsAny(v, " \t\r\n\"'\\$`") || !utf8.ValidString(v) {
 			res[i] = strconv.Quote(v)
 		} else {
 			res[i] = v
@@ -414,12 +2289,30 @@ func quote(s []string) []string {
 }
 
 func hasEncoder(name string) bool {
-	out, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
-	if err != nil {
-		return false
+	return ffmpegHas("encoders", name)
+}
+
+func hasFilter(name string) bool {
+	return ffmpegHas("filters", name)
+}
+
+// ffmpegLists caches `ffmpeg -encoders`/`-filters` output per kind.
+var ffmpegLists = map[string]string{}
+
+// ffmpegHas reports whether the ffmpeg build lists name under kind
+// ("encoders", "filters", ...). The second column of each row is the name.
+func ffmpegHas(kind, name string) bool {
+	out, ok := ffmpegLists[kind]
+	if !ok {
+		b, err := exec.Command("ffmpeg", "-hide_banner", "-"+kind).Output()
+		if err != nil {
+			return false
+		}
+		out = string(b)
+		ffmpegLists[kind] = out
 	}
 	want := strings.ToLower(strings.TrimSpace(name))
-	for _, line := range strings.Split(string(out), "\n") {
+	for _, line := range strings.Split(out, "\n") {
 		fields := strings.Fields(line)
 		if len(fields) >= 2 && strings.ToLower(fields[1]) == want {
 			return true
@@ -428,6 +2321,193 @@ func hasEncoder(name string) bool {
 	return false
 }
 
+// ffmpegVersion returns the first line of `ffmpeg -version`.
+func ffmpegVersion() string {
+	out, err := exec.Command("ffmpeg", "-version").Output()
+	if err != nil {
+		return "ffmpeg (unknown version)"
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimSpace(line)
+}
+
+// renameFile is os.Rename, swappable so the cross-device path can be exercised.
+var renameFile = os.Rename
+
+// moveFile moves src to dst. When they live on different filesystems
+// (EXDEV) it copies, fsyncs and removes src instead, preserving the mode.
+func moveFile(src, dst string) error {
+	err := renameFile(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	return copyMove(src, dst)
+}
+
+func copyMove(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp := dst + ".part"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, st.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n != st.Size() {
+		err = fmt.Errorf("short copy: %d of %d bytes", n, st.Size())
+	}
+	if err == nil {
+		err = os.Chmod(tmp, st.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("copy %s -> %s: %w", src, dst, err)
+	}
+	return os.Remove(src)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// flagSet reports whether name was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(s string) error { *l = append(*l, s); return nil }
+
+// expandStoryFiles splits comma lists and expands globs in the -storyFile
+// values, keeping their order. A value naming an existing file is taken
+// whole, so file names may contain commas. "-" (stdin) may appear once.
+func expandStoryFiles(vals []string) ([]string, error) {
+	var out []string
+	stdin := false
+	for _, v := range vals {
+		if v != "-" && pathExists(v) {
+			out = append(out, v)
+			continue
+		}
+		for _, p := range splitTrim(v, ",", -1) {
+			switch {
+			case p == "-":
+				if stdin {
+					return nil, fmt.Errorf("stdin (-) given twice")
+				}
+				stdin = true
+				out = append(out, p)
+			case strings.ContainsAny(p, "*?["):
+				m, err := filepath.Glob(p)
+				if err != nil {
+					return nil, err
+				}
+				if len(m) == 0 {
+					return nil, fmt.Errorf("%s matches no files", p)
+				}
+				out = append(out, m...)
+			case pathExists(p):
+				out = append(out, p)
+			default:
+				return nil, fmt.Errorf("%s not found", p)
+			}
+		}
+	}
+	return out, nil
+}
+
+// storyOffsets returns where each story starts on the voice timeline.
+// Without a timeline (a single synthesis call) there is one story at 0.
+func storyOffsets(spans []partSpan, firstPart []int) []float64 {
+	offs := make([]float64, len(firstPart))
+	for i, p := range firstPart {
+		if p < len(spans) {
+			offs[i] = spans[p].start
+		}
+	}
+	return offs
+}
+
+func fmtSecs(v []float64) string {
+	s := make([]string, len(v))
+	for i, f := range v {
+		s[i] = fmtSec(f)
+	}
+	return strings.Join(s, " ")
+}
+
+// preview shortens s to at most n runes on one line, for logs.
+func preview(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
+
+// readStory returns the trimmed story text from path, or from stdin when
+// path is "-".
+func readStory(path string, stdin io.Reader) (string, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = io.ReadAll(stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	return strings.TrimSpace(string(b)), err
+}
+
+// absPath returns p made absolute, or p if that fails.
+func absPath(p string) string {
+	if a, err := filepath.Abs(p); err == nil {
+		return a
+	}
+	return p
+}
+
+// samePath reports whether a and b name the same file, existing or not.
+func samePath(a, b string) bool {
+	if fa, err := os.Stat(a); err == nil {
+		if fb, err := os.Stat(b); err == nil {
+			return os.SameFile(fa, fb)
+		}
+	}
+	aa, err1 := filepath.Abs(a)
+	ab, err2 := filepath.Abs(b)
+	return err1 == nil && err2 == nil && aa == ab
+}
+
 func pathExists(p string) bool {
 	_, err := os.Stat(p)
 	return err == nil
@@ -440,15 +2520,38 @@ func must(err error, format string, a ...any) {
 }
 
 func fail(format string, a ...any) {
+	failCode(1, format, a...)
+}
+
+// exitSubs is the exit code when the subtitles cannot be generated, so a
+// -subsOnly caller can tell a transcription failure from any other.
+const exitSubs = 3
+
+func failCode(code int, format string, a ...any) {
 	fmt.Fprintf(os.Stderr, format+"\n", a...)
-	os.Exit(1)
+	runCleanups()
+	os.Exit(code)
+}
+
+// cleanups run on normal exit and on fail(), most recent first.
+var cleanups []func()
+
+func atExit(f func()) {
+	cleanups = append(cleanups, f)
+}
+
+func runCleanups() {
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+	cleanups = nil
 }
 
-func randRange(min, max float64) float64 {
+func randRange(rng *rand.Rand, min, max float64) float64 {
 	if max <= min {
 		return min
 	}
-	return min + rand.Float64()*(max-min)
+	return min + rng.Float64()*(max-min)
 }
 
 func maxf(a, b float64) float64 {
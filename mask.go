@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+)
+
+// Speech masking check: compares voice and music energy in the 1-4 kHz
+// intelligibility band, frame by frame, as they will be mixed.
+
+const (
+	maskRate     = 16000 // analysis sample rate
+	maskFrame    = 1024  // FFT size (64 ms)
+	maskBandLo   = 1000  // Hz
+	maskBandHi   = 4000  // Hz
+	maskMarginDB = 6     // music within this many dB of the voice counts as masking
+	maskVoicedDB = 30    // frames this far below the loud voice frames are pauses
+)
+
+// musicEQFilters are the -musicEQ presets, applied to the music branch.
+var musicEQFilters = map[string]string{
+	"":            "",
+	"speechcarve": "equalizer=f=2800:t=o:w=1:g=-5", // gentle one-octave dip around 2-4 kHz
+}
+
+// maskingScore is the fraction of voiced frames in which the music's band
+// energy comes within maskMarginDB of the voice's. voice and music are
+// mono samples at maskRate.
+func maskingScore(voice, music []float32) float64 {
+	vb := bandEnergyDB(voice)
+	mb := bandEnergyDB(music)
+	n := min(len(vb), len(mb))
+	if n == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), vb[:n]...)
+	sort.Float64s(sorted)
+	floor := sorted[n*95/100] - maskVoicedDB
+	voiced, masked := 0, 0
+	for i := 0; i < n; i++ {
+		if vb[i] < floor {
+			continue
+		}
+		voiced++
+		if mb[i] >= vb[i]-maskMarginDB {
+			masked++
+		}
+	}
+	if voiced == 0 {
+		return 0
+	}
+	return float64(masked) / float64(voiced)
+}
+
+// bandEnergyDB returns the Hann-windowed 1-4 kHz energy of each
+// non-overlapping frame, in dB.
+func bandEnergyDB(s []float32) []float64 {
+	lo := maskBandLo * maskFrame / maskRate
+	hi := maskBandHi * maskFrame / maskRate
+	buf := make([]complex128, maskFrame)
+	var out []float64
+	for off := 0; off+maskFrame <= len(s); off += maskFrame {
+		for i := range buf {
+			w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/maskFrame)
+			buf[i] = complex(float64(s[off+i])*w, 0)
+		}
+		fft(buf)
+		e := 1e-12
+		for k := lo; k <= hi; k++ {
+			a := cmplx.Abs(buf[k])
+			e += a * a
+		}
+		out = append(out, 10*math.Log10(e))
+	}
+	return out
+}
+
+// fft is an in-place radix-2 transform; len(a) must be a power of two.
+func fft(a []complex128) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u, v := a[start+k], a[start+k+size/2]*w
+				a[start+k], a[start+k+size/2] = u+v, u-v
+				w *= step
+			}
+		}
+	}
+}
+
+// decodePCM decodes path to mono float32 at maskRate. pre are input
+// options (seek, loop) and af an optional filter chain.
+func decodePCM(ctx context.Context, path string, pre []string, dur float64, af string) ([]float32, error) {
+	args := append([]string{"-hide_banner", "-nostats", "-loglevel", "error"}, pre...)
+	args = append(args, "-i", path, "-t", fmtSec(dur), "-vn")
+	if af != "" {
+		args = append(args, "-af", af)
+	}
+	args = append(args, "-ac", "1", "-ar", fmt.Sprint(maskRate), "-f", "f32le", "-")
+	out, err := newCommand(ctx, "ffmpeg", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	s := make([]float32, len(out)/4)
+	for i := range s {
+		s[i] = math.Float32frombits(binary.LittleEndian.Uint32(out[4*i:]))
+	}
+	return s, nil
+}
+
+// analyzeMasking decodes voice and music the way the mux will combine them
+// (same delay, gains, offset, looping and EQ) and scores the overlap.
+func analyzeMasking(ctx context.Context, voice, music string, voiceDelay, outDur, musicDur, musicStart float64,
+	musicLoop bool, voiceVol, musicVol float64, musicEQ string) (float64, error) {
+	vaf := fmt.Sprintf("volume=%g", voiceVol)
+	if voiceDelay > 0 {
+		vaf = fmt.Sprintf("adelay=%d:all=1,%s", int(math.Round(voiceDelay*1000)), vaf)
+	}
+	v, err := decodePCM(ctx, voice, nil, outDur, vaf)
+	if err != nil {
+		return 0, err
+	}
+	var pre []string
+	if musicLoop && outDur > musicDur {
+		pre = append(pre, "-stream_loop", "-1")
+	}
+	pre = append(pre, "-ss", fmtSec(musicStart))
+	af := fmt.Sprintf("volume=%g", musicVol)
+	if musicEQ != "" {
+		af += "," + musicEQ
+	}
+	m, err := decodePCM(ctx, music, pre, outDur, af)
+	if err != nil {
+		return 0, err
+	}
+	return maskingScore(v, m), nil
+}
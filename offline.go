@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// offlineTransport replaces http.DefaultTransport under -offline so a
+// network call from any code path fails loudly instead of reaching out.
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fmt.Fprintf(os.Stderr, "offline: blocked request to %s\n", req.URL.Redacted())
+	return nil, fmt.Errorf("network access disabled by -offline (request to %s)", req.URL.Host)
+}
+
+func installOfflineGuard() {
+	http.DefaultTransport = offlineTransport{}
+	http.DefaultClient.Transport = offlineTransport{}
+}
+
+// isURL reports whether an input would be opened over the network by
+// ffmpeg or a TTS tool rather than read from disk.
+func isURL(s string) bool {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok || scheme == "" || rest == "" || strings.EqualFold(scheme, "file") {
+		return false
+	}
+	for _, r := range scheme {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '+' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// offlineViolations lists the settings that need the network. inputs maps
+// flag names to the paths they were given.
+func offlineViolations(inputs map[string]string) []string {
+	var bad []string
+	for _, name := range sortedKeys(inputs) {
+		if isURL(inputs[name]) {
+			bad = append(bad, fmt.Sprintf("-%s=%s (remote input)", name, inputs[name]))
+		}
+	}
+	return bad
+}
+
+// offlineListViolations is offlineViolations for a flag holding comma
+// lists, possibly repeated: each entry is checked on its own.
+func offlineListViolations(name string, vals []string) []string {
+	var bad []string
+	for _, v := range vals {
+		for _, p := range splitList(v) {
+			if isURL(p) {
+				bad = append(bad, fmt.Sprintf("-%s=%s (remote input)", name, p))
+			}
+		}
+	}
+	return bad
+}
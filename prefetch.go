@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// downloadRe matches the progress lines Coqui and huggingface_hub print
+// while fetching model weights on a cold machine.
+var downloadRe = regexp.MustCompile(`(?i)downloading model|downloading .*(\.bin|\.pth|\.safetensors|model)|fetching \d+ files`)
+
+// downloadWatch tees tool output and notes whether a model download was
+// seen, so a timeout can be explained instead of looking like a hang.
+type downloadWatch struct {
+	w    io.Writer
+	seen *atomic.Bool
+}
+
+func (d *downloadWatch) Write(p []byte) (int, error) {
+	if !d.seen.Load() && downloadRe.Match(p) {
+		d.seen.Store(true)
+	}
+	return d.w.Write(p)
+}
+
+func downloadHint(seen *atomic.Bool) string {
+	if !seen.Load() {
+		return ""
+	}
+	return " (a model download was in progress; run `avmux prefetch` once to fetch models ahead of time)"
+}
+
+// Generous bounds for the prefetch steps: the first includes the download,
+// the second only has to load what is now cached.
+const (
+	prefetchFetchTimeout = 30 * time.Minute
+	prefetchLoadTimeout  = 2 * time.Minute
+)
+
+// runPrefetch drives each tool's own download path with a trivial job so the
+// configured models are cached before a real (time-bounded) run.
+func runPrefetch(ctx context.Context, tts *ttsOptions, py, pyScript string, wo *whisperOptions, work string) error {
+	wav := filepath.Join(work, "prefetch.wav")
+
+	if ttsRemote[tts.engine] {
+		fmt.Printf("prefetch: tts engine %s has no local model\n", tts.engine)
+	} else {
+		fmt.Printf("prefetch: tts model %s\n", tts.model)
+		t0 := time.Now()
+		if err := runTTS(ctx, tts, "Hello.", wav, prefetchFetchTimeout); err != nil {
+			return fmt.Errorf("tts prefetch: %w", err)
+		}
+		fmt.Printf("prefetch: tts ready after %v; verifying load\n", time.Since(t0).Round(time.Second))
+		t0 = time.Now()
+		if err := runTTS(ctx, tts, "Hello.", wav, prefetchLoadTimeout); err != nil {
+			return fmt.Errorf("tts load check: %w", err)
+		}
+		fmt.Printf("prefetch: tts loads in %v\n", time.Since(t0).Round(100*time.Millisecond))
+	}
+
+	fmt.Printf("prefetch: whisper model %s on %s\n", wo.model, wo.device)
+	tone := filepath.Join(work, "prefetch-1s.wav")
+	gen := newCommand(ctx, "ffmpeg", "-y", "-v", "error", "-f", "lavfi", "-i", "sine=frequency=440:duration=1", tone)
+	gen.Stderr = os.Stderr
+	if err := gen.Run(); err != nil {
+		return fmt.Errorf("generate sample audio: %w", err)
+	}
+	t0 := time.Now()
+	if err := runSubsGenerator(ctx, py, pyScript, tone, work, filepath.Join(work, "prefetch.ass"), wo, prefetchFetchTimeout); err != nil {
+		return fmt.Errorf("whisper prefetch: %w", err)
+	}
+	fmt.Printf("prefetch: whisper ready after %v; verifying load\n", time.Since(t0).Round(time.Second))
+	t0 = time.Now()
+	if err := runSubsGenerator(ctx, py, pyScript, tone, work, filepath.Join(work, "prefetch.ass"), wo, prefetchLoadTimeout); err != nil {
+		return fmt.Errorf("whisper load check: %w", err)
+	}
+	fmt.Printf("prefetch: whisper loads in %v\n", time.Since(t0).Round(100*time.Millisecond))
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+// Every child process is started through newCommand so it runs in its own
+// process group: cancelling the run (Ctrl-C, SIGTERM, -timeout) kills the
+// tool together with anything it spawned, e.g. python worker processes.
+
+func newCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	return cmd
+}
+
+// stageContext bounds one stage by to (0 -> only the parent's deadline).
+func stageContext(ctx context.Context, to time.Duration) (context.Context, context.CancelFunc) {
+	if to > 0 {
+		return context.WithTimeout(ctx, to)
+	}
+	return context.WithCancel(ctx)
+}
+
+// stageTimeout reads as a plain message but matches
+// errors.Is(err, context.DeadlineExceeded).
+type stageTimeout struct{ msg string }
+
+func (e *stageTimeout) Error() string { return e.msg }
+func (e *stageTimeout) Unwrap() error { return context.DeadlineExceeded }
+
+// stageError explains why a stage's command failed. Cancellation and
+// timeouts are wrapped so callers can test errors.Is(err, context.Canceled)
+// or context.DeadlineExceeded and still see which stage it was. hint is
+// appended to a timeout message.
+func stageError(ctx context.Context, stage string, to time.Duration, err error, hint string) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return &stageTimeout{fmt.Sprintf("%s timed out after %v%s", stage, to, hint)}
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("%s interrupted: %w", stage, context.Canceled)
+	}
+	return err
+}
+
+// errStalled marks a command killed by a stallWatch.
+var errStalled = errors.New("stalled")
+
+// stallError reports a stalled command; errors.Is(err, errStalled) holds.
+type stallError struct {
+	stage string
+	limit time.Duration
+}
+
+func (e *stallError) Error() string {
+	return fmt.Sprintf("%s stalled: no output for %v; killed", e.stage, e.limit)
+}
+func (e *stallError) Unwrap() error { return errStalled }
+
+// stallWatch tracks signs of life from a command: writes through its
+// writers and growth of the file it produces.
+type stallWatch struct {
+	last  atomic.Int64 // UnixNano of the last activity
+	limit time.Duration
+	ctx   context.Context
+}
+
+// minStallLimit is the shortest stall limit accepted; the watch polls at a
+// tenth of the limit.
+const minStallLimit = time.Second
+
+// watchStall returns a context that is cancelled once nothing has been
+// written through the watch's writers and file has not grown for limit.
+// limit 0 never fires. It runs inside whatever deadline ctx has, so a
+// stage timeout still applies.
+func watchStall(ctx context.Context, limit time.Duration, file string) (context.Context, *stallWatch) {
+	if limit <= 0 {
+		return ctx, &stallWatch{ctx: ctx}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	s := &stallWatch{limit: limit, ctx: ctx}
+	s.last.Store(time.Now().UnixNano())
+	go func() {
+		defer cancel(nil)
+		tick := time.NewTicker(min(limit/10, 5*time.Second))
+		defer tick.Stop()
+		var size int64 = -1
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-tick.C:
+				if fi, err := os.Stat(file); err == nil && fi.Size() != size {
+					size = fi.Size()
+					s.last.Store(now.UnixNano())
+				}
+				if now.Sub(time.Unix(0, s.last.Load())) >= limit {
+					cancel(errStalled)
+					return
+				}
+			}
+		}
+	}()
+	return ctx, s
+}
+
+// writer wraps w so that every write counts as activity.
+func (s *stallWatch) writer(w io.Writer) io.Writer { return activityWriter{w, s} }
+
+// err returns the stall error for stage if the watch fired, else nil.
+func (s *stallWatch) err(stage string) error {
+	if errors.Is(context.Cause(s.ctx), errStalled) {
+		return &stallError{stage, s.limit}
+	}
+	return nil
+}
+
+type activityWriter struct {
+	w io.Writer
+	s *stallWatch
+}
+
+func (a activityWriter) Write(p []byte) (int, error) {
+	a.s.last.Store(time.Now().UnixNano())
+	return a.w.Write(p)
+}
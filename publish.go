@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Publishing: artifacts are produced in a hidden staging directory inside
+// -publishDir, moved into place together, and only then is <out>.done
+// written. Watchers should treat the .done marker as the signal that the
+// set is complete; a crashed run never writes one.
+
+func newStaging(publishDir string) (string, error) {
+	if err := os.MkdirAll(publishDir, 0o755); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(publishDir, ".avmux-staging-")
+}
+
+// donePath is the completion marker for an output file name.
+func donePath(publishDir, out string) string {
+	return filepath.Join(publishDir, filepath.Base(out)+".done")
+}
+
+// publishArtifacts moves files into dir and writes the done marker listing
+// each artifact's SHA-256 plus a hash over that list.
+func publishArtifacts(dir string, files []string, done string) ([]string, error) {
+	var list strings.Builder
+	var dsts []string
+	for _, f := range files {
+		sum, err := fileSHA256(f)
+		if err != nil {
+			return dsts, err
+		}
+		dst := filepath.Join(dir, filepath.Base(f))
+		if err := moveFile(f, dst); err != nil {
+			return dsts, fmt.Errorf("publish %s: %w", f, err)
+		}
+		dsts = append(dsts, dst)
+		fmt.Fprintf(&list, "%s  %s\n", sum, filepath.Base(f))
+	}
+	setSum := sha256.Sum256([]byte(list.String()))
+	content := list.String() + fmt.Sprintf("set  %x\n", setSum)
+	tmp := done + ".part"
+	if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+		return dsts, err
+	}
+	return dsts, os.Rename(tmp, done)
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Minimal QR code encoder: byte mode, error correction level M, versions
+// 1-10 (up to 213 bytes), which covers any reasonable source URL.
+
+// qrBlocks describes the level-M block structure per version: EC codewords
+// per block and the data codewords of each block.
+var qrBlocks = [...]struct {
+	ec   int
+	data []int
+}{
+	1:  {10, []int{16}},
+	2:  {16, []int{28}},
+	3:  {26, []int{44}},
+	4:  {18, []int{32, 32}},
+	5:  {24, []int{43, 43}},
+	6:  {16, []int{27, 27, 27, 27}},
+	7:  {18, []int{31, 31, 31, 31}},
+	8:  {22, []int{38, 38, 39, 39}},
+	9:  {22, []int{36, 36, 36, 37, 37}},
+	10: {26, []int{43, 43, 43, 43, 44}},
+}
+
+var qrAlign = [...][]int{
+	2: {6, 18}, 3: {6, 22}, 4: {6, 26}, 5: {6, 30}, 6: {6, 34},
+	7: {6, 22, 38}, 8: {6, 24, 42}, 9: {6, 26, 46}, 10: {6, 28, 50},
+}
+
+// qrCode is a square module matrix, true = dark.
+type qrCode struct {
+	size    int
+	modules [][]bool
+	fn      [][]bool // function patterns, excluded from data and masking
+}
+
+func encodeQR(text string) (*qrCode, error) {
+	data := []byte(text)
+	version := 0
+	for v := 1; v < len(qrBlocks); v++ {
+		ccBits := 8
+		if v >= 10 {
+			ccBits = 16
+		}
+		if 4+ccBits+8*len(data) <= 8*sumInts(qrBlocks[v].data) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("qr: %d bytes is too long (max 213)", len(data))
+	}
+
+	// bit stream: mode, count, data, terminator, padding
+	var bits []bool
+	put := func(v, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, v>>i&1 == 1)
+		}
+	}
+	put(0b0100, 4)
+	if version >= 10 {
+		put(len(data), 16)
+	} else {
+		put(len(data), 8)
+	}
+	for _, b := range data {
+		put(int(b), 8)
+	}
+	capBits := 8 * sumInts(qrBlocks[version].data)
+	put(0, min(4, capBits-len(bits)))
+	put(0, (8-len(bits)%8)%8)
+	for pad := 0xec; len(bits) < capBits; pad ^= 0xec ^ 0x11 {
+		put(pad, 8)
+	}
+	cw := make([]byte, len(bits)/8)
+	for i, b := range bits {
+		if b {
+			cw[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+
+	// split into blocks, add EC, interleave
+	blk := qrBlocks[version]
+	div := rsDivisor(blk.ec)
+	var dataBlocks, ecBlocks [][]byte
+	off := 0
+	for _, n := range blk.data {
+		d := cw[off : off+n]
+		off += n
+		dataBlocks = append(dataBlocks, d)
+		ecBlocks = append(ecBlocks, rsRemainder(d, div))
+	}
+	var final []byte
+	for i := 0; i < blk.data[len(blk.data)-1]; i++ {
+		for _, d := range dataBlocks {
+			if i < len(d) {
+				final = append(final, d[i])
+			}
+		}
+	}
+	for i := 0; i < blk.ec; i++ {
+		for _, e := range ecBlocks {
+			final = append(final, e[i])
+		}
+	}
+
+	q := newQR(version)
+	q.placeData(final)
+	best, bestPenalty := 0, -1
+	for m := 0; m < 8; m++ {
+		q.applyMask(m)
+		q.drawFormat(m)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = m, p
+		}
+		q.applyMask(m) // undo (XOR)
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+	return q, nil
+}
+
+func newQR(version int) *qrCode {
+	size := 17 + 4*version
+	q := &qrCode{size: size, modules: make([][]bool, size), fn: make([][]bool, size)}
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.fn[i] = make([]bool, size)
+	}
+	for i := 0; i < size; i++ { // timing
+		q.setFn(6, i, i%2 == 0)
+		q.setFn(i, 6, i%2 == 0)
+	}
+	q.finder(3, 3)
+	q.finder(size-4, 3)
+	q.finder(3, size-4)
+	if version >= 2 {
+		pos := qrAlign[version]
+		last := len(pos) - 1
+		for i, x := range pos {
+			for j, y := range pos {
+				if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 { // finder corners
+					continue
+				}
+				for dy := -2; dy <= 2; dy++ {
+					for dx := -2; dx <= 2; dx++ {
+						q.setFn(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+					}
+				}
+			}
+		}
+	}
+	q.drawFormat(0) // reserve the format areas
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1f25
+		}
+		v := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			a, b := size-11+i%3, i/3
+			q.setFn(a, b, v>>i&1 == 1)
+			q.setFn(b, a, v>>i&1 == 1)
+		}
+	}
+	return q
+}
+
+func (q *qrCode) setFn(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.fn[y][x] = true
+}
+
+// finder draws a finder pattern with its separator, centred at (cx, cy).
+func (q *qrCode) finder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || y < 0 || x >= q.size || y >= q.size {
+				continue
+			}
+			d := max(abs(dx), abs(dy))
+			q.setFn(x, y, d != 2 && d != 4)
+		}
+	}
+}
+
+// drawFormat writes both copies of the format information for level M.
+func (q *qrCode) drawFormat(mask int) {
+	data := 0b00<<3 | mask // level M
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+	for i := 0; i <= 5; i++ {
+		q.setFn(8, i, bit(i))
+	}
+	q.setFn(8, 7, bit(6))
+	q.setFn(8, 8, bit(7))
+	q.setFn(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFn(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.setFn(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFn(8, q.size-15+i, bit(i))
+	}
+	q.setFn(8, q.size-8, true) // dark module
+}
+
+// placeData fills the non-function modules in the standard zigzag order.
+func (q *qrCode) placeData(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.fn[y][x] && i < len(data)*8 {
+					q.modules[y][x] = data[i>>3]>>(7-i&7)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var inv bool
+			switch mask {
+			case 0:
+				inv = (x+y)%2 == 0
+			case 1:
+				inv = y%2 == 0
+			case 2:
+				inv = x%3 == 0
+			case 3:
+				inv = (x+y)%3 == 0
+			case 4:
+				inv = (x/3+y/2)%2 == 0
+			case 5:
+				inv = x*y%2+x*y%3 == 0
+			case 6:
+				inv = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				inv = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if inv && !q.fn[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores a masked matrix with the four standard rules; lower is
+// easier to scan.
+func (q *qrCode) penalty() int {
+	n := q.size
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+	p, dark := 0, 0
+	finderLike := []bool{true, false, true, true, true, false, true}
+	for _, tr := range []bool{false, true} {
+		for y := 0; y < n; y++ {
+			run := 1
+			for x := 1; x <= n; x++ {
+				if x < n && at(x, y, tr) == at(x-1, y, tr) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					p += 3 + run - 5
+				}
+				run = 1
+			}
+			for x := 0; x+7 <= n; x++ {
+				match := true
+				for k, v := range finderLike {
+					if at(x+k, y, tr) != v {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				lightBefore, lightAfter := true, true
+				for k := 1; k <= 4; k++ {
+					if x-k >= 0 && at(x-k, y, tr) {
+						lightBefore = false
+					}
+					if x+6+k < n && at(x+6+k, y, tr) {
+						lightAfter = false
+					}
+				}
+				if lightBefore || lightAfter {
+					p += 40
+				}
+			}
+		}
+	}
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < n && y+1 < n {
+				c := q.modules[y][x]
+				if q.modules[y][x+1] == c && q.modules[y+1][x] == c && q.modules[y+1][x+1] == c {
+					p += 3
+				}
+			}
+		}
+	}
+	p += abs(dark*20-n*n*10) / (n * n) * 10
+	return p
+}
+
+// writePNG renders the code with the standard 4-module white quiet zone,
+// which keeps it scannable over any background.
+func (q *qrCode) writePNG(path string, moduleSize int) (int, error) {
+	const quiet = 4
+	px := (q.size + 2*quiet) * moduleSize
+	img := image.NewGray(image.Rect(0, 0, px, px))
+	for y := 0; y < px; y++ {
+		for x := 0; x < px; x++ {
+			mx, my := x/moduleSize-quiet, y/moduleSize-quiet
+			c := color.Gray{Y: 255}
+			if mx >= 0 && my >= 0 && mx < q.size && my < q.size && q.modules[my][mx] {
+				c = color.Gray{Y: 0}
+			}
+			img.SetGray(x, y, c)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return 0, err
+	}
+	return px, f.Close()
+}
+
+// --- Reed-Solomon over GF(256), polynomial 0x11d ---
+
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11d
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+func rsDivisor(degree int) []byte {
+	res := make([]byte, degree)
+	res[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range res {
+			res[j] = gfMul(res[j], root)
+			if j+1 < degree {
+				res[j] ^= res[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return res
+}
+
+func rsRemainder(data, div []byte) []byte {
+	res := make([]byte, len(div))
+	for _, b := range data {
+		factor := b ^ res[0]
+		copy(res, res[1:])
+		res[len(res)-1] = 0
+		for i := range res {
+			res[i] ^= gfMul(div[i], factor)
+		}
+	}
+	return res
+}
+
+func sumInts(a []int) int {
+	s := 0
+	for _, v := range a {
+		s += v
+	}
+	return s
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// qrOverlay is a rendered QR code composited over the video from start on.
+type qrOverlay struct {
+	png   string
+	url   string
+	x, y  string // overlay filter position expressions
+	start float64
+}
+
+// prepareQR renders code into workDir sized for a w x h output and places
+// it in the given corner, inset by a safe margin; bottom corners also stay
+// above the bottomPct band that platform buttons cover.
+func prepareQR(code *qrCode, url, workDir, pos string, sizeFrac, dur, total, bottomPct float64, w, h int) (*qrOverlay, error) {
+	short := min(w, h)
+	const quiet = 4
+	module := max(2, int(sizeFrac*float64(short))/(code.size+2*quiet))
+	path := filepath.Join(workDir, "qr.png")
+	if _, err := code.writePNG(path, module); err != nil {
+		return nil, err
+	}
+	m := short * 4 / 100
+	o := &qrOverlay{png: path, url: url, x: strconv.Itoa(m), y: strconv.Itoa(m), start: maxf(total-dur, 0)}
+	if strings.HasSuffix(pos, "r") {
+		o.x = fmt.Sprintf("main_w-overlay_w-%d", m)
+	}
+	if strings.HasPrefix(pos, "b") {
+		o.y = fmt.Sprintf("main_h-overlay_h-%d", m+int(bottomPct*float64(h)/100))
+	}
+	return o, nil
+}
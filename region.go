@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// subRegions are the named -subRegion anchors as normalized canvas points.
+// split-boundary is the seam of an even top/bottom split.
+var subRegions = map[string][2]float64{
+	"center":         {0.5, 0.5},
+	"lower-third":    {0.5, 5.0 / 6},
+	"split-boundary": {0.5, 0.5},
+}
+
+// parseSubRegion accepts a named anchor or "x,y" in 0..1.
+func parseSubRegion(s string) (x, y float64, err error) {
+	if p, ok := subRegions[s]; ok {
+		return p[0], p[1], nil
+	}
+	xs, ys, ok := strings.Cut(s, ",")
+	if ok {
+		x, err1 := strconv.ParseFloat(strings.TrimSpace(xs), 64)
+		y, err2 := strconv.ParseFloat(strings.TrimSpace(ys), 64)
+		if err1 == nil && err2 == nil && x >= 0 && x <= 1 && y >= 0 && y <= 1 {
+			return x, y, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("want center|lower-third|split-boundary or x,y in 0..1, got %q", s)
+}
+
+// placeSubtitles centres every dialogue line on (x, y) of the canvas via a
+// leading \an5\pos override. libass scales PlayRes to the output frame, so
+// the point is relative to the final picture. Events that already position
+// themselves are left alone.
+func placeSubtitles(d *assDoc, x, y float64) int {
+	w, h := d.playRes()
+	tag := fmt.Sprintf(`{\an5\pos(%d,%d)}`, int(math.Round(x*float64(w))), int(math.Round(y*float64(h))))
+	n := 0
+	for _, i := range d.dialogues() {
+		t := d.events[i].text
+		if strings.Contains(t, `\pos(`) || strings.Contains(t, `\move(`) {
+			continue
+		}
+		d.events[i].text = tag + t
+		n++
+	}
+	return n
+}
+
+func applySubRegion(path string, x, y float64) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	n := placeSubtitles(d, x, y)
+	return n, writeASS(path, d)
+}
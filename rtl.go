@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Right-to-left captions (-subRTL): libass lays every line out with a
+// left-to-right base direction, so an Arabic or Hebrew sentence holding a
+// number or a Latin name comes out with its parts in the wrong order.
+// Each line with RTL letters is wrapped in a right-to-left embedding
+// (RLE ... PDF), which libass resolves but does not draw. Styles get a font
+// with the script's glyphs unless -subFont names one. Cue grouping and line
+// breaking join and split only at spaces, so words are never cut inside a
+// ligature.
+
+const (
+	rle = "\u202B" // RIGHT-TO-LEFT EMBEDDING
+	pdf = "\u202C" // POP DIRECTIONAL FORMATTING
+)
+
+// rtlFonts maps the whisper codes of RTL languages to the font their
+// captions default to.
+var rtlFonts = map[string]string{
+	"ar": "Noto Naskh Arabic",
+	"fa": "Noto Naskh Arabic",
+	"ur": "Noto Nastaliq Urdu",
+	"ps": "Noto Naskh Arabic",
+	"sd": "Noto Naskh Arabic",
+	"he": "Noto Sans Hebrew",
+	"yi": "Noto Sans Hebrew",
+}
+
+func isRTLRune(r rune) bool {
+	return unicode.In(r, unicode.Arabic, unicode.Hebrew, unicode.Syriac, unicode.Thaana, unicode.Nko)
+}
+
+// rtlLine wraps each line (\N-separated) of an event's text that has RTL
+// letters in an RTL embedding, inside the line's leading override tags.
+// Lines already embedded are left as they are.
+func rtlLine(text string) string {
+	lines := strings.Split(text, `\N`)
+	for i, l := range lines {
+		lead := leadingTagsRe.FindString(l)
+		body := l[len(lead):]
+		if strings.HasPrefix(body, rle) || strings.IndexFunc(plainText(body), isRTLRune) < 0 {
+			continue
+		}
+		lines[i] = lead + rle + body + pdf
+	}
+	return strings.Join(lines, `\N`)
+}
+
+// applyRTL embeds the RTL lines of the ASS file at path and returns how
+// many events changed. It runs after every other text pass, so none of
+// them sees the control characters.
+func applyRTL(path string) (int, error) {
+	return editDialogues(path, func(ev *assEvent, _ *assDoc) string { return rtlLine(ev.text) })
+}
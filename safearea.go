@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Safe area (-subSafeArea): captions are fitted to the frame they are
+// burned into. A script whose PlayRes does not match the output (the
+// generator writes 1920x1080 whatever the video) is moved onto the output
+// size, with font sizes, outlines and margins rescaled so libass does not
+// stretch them, and bottom-aligned captions are lifted above the bottom
+// -subSafeBottomPct of the frame, where Shorts and Reels draw their
+// buttons and descriptions.
+
+// verticalSafeBottomPct is the bottom safe area of vertical (9:16) output
+// unless -subSafeBottomPct is given.
+const verticalSafeBottomPct = 18
+
+// safeBottomPct resolves -subSafeBottomPct: negative means the default
+// for the output, verticalSafeBottomPct when taller than wide, else none.
+func safeBottomPct(pct float64, w, h int) float64 {
+	switch {
+	case pct >= 0:
+		return pct
+	case h > w:
+		return verticalSafeBottomPct
+	}
+	return 0
+}
+
+var (
+	posTagRe  = regexp.MustCompile(`\\(pos|move|org)\(([^)]*)\)`)
+	fontTagRe = regexp.MustCompile(`\\(fs|bord|shad|fsp)([0-9.]+)`)
+)
+
+func scaleNum(s string, f float64) string {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return s
+	}
+	return strconv.FormatFloat(math.Round(v*f*100)/100, 'f', -1, 64)
+}
+
+// scaleTags rescales the coordinates of \pos, \move and \org by fx, fy
+// and font sizes, borders and shadows by fs in the override blocks of an
+// event's text. The times of \move are kept.
+func scaleTags(text string, fx, fy, fs float64) string {
+	if !strings.Contains(text, "{") {
+		return text
+	}
+	text = posTagRe.ReplaceAllStringFunc(text, func(m string) string {
+		sm := posTagRe.FindStringSubmatch(m)
+		args := strings.Split(sm[2], ",")
+		for i := range args {
+			if i < 4 {
+				args[i] = scaleNum(args[i], []float64{fx, fy}[i%2])
+			}
+		}
+		return `\` + sm[1] + "(" + strings.Join(args, ",") + ")"
+	})
+	return fontTagRe.ReplaceAllStringFunc(text, func(m string) string {
+		sm := fontTagRe.FindStringSubmatch(m)
+		return `\` + sm[1] + scaleNum(sm[2], fs)
+	})
+}
+
+// fitPlayRes moves d onto a w x h canvas and reports whether it had to.
+// Horizontal sizes scale with the width, vertical with the height, and
+// font sizes with the smaller of the two so lines still fit across.
+func (d *assDoc) fitPlayRes(w, h int) (bool, error) {
+	ow, oh := d.playRes()
+	if ow == w && oh == h {
+		return false, nil
+	}
+	fx, fy := float64(w)/float64(ow), float64(h)/float64(oh)
+	fs := min(fx, fy)
+	scales := map[string]float64{
+		"Fontsize": fs, "Outline": fs, "Shadow": fs, "Spacing": fs,
+		"MarginL": fx, "MarginR": fx, "MarginV": fy,
+	}
+	_, err := d.editStyles(func(format, fields []string) error {
+		for name, f := range scales {
+			if j := indexFold(format, name); j >= 0 {
+				if m, err := strconv.Atoi(fields[j]); err == nil && strings.HasPrefix(name, "Margin") {
+					fields[j] = strconv.Itoa(int(math.Round(float64(m) * f)))
+					continue
+				}
+				fields[j] = scaleNum(fields[j], f)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	for i := range d.events {
+		ev := &d.events[i]
+		for name, f := range map[string]float64{"MarginL": fx, "MarginR": fx, "MarginV": fy} {
+			if v, err := strconv.Atoi(ev.get(d, name)); err == nil && v != 0 {
+				ev.set(d, name, strconv.Itoa(int(math.Round(float64(v)*f))))
+			}
+		}
+		ev.text = scaleTags(ev.text, fx, fy, fs)
+	}
+	d.setInfo("PlayResX", strconv.Itoa(w))
+	d.setInfo("PlayResY", strconv.Itoa(h))
+	return true, nil
+}
+
+// bottomAligned reports whether an ASS v4+ Alignment value is one of the
+// bottom row.
+func bottomAligned(align string) bool {
+	a, err := strconv.Atoi(strings.TrimSpace(align))
+	return err == nil && a >= 1 && a <= 3
+}
+
+// liftAboveBottom raises the vertical margin of bottom-aligned styles, and
+// of events overriding it, to at least minV and returns how many styles it
+// raised.
+func (d *assDoc) liftAboveBottom(minV int) (int, error) {
+	raised := map[string]bool{}
+	_, err := d.editStyles(func(format, fields []string) error {
+		a, m := indexFold(format, "Alignment"), indexFold(format, "MarginV")
+		if a < 0 || m < 0 || !bottomAligned(fields[a]) {
+			return nil
+		}
+		if v, _ := strconv.Atoi(fields[m]); v < minV {
+			fields[m] = strconv.Itoa(minV)
+			raised[fields[0]] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i := range d.events {
+		ev := &d.events[i]
+		if !bottomAligned(d.styleField(ev.get(d, "Style"), "Alignment")) {
+			continue
+		}
+		if v, err := strconv.Atoi(ev.get(d, "MarginV")); err == nil && v != 0 && v < minV {
+			ev.set(d, "MarginV", strconv.Itoa(minV))
+		}
+	}
+	return len(raised), nil
+}
+
+// applySafeArea fits the ASS file at path to a w x h output and keeps
+// bottom captions above the bottom pct percent of it. It reports whether
+// PlayRes was rescaled and how many styles were lifted.
+func applySafeArea(path string, w, h int, pct float64) (rescaled bool, lifted int, err error) {
+	d, err := readASS(path)
+	if err != nil {
+		return false, 0, err
+	}
+	if rescaled, err = d.fitPlayRes(w, h); err != nil {
+		return false, 0, fmt.Errorf("%s: %w", path, err)
+	}
+	if pct > 0 {
+		if lifted, err = d.liftAboveBottom(int(math.Round(pct / 100 * float64(h)))); err != nil {
+			return false, 0, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if !rescaled && lifted == 0 {
+		return false, 0, nil
+	}
+	return rescaled, lifted, writeASS(path, d)
+}
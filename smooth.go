@@ -0,0 +1,218 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Word timing smoothing. Whisper's word timestamps jitter by tens of
+// milliseconds in fast speech, so one-word captions flash or hang. Within
+// each phrase (a run of one-word events with short gaps) the inter-word
+// gaps are averaged and the speaking time is shared out by word length.
+// Phrase start and end never move, so the total duration is unchanged.
+
+const smoothPhraseGap = 30 // cs; a longer pause starts a new phrase
+
+var overrideRe = regexp.MustCompile(`\{[^}]*\}`)
+
+// plainText strips override blocks and turns \N/\n/\h into spaces.
+func plainText(s string) string {
+	s = overrideRe.ReplaceAllString(s, "")
+	return strings.NewReplacer(`\N`, " ", `\n`, " ", `\h`, " ").Replace(s)
+}
+
+// smoothWordTimings adjusts the one-word Dialogue events of d and returns
+// how many phrases were smoothed. minDur is the shortest a word may show,
+// in centiseconds.
+func smoothWordTimings(d *assDoc, minDur int) int {
+	words := wordEvents(d)
+
+	n := 0
+	for lo := 0; lo < len(words); {
+		hi := lo + 1
+		for hi < len(words) && d.events[words[hi]].start-d.events[words[hi-1]].end <= smoothPhraseGap &&
+			d.events[words[hi]].get(d, "Style") == d.events[words[lo]].get(d, "Style") {
+			hi++
+		}
+		if hi-lo >= 2 {
+			smoothPhrase(d, words[lo:hi], minDur)
+			n++
+		}
+		lo = hi
+	}
+	return n
+}
+
+func smoothPhrase(d *assDoc, idx []int, minDur int) {
+	k := len(idx)
+	evs := make([]*assEvent, k)
+	for i, j := range idx {
+		evs[i] = &d.events[j]
+	}
+	start := evs[0].start
+	end := start
+	for _, ev := range evs {
+		end = max(end, ev.end)
+	}
+
+	// monotonic gaps, then a 3-tap moving average
+	raw := make([]float64, k-1)
+	for i := 1; i < k; i++ {
+		raw[i-1] = float64(max(0, evs[i].start-max(evs[i-1].end, evs[i-1].start)))
+	}
+	gaps := make([]float64, k-1)
+	for i := range raw {
+		sum, cnt := raw[i], 1.0
+		if i > 0 {
+			sum, cnt = sum+raw[i-1], cnt+1
+		}
+		if i+1 < len(raw) {
+			sum, cnt = sum+raw[i+1], cnt+1
+		}
+		gaps[i] = sum / cnt
+	}
+
+	total := float64(end - start)
+	gapSum := 0.0
+	for _, g := range gaps {
+		gapSum += g
+	}
+	speak := total - gapSum
+	if floor := float64(k * minDur); speak < floor { // give pauses up to keep the floor
+		scale := 0.0
+		if gapSum > 0 {
+			scale = maxf(0, total-floor) / gapSum
+		}
+		for i := range gaps {
+			gaps[i] *= scale
+		}
+		speak = total - gapSum*scale
+	}
+
+	// durations by rune count, lifting short words to minDur
+	weights := make([]float64, k)
+	for i, ev := range evs {
+		weights[i] = float64(max(1, utf8.RuneCountInString(strings.TrimSpace(plainText(ev.text)))))
+	}
+	durs := shareWithFloor(speak, weights, float64(minDur))
+
+	t := float64(start)
+	for i, ev := range evs {
+		ev.start = int(t + 0.5)
+		t += durs[i]
+		if i < k-1 {
+			ev.end = int(t + 0.5)
+			t += gaps[i]
+		} else {
+			ev.end = end
+		}
+	}
+}
+
+// shareWithFloor splits total in proportion to weights, with no share below
+// floor (when total allows it).
+func shareWithFloor(total float64, weights []float64, floor float64) []float64 {
+	out := make([]float64, len(weights))
+	fixed := make([]bool, len(weights))
+	for {
+		rest, w := total, 0.0
+		for i := range weights {
+			if fixed[i] {
+				rest -= floor
+			} else {
+				w += weights[i]
+			}
+		}
+		changed := false
+		for i := range weights {
+			if fixed[i] {
+				out[i] = floor
+				continue
+			}
+			out[i] = rest * weights[i] / w
+			if out[i] < floor && rest > floor {
+				fixed[i], changed = true, true
+			}
+		}
+		if !changed || w == 0 {
+			return out
+		}
+	}
+}
+
+func applyWordSmoothing(path string, minDur int) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	n := smoothWordTimings(d, minDur)
+	if n == 0 {
+		return 0, nil
+	}
+	return n, writeASS(path, d)
+}
+
+// Minimum caption length (-subMinDuration): whisper gives short function
+// words 30-60 ms, which flicker. A caption shorter than the minimum is
+// extended into the silence after it when there is room, and otherwise
+// merged with the next caption. Nothing is extended past the next
+// caption's start or the end of the voice.
+
+// enforceMinDuration lengthens the Dialogue events of d shorter than minDur
+// (centiseconds), ending none after endCS, and returns how many were
+// extended and how many were merged into the next.
+func enforceMinDuration(d *assDoc, minDur, endCS int) (extended, merged int) {
+	idx := d.dialogues()
+	sort.SliceStable(idx, func(a, b int) bool { return d.events[idx[a]].start < d.events[idx[b]].start })
+	drop := map[int]bool{}
+	for k, i := range idx {
+		ev := &d.events[i]
+		if ev.end-ev.start >= minDur {
+			continue
+		}
+		var next *assEvent
+		limit := endCS
+		if k+1 < len(idx) {
+			next = &d.events[idx[k+1]]
+			limit = min(limit, next.start)
+		}
+		switch {
+		case limit-ev.start >= minDur:
+			ev.end = ev.start + minDur
+			extended++
+		case next != nil && next.get(d, "Style") == ev.get(d, "Style"):
+			a, b := strings.TrimSpace(ev.text), strings.TrimSpace(next.text)
+			next.text = a + wordSep(plainText(a), strings.TrimSpace(plainText(b))) + b
+			next.start = ev.start
+			drop[i] = true
+			merged++
+		case limit > ev.end:
+			ev.end = limit
+			extended++
+		}
+	}
+	if len(drop) > 0 {
+		kept := d.events[:0]
+		for i, ev := range d.events {
+			if !drop[i] {
+				kept = append(kept, ev)
+			}
+		}
+		d.events = kept
+	}
+	return extended, merged
+}
+
+func applyMinDuration(path string, minDur, endCS int) (extended, merged int, err error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	extended, merged = enforceMinDuration(d, minDur, endCS)
+	if extended+merged == 0 {
+		return 0, 0, nil
+	}
+	return extended, merged, writeASS(path, d)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Soft subtitles (-softSubs, -attachSubs): the captions are muxed as a
+// subtitle stream players can switch on and off. Matroska carries the ASS
+// as is; MP4 and MOV only take mov_text, which keeps the words and their
+// timing but loses fonts, colours, positions and karaoke highlighting.
+
+// subStream is a subtitle file muxed into the output as its own stream.
+type subStream struct {
+	path  string // ASS file
+	codec string // -c:s
+	lang  string // ISO 639-2 language tag
+}
+
+// subCodecs maps output extensions to the subtitle codec they carry.
+var subCodecs = map[string]string{
+	".mkv": "ass",
+	".mp4": "mov_text",
+	".m4v": "mov_text",
+	".mov": "mov_text",
+}
+
+var subLangRe = regexp.MustCompile(`^[a-z]{3}$`)
+
+// subCodecFor returns the subtitle codec for the container of out.
+func subCodecFor(out string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(out))
+	c, ok := subCodecs[ext]
+	if !ok {
+		return "", fmt.Errorf("%s output cannot carry a subtitle stream (use %s)", ext, strings.Join(sortedKeys(subCodecs), ", "))
+	}
+	return c, nil
+}
+
+// copyCodecs lists the video codecs that can be stream-copied into each
+// container without re-encoding.
+var copyCodecs = map[string][]string{
+	"ass":      {"h264", "hevc", "av1", "vp9", "vp8", "mpeg4"},
+	"mov_text": {"h264", "hevc", "av1", "mpeg4"},
+}
+
+// videoCopyable reports whether the first video stream of path can be
+// copied unchanged into an output taking subtitle codec subCodec.
+func videoCopyable(ctx context.Context, path, subCodec string) (string, bool) {
+	cmd := newCommand(ctx, "ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=codec_name",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", false
+	}
+	codec := strings.TrimSpace(string(out))
+	for _, c := range copyCodecs[subCodec] {
+		if c == codec {
+			return codec, true
+		}
+	}
+	return codec, false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Speaker preflight: a speaker WAV that moved or a speaker id a newer model
+// dropped would otherwise only fail once synthesis starts.
+
+// speakerAvailable reports whether the configured speaker can be used.
+func speakerAvailable(ctx context.Context, o *ttsOptions) error {
+	if o.speakerWav != "" {
+		for _, w := range speakerWavs(o.speakerWav) {
+			if !pathExists(w) {
+				return fmt.Errorf("speaker wav %s not found", w)
+			}
+		}
+		return nil
+	}
+	if o.speaker == "" || o.engine != "coqui" || o.server != "" {
+		return nil
+	}
+	out, err := newCommand(ctx, o.bin, "--model_name", o.model, "--list_speaker_idxs").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("list speakers of %s: %w", o.model, err)
+	}
+	s := string(out)
+	if !strings.Contains(s, "'"+o.speaker+"'") && !strings.Contains(s, `"`+o.speaker+`"`) {
+		return fmt.Errorf("speaker %q not offered by %s", o.speaker, o.model)
+	}
+	return nil
+}
+
+// resolveSpeaker validates the configured speaker and, if it is unusable,
+// switches o to the first working entry of chain. Entries are speaker ids,
+// speaker WAV paths, or "default" (the model's own voice). It returns a
+// description of the substitution, or "" when none was needed.
+func resolveSpeaker(ctx context.Context, o *ttsOptions, chain []string) (string, error) {
+	err := speakerAvailable(ctx, o)
+	if err == nil {
+		return "", nil
+	}
+	if len(chain) == 0 {
+		return "", err
+	}
+	want := o.speaker
+	if o.speakerWav != "" {
+		want = o.speakerWav
+	}
+	tried := []string{fmt.Sprintf("%s: %v", want, err)}
+	for _, entry := range chain {
+		c := *o
+		switch {
+		case entry == "":
+			continue
+		case entry == "default":
+			c.speaker, c.speakerWav = "", ""
+		case strings.HasSuffix(strings.ToLower(entry), ".wav") || strings.ContainsRune(entry, os.PathSeparator):
+			c.speaker, c.speakerWav = "", entry
+		default:
+			c.speaker, c.speakerWav = entry, ""
+		}
+		if err := speakerAvailable(ctx, &c); err != nil {
+			tried = append(tried, fmt.Sprintf("%s: %v", entry, err))
+			continue
+		}
+		*o = c
+		return fmt.Sprintf("%s -> %s (%v)", want, entry, tried[0]), nil
+	}
+	return "", fmt.Errorf("no usable speaker:\n  %s", strings.Join(tried, "\n  "))
+}
+
+// maxSpeakerWavs caps the -ttsSpeakerWav list; XTTS gains little past a
+// handful of clips.
+const maxSpeakerWavs = 16
+
+// speakerWavs splits a comma-separated list of reference clips; an empty
+// list is nil.
+func speakerWavs(s string) []string { return splitList(s) }
+
+// checkSpeakerWavs validates a reference list before synthesis: at most
+// maxSpeakerWavs entries, each a file ffprobe reads as audio.
+func checkSpeakerWavs(ctx context.Context, s string) error {
+	wavs := speakerWavs(s)
+	if len(wavs) > maxSpeakerWavs {
+		return fmt.Errorf("%d reference clips; at most %d are allowed", len(wavs), maxSpeakerWavs)
+	}
+	for _, w := range wavs {
+		if !pathExists(w) {
+			return fmt.Errorf("reference clip not found: %s", w)
+		}
+		if _, err := probeSampleRate(ctx, w); err != nil {
+			return fmt.Errorf("reference clip %s is not audio: %v", w, err)
+		}
+	}
+	return nil
+}
+
+// speakerWavExts are the reference clip types -ttsSpeakerWavDir picks from.
+var speakerWavExts = map[string]bool{".wav": true, ".mp3": true}
+
+// listSpeakerWavs returns the reference clips in dir, sorted so that a
+// -seed picks the same clip on every machine.
+func listSpeakerWavs(dir string) ([]string, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var wavs []string
+	for _, e := range ents {
+		if !e.IsDir() && speakerWavExts[strings.ToLower(filepath.Ext(e.Name()))] {
+			wavs = append(wavs, filepath.Join(dir, e.Name()))
+		}
+	}
+	if len(wavs) == 0 {
+		return nil, fmt.Errorf("no .wav or .mp3 files in %s", dir)
+	}
+	sort.Strings(wavs)
+	return wavs, nil
+}
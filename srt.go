@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SRT export for closed captions (-srtOut). The burned ASS is word-level,
+// which makes for unreadable closed captions, so the caption events are
+// merged into sentence-sized blocks: words join until one ends a
+// sentence, the speaker pauses for longer than srtGap, or the block would
+// exceed srtMaxChars. Override tags are dropped and \N becomes a line
+// break. The title card (layer 1) is not a caption and is skipped.
+
+const (
+	srtGap       = 60 // cs; a longer pause starts a new block
+	srtMaxChars  = 84 // two lines of srtLineChars
+	srtLineChars = 42
+)
+
+type srtBlock struct {
+	start, end int // cs
+	text       string
+}
+
+// srtText turns ASS event text into SRT text. A -subsSecondary
+// translation line is left out.
+func srtText(s string) string {
+	if k := strings.Index(s, secondaryTag); k >= 0 {
+		s = s[:k]
+	}
+	s = overrideRe.ReplaceAllString(s, "")
+	s = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(s)
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = strings.Join(strings.Fields(lines[i]), " ")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func srtBlocks(d *assDoc) []srtBlock {
+	var evs []assEvent
+	for _, i := range d.dialogues() {
+		if l := d.events[i].get(d, "Layer"); l == "" || l == "0" {
+			evs = append(evs, d.events[i])
+		}
+	}
+	sort.SliceStable(evs, func(a, b int) bool { return evs[a].start < evs[b].start })
+
+	var out []srtBlock
+	var cur *srtBlock
+	prev := ""
+	for _, ev := range evs {
+		t := srtText(ev.text)
+		if t == "" {
+			continue
+		}
+		if n := len(out); n > 0 && t == prev && ev.start-out[n-1].end <= srtGap {
+			// sentence-highlight repeats the sentence once per word
+			out[n-1].end = max(out[n-1].end, ev.end)
+			continue
+		}
+		prev = t
+		if cur != nil && (ev.start-cur.end > srtGap ||
+			utf8.RuneCountInString(cur.text)+1+utf8.RuneCountInString(t) > srtMaxChars) {
+			cur = nil
+		}
+		if cur == nil {
+			out = append(out, srtBlock{start: ev.start, end: ev.end, text: t})
+			cur = &out[len(out)-1]
+		} else {
+			cur.text += " " + t
+			cur.end = max(cur.end, ev.end)
+		}
+		if strings.ContainsAny(lastRune(t), ".!?…") {
+			cur = nil
+		}
+	}
+	for i := range out {
+		out[i].text = wrapSRT(out[i].text)
+	}
+	return out
+}
+
+// wrapSRT breaks a long single-line block at the space nearest its
+// middle.
+func wrapSRT(s string) string {
+	if strings.Contains(s, "\n") || utf8.RuneCountInString(s) <= srtLineChars {
+		return s
+	}
+	mid, best := len(s)/2, -1
+	for i := range s {
+		if s[i] == ' ' && (best < 0 || abs(i-mid) < abs(best-mid)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return s
+	}
+	return s[:best] + "\n" + s[best+1:]
+}
+
+// formatSRTTime formats centiseconds as HH:MM:SS,mmm.
+func formatSRTTime(cs int) string {
+	cs = max(0, cs)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", cs/360000, cs/6000%60, cs/100%60, cs%100*10)
+}
+
+// writeSRTFromASS converts the ASS file at assPath and returns the number
+// of blocks written.
+func writeSRTFromASS(assPath, srtPath string) (int, error) {
+	d, err := readASS(assPath)
+	if err != nil {
+		return 0, err
+	}
+	blocks := srtBlocks(d)
+	var b strings.Builder
+	for i, bl := range blocks {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, formatSRTTime(bl.start), formatSRTTime(bl.end), bl.text)
+	}
+	return len(blocks), os.WriteFile(srtPath, []byte(b.String()), 0o644)
+}
+
+// SRT import (-subsIn): hand-made SRT captions become Dialogue events on
+// plainASSHeader's style, so the ASS passes and the burn treat them
+// like generated ones. <i>, <b> and <u> become override tags; other HTML
+// tags are dropped.
+
+var (
+	srtTimingRe = regexp.MustCompile(`^(\d+):(\d{2}):(\d{2})[,.](\d{1,3})\s*-->\s*(\d+):(\d{2}):(\d{2})[,.](\d{1,3})`)
+	srtTagRe    = regexp.MustCompile(`(?i)<(/?)([biu])>|<[^>]*>`)
+)
+
+// parseSRT converts SRT captions to an ASS document.
+func parseSRT(b []byte) (*assDoc, error) {
+	d, err := parseASS([]byte(plainASSHeader))
+	if err != nil {
+		return nil, err
+	}
+	text := strings.ReplaceAll(strings.TrimPrefix(string(b), "\xef\xbb\xbf"), "\r\n", "\n")
+	for n, block := range strings.Split(strings.TrimSpace(text), "\n\n") {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		if len(lines) > 0 && !srtTimingRe.MatchString(lines[0]) {
+			lines = lines[1:] // cue number
+		}
+		if len(lines) == 0 || strings.TrimSpace(lines[0]) == "" {
+			continue
+		}
+		m := srtTimingRe.FindStringSubmatch(strings.TrimSpace(lines[0]))
+		if m == nil {
+			return nil, fmt.Errorf("cue %d: bad timing line %q", n+1, lines[0])
+		}
+		start, end := srtTimeCS(m[1:5]), srtTimeCS(m[5:9])
+		if end <= start {
+			return nil, fmt.Errorf("cue %d: ends at or before its start", n+1)
+		}
+		var body []string
+		for _, l := range lines[1:] {
+			if l = strings.TrimSpace(l); l != "" {
+				body = append(body, srtTagRe.ReplaceAllStringFunc(assEscapeText(l), srtTag))
+			}
+		}
+		if len(body) > 0 {
+			d.events = append(d.events, d.newEvent(start, end, "Default", strings.Join(body, `\N`)))
+		}
+	}
+	if len(d.events) == 0 {
+		return nil, fmt.Errorf("no captions")
+	}
+	return d, nil
+}
+
+// srtTimeCS converts h, m, s and ms strings to centiseconds, rounding to
+// nearest.
+func srtTimeCS(f []string) int {
+	n := make([]int, 4)
+	for i, s := range f {
+		n[i], _ = strconv.Atoi(s)
+	}
+	for l := len(f[3]); l < 3; l++ {
+		n[3] *= 10 // ",5" is 500 ms
+	}
+	return ((n[0]*60+n[1])*60+n[2])*100 + (n[3]+5)/10
+}
+
+func srtTag(tag string) string {
+	m := srtTagRe.FindStringSubmatch(tag)
+	if m[2] == "" {
+		return ""
+	}
+	on := "1"
+	if m[1] == "/" {
+		on = "0"
+	}
+	return `{\` + strings.ToLower(m[2]) + on + `}`
+}
+
+// readSubsIn loads a -subsIn file, converting SRT by extension. Empty or
+// caption-less files are errors.
+func readSubsIn(path string) (*assDoc, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	var d *assDoc
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".srt":
+		d, err = parseSRT(b)
+	case ".ass", ".ssa":
+		d, err = parseASS(b)
+		if err == nil && len(d.dialogues()) == 0 {
+			err = fmt.Errorf("no Dialogue events")
+		}
+	default:
+		return nil, fmt.Errorf("%s: want an .ass, .ssa or .srt file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return d, nil
+}
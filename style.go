@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Subtitle style overrides: the -sub* style flags rewrite fields of every
+// Style line in [V4+ Styles], whichever generator wrote the file. Only the
+// fields given are touched.
+
+// styleEdit maps [V4+ Styles] Format field names to new values.
+type styleEdit map[string]string
+
+var hexColorRe = regexp.MustCompile(`^#?([0-9a-fA-F]{6})$`)
+
+// assColor converts #RRGGBB to the &HAABBGGRR form of a Style line, with
+// an opaque alpha.
+func assColor(s string) (string, error) {
+	m := hexColorRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return "", fmt.Errorf("want #RRGGBB, got %q", s)
+	}
+	rgb := strings.ToUpper(m[1])
+	return "&H00" + rgb[4:6] + rgb[2:4] + rgb[0:2], nil
+}
+
+// assAlphaColor is assColor with an opacity in 0..1. ASS alpha counts
+// transparency, so fully opaque is 00 and fully clear FF.
+func assAlphaColor(s string, opacity float64) (string, error) {
+	c, err := assColor(s)
+	if err != nil {
+		return "", err
+	}
+	if opacity < 0 || opacity > 1 {
+		return "", fmt.Errorf("opacity must be in 0..1, got %g", opacity)
+	}
+	return fmt.Sprintf("&H%02X", int(math.Round((1-opacity)*255))) + c[4:], nil
+}
+
+// boxStyle is the style edit for an opaque box behind the text. With
+// BorderStyle 3 libass paints the box in OutlineColour and its shadow in
+// BackColour, so both get the box colour; the box's padding is Outline.
+func boxStyle(color string) styleEdit {
+	return styleEdit{"BorderStyle": "3", "OutlineColour": color, "BackColour": color}
+}
+
+// assBool is the ASS form of a boolean style field.
+func assBool(b bool) string {
+	if b {
+		return "-1"
+	}
+	return "0"
+}
+
+// applyStyle rewrites the styles of d and returns how many Style lines it
+// changed.
+func (d *assDoc) applyStyle(edit styleEdit) (int, error) {
+	return d.editStyles(func(format, fields []string) error {
+		for name, val := range edit {
+			j := indexFold(format, name)
+			if j < 0 {
+				return fmt.Errorf("styles have no %s field", name)
+			}
+			fields[j] = val
+		}
+		return nil
+	})
+}
+
+// editStyles calls edit with the Format names and the fields of each Style
+// line, writes back what edit changed and returns the number of styles.
+func (d *assDoc) editStyles(edit func(format, fields []string) error) (int, error) {
+	var format []string
+	in, n := false, 0
+	for i, l := range d.head {
+		t := strings.TrimSpace(l)
+		if strings.HasPrefix(t, "[") {
+			in = strings.EqualFold(t, "[V4+ Styles]") || strings.EqualFold(t, "[V4 Styles]")
+			continue
+		}
+		k, v, ok := strings.Cut(t, ":")
+		if !in || !ok {
+			continue
+		}
+		switch k {
+		case "Format":
+			format = splitTrim(v, ",", -1)
+		case "Style":
+			if format == nil {
+				return 0, fmt.Errorf("Style line before Format in [V4+ Styles]")
+			}
+			fields := splitTrim(v, ",", len(format))
+			if len(fields) != len(format) {
+				return 0, fmt.Errorf("malformed Style: %q", v)
+			}
+			if err := edit(format, fields); err != nil {
+				return 0, err
+			}
+			d.head[i] = "Style: " + strings.Join(fields, ",")
+			n++
+		}
+	}
+	return n, nil
+}
+
+func indexFold(list []string, s string) int {
+	for i, v := range list {
+		if strings.EqualFold(v, s) {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkMargins rejects margins that leave no room for text on the
+// script's PlayRes canvas, which the flags can only be checked against
+// once the file exists.
+func (d *assDoc) checkMargins(edit styleEdit) error {
+	w, h := d.playRes()
+	m := func(k string) int { n, _ := strconv.Atoi(edit[k]); return n }
+	if l, r := m("MarginL"), m("MarginR"); l+r >= w {
+		return fmt.Errorf("-subMarginL + -subMarginR (%d) must be below the script width %d", l+r, w)
+	}
+	if v := m("MarginV"); v >= h/2 {
+		return fmt.Errorf("-subMarginV %d must be below half the script height %d", v, h)
+	}
+	return nil
+}
+
+func applyStyleFile(path string, edit styleEdit) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	if err := d.checkMargins(edit); err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	n, err := d.applyStyle(edit)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return n, writeASS(path, d)
+}
+
+// styleFieldValue formats a number for a Style field.
+func styleFieldValue(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+
+// styleField returns a field of the named style, or "" if either is
+// missing.
+func (d *assDoc) styleField(style, field string) string {
+	var format []string
+	in := false
+	for _, l := range d.head {
+		t := strings.TrimSpace(l)
+		if strings.HasPrefix(t, "[") {
+			in = strings.EqualFold(t, "[V4+ Styles]") || strings.EqualFold(t, "[V4 Styles]")
+			continue
+		}
+		k, v, ok := strings.Cut(t, ":")
+		switch {
+		case !in || !ok:
+		case k == "Format":
+			format = splitTrim(v, ",", -1)
+		case k == "Style" && format != nil:
+			fields := splitTrim(v, ",", len(format))
+			if j := indexFold(format, field); j >= 0 && j < len(fields) && fields[0] == style {
+				return fields[j]
+			}
+		}
+	}
+	return ""
+}
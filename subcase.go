@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Caption text transforms: -subsCase recases the words of every Dialogue
+// event and -subsStripPunct drops the comma or full stop that ends a cue.
+// Override blocks ({...}) and escapes (\N) are copied untouched.
+
+// subsCases are the -subsCase modes other than none.
+var subsCases = map[string]bool{"upper": true, "lower": true, "title": true}
+
+// recase applies mode to the visible text of an event. title capitalizes
+// the first letter of each word and lowercases the rest; an apostrophe
+// inside a word ("don't") does not start a new one.
+func recase(text, mode string) string {
+	var out strings.Builder
+	inWord := false
+	rs := []rune(text)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '{':
+			j := i
+			for j < len(rs) && rs[j] != '}' {
+				j++
+			}
+			if j == len(rs) {
+				j--
+			}
+			out.WriteString(string(rs[i : j+1]))
+			i = j
+			continue
+		case r == '\\' && i+1 < len(rs):
+			out.WriteString(string(rs[i : i+2]))
+			i++
+			inWord = false
+			continue
+		}
+		letter := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+		switch mode {
+		case "upper":
+			r = unicode.ToUpper(r)
+		case "lower":
+			r = unicode.ToLower(r)
+		case "title":
+			if letter && !inWord {
+				r = unicode.ToTitle(r)
+			} else {
+				r = unicode.ToLower(r)
+			}
+		}
+		out.WriteRune(r)
+		if !(inWord && (r == '\'' || r == '’')) {
+			inWord = letter
+		}
+	}
+	return out.String()
+}
+
+// stripCuePunct removes the commas and full stops ending the visible text
+// of an event, unless nothing else is left.
+func stripCuePunct(text string) string {
+	end := len(text)
+	for {
+		t := strings.TrimRight(text[:end], " \t")
+		if strings.HasSuffix(t, "}") {
+			if k := strings.LastIndex(t, "{"); k >= 0 {
+				end = k
+				continue
+			}
+		}
+		end = len(t)
+		break
+	}
+	cut := end
+	for cut > 0 && (text[cut-1] == '.' || text[cut-1] == ',') {
+		cut--
+	}
+	if cut == end || strings.TrimSpace(plainText(text[:cut])) == "" || strings.HasSuffix(text[:cut], `\`) {
+		return text
+	}
+	return text[:cut] + text[end:]
+}
+
+// applySubsCase recases every Dialogue event of the ASS file at path and
+// returns how many changed.
+func applySubsCase(path, mode string) (int, error) {
+	return editDialogues(path, func(ev *assEvent, _ *assDoc) string { return recase(ev.text, mode) })
+}
+
+// applyStripPunct strips cue punctuation in the ASS file at path. It runs
+// last, once cue grouping and the SRT have used the punctuation, and
+// leaves the title card (layer 1) alone.
+func applyStripPunct(path string) (int, error) {
+	return editDialogues(path, func(ev *assEvent, d *assDoc) string {
+		if l := ev.get(d, "Layer"); l != "" && l != "0" {
+			return ev.text
+		}
+		return stripCuePunct(ev.text)
+	})
+}
+
+// editDialogues replaces the text of each Dialogue event with edit's and
+// returns how many changed; the file is only rewritten if any did.
+func editDialogues(path string, edit func(ev *assEvent, d *assDoc) string) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, i := range d.dialogues() {
+		ev := &d.events[i]
+		if t := edit(ev, d); t != ev.text {
+			ev.text = t
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, writeASS(path, d)
+}
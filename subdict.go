@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Subtitle dictionary: canonical spellings for names the recognizer gets
+// wrong. One entry per line, optionally followed by known misrecognitions:
+//
+//	Kaelen: Kalen, Caylen
+//	Vashti
+//
+// Words are replaced whole, case-insensitively, keeping the original case
+// style. Near misses (small edit distance) are only matched against the
+// listed variants, never against words the file does not mention.
+
+type subDict struct {
+	exact    map[string]string // lowercased spelling -> canonical
+	variants []dictVariant
+}
+
+type dictVariant struct {
+	word      string // lowercased
+	canonical string
+}
+
+func readSubDict(path string) (*subDict, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := &subDict{exact: map[string]string{}}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		canon, rest, _ := strings.Cut(line, ":")
+		canon = strings.TrimSpace(canon)
+		if canon == "" || strings.IndexFunc(canon, unicode.IsSpace) >= 0 {
+			return nil, fmt.Errorf("%s:%d: entry must be a single word", path, n)
+		}
+		d.exact[strings.ToLower(canon)] = canon
+		for _, v := range splitTrim(rest, ",", -1) {
+			if v == "" {
+				continue
+			}
+			d.exact[strings.ToLower(v)] = canon
+			d.variants = append(d.variants, dictVariant{strings.ToLower(v), canon})
+		}
+	}
+	return d, sc.Err()
+}
+
+// lookup returns the canonical spelling for word, if the dictionary covers it.
+func (d *subDict) lookup(word string) (string, bool) {
+	lw := strings.ToLower(word)
+	if c, ok := d.exact[lw]; ok {
+		return c, true
+	}
+	for _, v := range d.variants {
+		limit := min(2, (utf8.RuneCountInString(v.word)-2)/4) // 6+ runes: 1, 10+: 2
+		if limit > 0 && editDistance(lw, v.word) <= limit {
+			return v.canonical, true
+		}
+	}
+	return "", false
+}
+
+// matchCase renders canonical in the case style of orig: ALL CAPS and
+// all-lowercase are kept, anything else uses the canonical spelling.
+func matchCase(orig, canonical string) string {
+	switch {
+	case utf8.RuneCountInString(orig) > 1 && orig == strings.ToUpper(orig) && orig != strings.ToLower(orig):
+		return strings.ToUpper(canonical)
+	case orig == strings.ToLower(orig) && orig != strings.ToUpper(orig):
+		return strings.ToLower(canonical)
+	}
+	return canonical
+}
+
+// normalize rewrites dictionary words in an event's text, leaving override
+// blocks ({...}) and escapes (\N) alone. It returns the new text and the
+// replacements made as "old->new".
+func (d *subDict) normalize(text string) (string, []string) {
+	var out strings.Builder
+	var changes []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() == 0 {
+			return
+		}
+		w := word.String()
+		word.Reset()
+		if c, ok := d.lookup(w); ok {
+			if r := matchCase(w, c); r != w {
+				changes = append(changes, w+"->"+r)
+				w = r
+			}
+		}
+		out.WriteString(w)
+	}
+	rs := []rune(text)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '{':
+			flush()
+			j := i
+			for j < len(rs) && rs[j] != '}' {
+				j++
+			}
+			if j == len(rs) {
+				j--
+			}
+			out.WriteString(string(rs[i : j+1]))
+			i = j
+		case r == '\\' && i+1 < len(rs):
+			flush()
+			out.WriteString(string(rs[i : i+2]))
+			i++
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			word.WriteRune(r)
+		default:
+			flush()
+			out.WriteRune(r)
+		}
+	}
+	flush()
+	return out.String(), changes
+}
+
+// applySubDict normalizes every Dialogue event of the ASS file at path.
+func applySubDict(path string, d *subDict) ([]string, error) {
+	doc, err := readASS(path)
+	if err != nil {
+		return nil, err
+	}
+	var all []string
+	for _, i := range doc.dialogues() {
+		text, changes := d.normalize(doc.events[i].text)
+		doc.events[i].text = text
+		all = append(all, changes...)
+	}
+	if len(all) == 0 {
+		return nil, nil
+	}
+	return all, writeASS(path, doc)
+}
+
+// editDistance is the Levenshtein distance between a and b, in runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
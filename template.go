@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ASS templates (-assTemplate): a house-style script supplies everything
+// outside [Events] ([Script Info], [V4+ Styles], fonts), kept verbatim, and
+// the generated events are moved into it under one of its styles. The
+// template's own events, if any, are dropped.
+
+var eventsSectionRe = regexp.MustCompile(`(?im)^\s*\[events\]\s*$`)
+
+type assTemplate struct {
+	head, tail []string
+	style      string
+	dropped    int // events the template had
+}
+
+// readASSTemplate reads the template at path and checks that it defines
+// style, or any style when style is "" (the first is then used).
+func readASSTemplate(path, style string) (*assTemplate, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !eventsSectionRe.Match(b) {
+		b = append(b, "\n[Events]\nFormat: Layer, Start, End, Style, Text\n"...)
+	}
+	d, err := parseASS(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, section := range []string{"[Script Info]", "[V4+ Styles]"} {
+		if !hasSection(d.head, section) {
+			return nil, fmt.Errorf("%s has no %s section", path, section)
+		}
+	}
+	names := d.styleNames()
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s defines no styles", path)
+	}
+	if style == "" {
+		style = names[0]
+	} else if indexOf(names, style) < 0 {
+		return nil, fmt.Errorf("%s has no style %q (it has %s)", path, style, strings.Join(names, ", "))
+	}
+	return &assTemplate{head: d.head, tail: d.tail, style: style, dropped: len(d.events)}, nil
+}
+
+func hasSection(lines []string, name string) bool {
+	for _, l := range lines {
+		if strings.EqualFold(strings.TrimSpace(l), name) {
+			return true
+		}
+	}
+	return false
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// applyASSTemplate puts the events of the ASS file at path into t, every
+// Dialogue in t's style, and returns how many events moved.
+func applyASSTemplate(path string, t *assTemplate) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	if d.fieldIndex("Style") < 0 {
+		return 0, fmt.Errorf("%s: events have no Style field", path)
+	}
+	d.head = append([]string(nil), t.head...)
+	d.tail = append([]string(nil), t.tail...)
+	for _, i := range d.dialogues() {
+		d.events[i].set(d, "Style", t.style)
+	}
+	return len(d.events), writeASS(path, d)
+}
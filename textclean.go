@@ -0,0 +1,97 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Text cleanup (on unless -rawText): scripts pasted from chat tools carry
+// markdown, raw URLs and emoji that the TTS reads out loud ("asterisk
+// asterisk") and the subtitle font draws as boxes. Markdown is reduced to
+// its words, URLs become -urlText (or go), and emoji and zero-width
+// characters are dropped. Bracketed markers are kept as written.
+
+var (
+	mdImageRe    = regexp.MustCompile(`!\[([^\]\n]*)\]\([^)\n]*\)`)
+	mdLinkRe     = regexp.MustCompile(`\[([^\]\n]+)\]\([^)\n]*\)`)
+	mdEmphRe     = regexp.MustCompile(`(\*\*|__|~~)(\S(?:.*?\S)?)(\*\*|__|~~)`)
+	mdItalicRe   = regexp.MustCompile(`(^|[^\pL\pN*_])[*_](\S(?:[^*_\n]*\S)?)[*_]([^\pL\pN*_]|$)`)
+	mdCodeRe     = regexp.MustCompile("`+([^`\n]*)`+")
+	mdRuleRe     = regexp.MustCompile(`(?m)^[ \t]*([-*_][ \t]*){3,}$`)
+	mdFenceRe    = regexp.MustCompile("(?m)^[ \t]*(```|~~~).*$")
+	mdLinePrefix = regexp.MustCompile(`^[ \t]*(#{1,6}[ \t]+|>[ \t]*|[-*+•‣◦▪][ \t]+|\d+[.)][ \t]+)`)
+	urlRe        = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>()\[\]]*[^\s<>()\[\].,;:!?'"]`)
+	spacesRe     = regexp.MustCompile(`[ \t]{2,}`)
+
+	// strandedPunctRe finds punctuation left behind by a dropped URL
+	strandedPunctRe = regexp.MustCompile(`[ \t]+([.,;:!?])`)
+)
+
+// cleanText strips markdown, URLs and emoji from text. urlText replaces
+// each URL; "" drops it.
+func cleanText(text, urlText string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range normMarkerRe.FindAllStringIndex(text, -1) {
+		if strings.HasPrefix(text[m[1]:], "(") {
+			continue // a markdown link, not a marker
+		}
+		b.WriteString(cleanSegment(text[last:m[0]], urlText))
+		b.WriteString(text[m[0]:m[1]])
+		last = m[1]
+	}
+	b.WriteString(cleanSegment(text[last:], urlText))
+	return strings.TrimSpace(b.String())
+}
+
+func cleanSegment(s, urlText string) string {
+	s = mdFenceRe.ReplaceAllString(s, "")
+	s = mdRuleRe.ReplaceAllString(s, "")
+	s = mdImageRe.ReplaceAllString(s, "$1")
+	s = mdLinkRe.ReplaceAllString(s, "$1")
+	s = urlRe.ReplaceAllString(s, urlText)
+	if urlText == "" {
+		s = strandedPunctRe.ReplaceAllString(s, "$1")
+	}
+	s = mdCodeRe.ReplaceAllString(s, "$1")
+	s = mdEmphRe.ReplaceAllString(s, "$2")
+	// twice: neighbouring spans share the separator between them
+	s = mdItalicRe.ReplaceAllString(s, "$1$2$3")
+	s = mdItalicRe.ReplaceAllString(s, "$1$2$3")
+	s = strings.Map(func(r rune) rune {
+		if isEmoji(r) {
+			return -1
+		}
+		return r
+	}, s)
+
+	lines := strings.Split(s, "\n")
+	for i, ln := range lines {
+		if p := mdLinePrefix.FindString(ln); p != "" {
+			// headings and list items often lack a full stop; add one so
+			// chunking still sees a sentence end
+			ln = strings.TrimSpace(ln[len(p):])
+			if ln != "" && !strings.ContainsAny(ln[len(ln)-1:], ".!?:;…") {
+				ln += "."
+			}
+		}
+		lines[i] = spacesRe.ReplaceAllString(ln, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isEmoji reports pictographs, their modifiers and the invisible
+// characters that join them.
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF, // pictographs, emoticons, flags, skin tones
+		r >= 0x2600 && r <= 0x27BF,   // misc symbols, dingbats
+		r >= 0x2B00 && r <= 0x2BFF,   // arrows and stars
+		r >= 0xFE00 && r <= 0xFE0F,   // variation selectors
+		r >= 0xE0000 && r <= 0xE007F, // tag characters
+		r == 0x200B || r == 0x200C || r == 0x200D || r == 0x2060 || r == 0xFEFF || r == 0x20E3:
+		return true
+	}
+	return unicode.Is(unicode.Co, r) // private use: always tofu
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Text normalization (-normalizeText): currency, dates, ordinals, units
+// and numbers are spelled out before TTS, so the model reads them the way
+// a person would and whisper hears what the script says. Rules are kept
+// per language in normRules; bracketed markers ([pause 1.5], stage
+// directions) are left alone.
+
+// normRule rewrites every match of re with spell(submatches).
+type normRule struct {
+	name  string
+	re    *regexp.Regexp
+	spell func(m []string) string
+}
+
+// normSub records one substitution for -debug.
+type normSub struct {
+	from, to string
+}
+
+var normRules = map[string][]normRule{
+	"en": {
+		{"currency", regexp.MustCompile(`([$£€])(\d{1,3}(?:,\d{3})+|\d+)(?:\.(\d\d))?(?:\s+(thousand|million|billion|trillion)\b)?`), spellCurrencyEN},
+		{"date", regexp.MustCompile(`\b(\d{1,2})/(\d{1,2})/(\d{4})\b`), func(m []string) string { return spellDateEN(m[0], m[3], m[1], m[2]) }},
+		{"date", regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})\b`), func(m []string) string { return spellDateEN(m[0], m[1], m[2], m[3]) }},
+		{"ordinal", regexp.MustCompile(`\b(\d+)(?:st|nd|rd|th)\b`), func(m []string) string { return ordinalEN(spellIntEN(m[1])) }},
+		{"unit", regexp.MustCompile(`\b(\d+(?:\.\d+)?) ?(km/h|kph|mph|km|kg|cm|mm|lbs?|ft|°C|°F|%)([^\pL\pN]|$)`), spellUnitEN},
+		{"year", regexp.MustCompile(`\b(1[1-9]|20)(\d\d)\b`), spellYearEN},
+		{"number", regexp.MustCompile(`\b(\d{1,3}(?:,\d{3})+|\d+)(?:\.(\d+))?\b`), func(m []string) string { return spellNumberEN(m[1], m[2]) }},
+	},
+}
+
+// normMarkerRe matches the bracketed markers the later passes parse.
+var normMarkerRe = regexp.MustCompile(`\[[^\]\n]*\]`)
+
+// normalizeText spells out text for lang, after replacing the abbrevs
+// entries. It returns the substitutions made.
+func normalizeText(text, lang string, abbrevs []normRule) (string, []normSub, error) {
+	rules, ok := normRules[lang]
+	if !ok {
+		return "", nil, fmt.Errorf("no text normalization rules for language %q (have: %s)", lang, strings.Join(sortedRuleLangs(), ", "))
+	}
+	rules = append(append([]normRule(nil), abbrevs...), rules...)
+	var subs []normSub
+	var b strings.Builder
+	last := 0
+	normalize := func(s string) string {
+		for _, r := range rules {
+			s = r.re.ReplaceAllStringFunc(s, func(match string) string {
+				out := r.spell(r.re.FindStringSubmatch(match))
+				if out != match {
+					subs = append(subs, normSub{match, out})
+				}
+				return out
+			})
+		}
+		return s
+	}
+	for _, m := range normMarkerRe.FindAllStringIndex(text, -1) {
+		b.WriteString(normalize(text[last:m[0]]))
+		b.WriteString(text[m[0]:m[1]])
+		last = m[1]
+	}
+	b.WriteString(normalize(text[last:]))
+	return b.String(), subs, nil
+}
+
+func sortedRuleLangs() []string {
+	langs := make(map[string]string, len(normRules))
+	for l := range normRules {
+		langs[l] = ""
+	}
+	return sortedKeys(langs)
+}
+
+// readAbbrevFile loads -abbrevFile: one "Dr.=Doctor" entry per line, #
+// comments allowed. Entries match whole words, case-sensitively.
+func readAbbrevFile(path string) ([]normRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []normRule
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		from, to, ok := strings.Cut(line, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("%s:%d: want ABBREV=expansion, got %q", path, n, line)
+		}
+		// anchor the ends that are letters or digits, so NASA misses NASAL
+		pat := regexp.QuoteMeta(from)
+		r := []rune(from)
+		if unicode.IsLetter(r[0]) || unicode.IsDigit(r[0]) {
+			pat = `\b` + pat
+		}
+		if last := r[len(r)-1]; unicode.IsLetter(last) || unicode.IsDigit(last) {
+			pat += `\b`
+		}
+		rules = append(rules, normRule{"abbrev", regexp.MustCompile(pat), func([]string) string { return to }})
+	}
+	return rules, sc.Err()
+}
+
+var (
+	onesEN = []string{"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+		"ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen", "seventeen", "eighteen", "nineteen"}
+	tensEN   = []string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+	scalesEN = []string{"", "thousand", "million", "billion", "trillion", "quadrillion"}
+	monthsEN = []string{"January", "February", "March", "April", "May", "June",
+		"July", "August", "September", "October", "November", "December"}
+)
+
+// spellIntEN spells a non-negative integer given as digits, with or
+// without thousands commas. Numbers too long for the scale table are
+// read digit by digit.
+func spellIntEN(digits string) string {
+	digits = strings.ReplaceAll(digits, ",", "")
+	n, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil || len(strings.TrimLeft(digits, "0")) > 3*len(scalesEN) {
+		return spellDigitsEN(digits)
+	}
+	if n == 0 {
+		return onesEN[0]
+	}
+	var groups []string
+	for i := 0; n > 0; i++ {
+		if g := n % 1000; g > 0 {
+			s := spellUnder1000EN(int(g))
+			if scalesEN[i] != "" {
+				s += " " + scalesEN[i]
+			}
+			groups = append([]string{s}, groups...)
+		}
+		n /= 1000
+	}
+	return strings.Join(groups, " ")
+}
+
+func spellUnder1000EN(n int) string {
+	var parts []string
+	if n >= 100 {
+		parts = append(parts, onesEN[n/100]+" hundred")
+		n %= 100
+	}
+	switch {
+	case n == 0:
+	case n < 20:
+		parts = append(parts, onesEN[n])
+	case n%10 == 0:
+		parts = append(parts, tensEN[n/10])
+	default:
+		parts = append(parts, tensEN[n/10]+"-"+onesEN[n%10])
+	}
+	return strings.Join(parts, " ")
+}
+
+func spellDigitsEN(digits string) string {
+	var words []string
+	for _, r := range digits {
+		if r >= '0' && r <= '9' {
+			words = append(words, onesEN[r-'0'])
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// spellNumberEN reads an integer part and optional decimal digits.
+func spellNumberEN(whole, frac string) string {
+	s := spellIntEN(whole)
+	if frac != "" {
+		s += " point " + spellDigitsEN(frac)
+	}
+	return s
+}
+
+// ordinalEN turns spelled cardinal words into the ordinal form.
+func ordinalEN(s string) string {
+	i := strings.LastIndexAny(s, " -") + 1
+	last := s[i:]
+	irregular := map[string]string{"one": "first", "two": "second", "three": "third", "five": "fifth",
+		"eight": "eighth", "nine": "ninth", "twelve": "twelfth"}
+	switch {
+	case irregular[last] != "":
+		last = irregular[last]
+	case strings.HasSuffix(last, "y"):
+		last = strings.TrimSuffix(last, "y") + "ieth"
+	default:
+		last += "th"
+	}
+	return s[:i] + last
+}
+
+func spellYearEN(m []string) string {
+	hi, lo := m[1], m[2]
+	switch {
+	case lo == "00" && hi == "20":
+		return "two thousand"
+	case lo == "00":
+		return spellIntEN(hi) + " hundred"
+	case hi == "20" && lo[0] == '0':
+		return "two thousand " + spellIntEN(lo)
+	case lo[0] == '0':
+		return spellIntEN(hi) + " oh " + spellIntEN(lo)
+	}
+	return spellIntEN(hi) + " " + spellIntEN(lo)
+}
+
+// spellDateEN reads a date in the US month/day order; an impossible date
+// is left as written.
+func spellDateEN(written, year, month, day string) string {
+	mo, _ := strconv.Atoi(month)
+	d, _ := strconv.Atoi(day)
+	if mo < 1 || mo > 12 || d < 1 || d > 31 {
+		return written
+	}
+	return fmt.Sprintf("%s %s, %s", monthsEN[mo-1], ordinalEN(spellIntEN(day)), spellYearEN([]string{"", year[:2], year[2:]}))
+}
+
+var currencyEN = map[string][2]string{"$": {"dollar", "cent"}, "£": {"pound", "penny"}, "€": {"euro", "cent"}}
+
+func spellCurrencyEN(m []string) string {
+	unit := currencyEN[m[1]]
+	amount := spellIntEN(m[2])
+	if m[4] != "" {
+		// "$3 million": the scale word comes before the unit
+		return amount + " " + m[4] + " " + unit[0] + "s"
+	}
+	s := amount + " " + plural(unit[0], amount)
+	if c := strings.TrimLeft(m[3], "0"); c != "" {
+		cents := spellIntEN(c)
+		s += " and " + cents + " " + plural(unit[1], cents)
+	}
+	return s
+}
+
+var unitsEN = map[string]string{
+	"km": "kilometer", "kg": "kilogram", "cm": "centimeter", "mm": "millimeter",
+	"lb": "pound", "lbs": "pound", "ft": "foot", "mph": "mile per hour",
+	"km/h": "kilometer per hour", "kph": "kilometer per hour",
+	"°C": "degree Celsius", "°F": "degree Fahrenheit", "%": "percent",
+}
+
+func spellUnitEN(m []string) string {
+	whole, frac, _ := strings.Cut(m[1], ".")
+	amount := spellNumberEN(whole, frac)
+	unit := unitsEN[m[2]]
+	head, tail, _ := strings.Cut(unit, " ")
+	unit = strings.TrimSpace(plural(head, amount) + " " + tail)
+	return amount + " " + unit + m[3] // m[3] is the character after the unit
+}
+
+// plural returns the English plural of a unit word unless amount is one.
+func plural(word, amount string) string {
+	if amount == "one" {
+		return word
+	}
+	switch word {
+	case "foot":
+		return "feet"
+	case "penny":
+		return "pence"
+	case "percent":
+		return word
+	}
+	return word + "s"
+}
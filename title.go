@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Title card layout, as fractions of the ASS canvas.
+const (
+	titleMarginX    = 0.08 // left and right safe margin
+	titleTop        = 0.12 // top safe margin
+	titleRegionH    = 0.30 // height available for the wrapped title
+	titleLineHeight = 1.2  // line advance relative to font size
+)
+
+// fitTitle finds the largest font size in [minSize, maxSize] whose wrapped
+// layout fits the title region of a w x h canvas. When even minSize does not
+// fit, minSize is returned anyway so the title is still shown.
+func fitTitle(text string, w, h, minSize, maxSize int) (int, []string) {
+	maxW := float64(w) * (1 - 2*titleMarginX)
+	maxH := float64(h) * titleRegionH
+	layout := func(size int) ([]string, bool) {
+		lines := wrapText(text, maxW/float64(size))
+		if float64(len(lines))*titleLineHeight*float64(size) > maxH {
+			return lines, false
+		}
+		for _, l := range lines {
+			if textEm(l)*float64(size) > maxW {
+				return lines, false
+			}
+		}
+		return lines, true
+	}
+
+	best := minSize
+	lo, hi := minSize, maxSize
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		if _, ok := layout(mid); ok {
+			best = mid
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+	lines, _ := layout(best)
+	return best, lines
+}
+
+// addTitleCard burns text into the first dur seconds as an extra event
+// centred at the top of the canvas.
+func addTitleCard(d *assDoc, text string, dur float64, minSize, maxSize int) int {
+	w, h := d.playRes()
+	size, lines := fitTitle(assEscapeText(text), w, h, minSize, maxSize)
+	x := w / 2
+	y := int(math.Round(float64(h) * titleTop))
+	tags := fmt.Sprintf(`{\an8\q2\pos(%d,%d)\fs%d\fad(200,300)}`, x, y, size)
+	ev := d.newEvent(0, secToCS(dur), d.defaultStyle(), tags+strings.Join(lines, `\N`))
+	ev.set(d, "Layer", "1")
+	d.events = append(d.events, ev)
+	return size
+}
+
+func applyTitleCard(path, text string, dur float64, minSize, maxSize int) (int, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, err
+	}
+	size := addTitleCard(d, text, dur, minSize, maxSize)
+	return size, writeASS(path, d)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Caption translation (-subsTranslate): the captions are grouped into
+// sentences, since word-for-word timing means nothing across languages,
+// and each sentence keeps its timing but takes its translation's text.
+// The backend is either a command (-translateCmd) reading a JSON array of
+// texts on stdin and writing an array of the same length to stdout, or a
+// LibreTranslate-compatible endpoint (-translateURL) with its key from
+// $TRANSLATE_API_KEY. A batch that fails is retried a sentence at a time,
+// and a sentence that still fails keeps its original text.
+
+const translateKeyEnv = "TRANSLATE_API_KEY"
+
+type translator struct {
+	from, to string   // language codes; from "" -> auto
+	cmd      []string // command hook, or
+	url      string   // HTTP endpoint
+}
+
+func (t *translator) backend() string {
+	if t.url != "" {
+		return t.url
+	}
+	return t.cmd[0]
+}
+
+func (t *translator) source() string {
+	if t.from == "" {
+		return "auto"
+	}
+	return t.from
+}
+
+// batch translates texts in one request.
+func (t *translator) batch(ctx context.Context, texts []string, to time.Duration) ([]string, error) {
+	ctx, cancel := stageContext(ctx, to)
+	defer cancel()
+	var out []string
+	if t.url != "" {
+		body, err := json.Marshal(map[string]any{
+			"q": texts, "source": t.source(), "target": t.to, "format": "text",
+			"api_key": os.Getenv(translateKeyEnv),
+		})
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if k := os.Getenv(translateKeyEnv); k != "" {
+			req.Header.Set("Authorization", "Bearer "+k)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, stageError(ctx, "translate", to, err, "")
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("translate: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(b[:min(len(b), 4096)])))
+		}
+		var r struct {
+			TranslatedText []string `json:"translatedText"`
+		}
+		if err := json.Unmarshal(b, &r); err != nil {
+			return nil, fmt.Errorf("translate: %v", err)
+		}
+		out = r.TranslatedText
+	} else {
+		in, err := json.Marshal(texts)
+		if err != nil {
+			return nil, err
+		}
+		cmd := newCommand(ctx, t.cmd[0], t.cmd[1:]...)
+		cmd.Env = append(os.Environ(), "SUBS_TRANSLATE_FROM="+t.from, "SUBS_TRANSLATE_TO="+t.to)
+		cmd.Stdin = bytes.NewReader(in)
+		stderr := &tailBuffer{max: 4 << 10}
+		cmd.Stderr = stderr
+		b, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" && ctx.Err() == nil {
+				err = fmt.Errorf("%w: %s", err, msg)
+			}
+			return nil, stageError(ctx, "translate", to, fmt.Errorf("translate: %s: %w", t.cmd[0], err), "")
+		}
+		if err := json.Unmarshal(b, &out); err != nil {
+			return nil, fmt.Errorf("translate: %s: want a JSON array of strings on stdout: %v", t.cmd[0], err)
+		}
+	}
+	if len(out) != len(texts) {
+		return nil, fmt.Errorf("translate: %d text(s) sent, %d returned", len(texts), len(out))
+	}
+	return out, nil
+}
+
+// translate translates texts, falling back to one request per text when
+// the batch fails. A text whose translation fails or comes back empty is
+// kept as it is; the warnings say which. The error is set only when
+// nothing could be translated.
+func (t *translator) translate(ctx context.Context, texts []string, to time.Duration) ([]string, []string, error) {
+	out, err := t.batch(ctx, texts, to)
+	if err == nil {
+		var warn []string
+		for i := range out {
+			if strings.TrimSpace(out[i]) == "" {
+				warn = append(warn, fmt.Sprintf("%q: empty translation, kept", texts[i]))
+				out[i] = texts[i]
+			}
+		}
+		return out, warn, nil
+	}
+	if ctx.Err() != nil {
+		return nil, nil, err
+	}
+	batchErr := err
+	out = make([]string, len(texts))
+	var warn []string
+	ok := 0
+	for i, s := range texts {
+		r, err := t.batch(ctx, []string{s}, to)
+		if err != nil || strings.TrimSpace(r[0]) == "" {
+			if err == nil {
+				err = fmt.Errorf("empty translation")
+			}
+			warn = append(warn, fmt.Sprintf("%q: %v, kept", s, err))
+			out[i] = s
+			continue
+		}
+		out[i] = r[0]
+		ok++
+	}
+	if ok == 0 && len(texts) > 0 {
+		return nil, nil, batchErr
+	}
+	return out, warn, nil
+}
+
+// applyTranslation turns the captions of the ASS file at path into
+// sentence cues of at most maxChars (pauses longer than maxGap
+// centiseconds split them) and translates them. It returns how many cues
+// were translated and the warnings for those kept.
+func applyTranslation(ctx context.Context, path string, t *translator, maxChars, maxGap int, to time.Duration) (int, []string, error) {
+	d, err := readASS(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	mergeCues(d, groupSentences(d, maxChars, maxGap), joinWords)
+	idx := d.dialogues()
+	texts := make([]string, len(idx))
+	for k, i := range idx {
+		texts[k] = strings.TrimSpace(plainText(d.events[i].text))
+	}
+	fmt.Printf("translating: %d caption(s) %s -> %s via %s\n", len(texts), t.source(), t.to, t.backend())
+	out, warn, err := t.translate(ctx, texts, to)
+	if err != nil {
+		return 0, nil, err
+	}
+	for k, i := range idx {
+		ev := &d.events[i]
+		ev.text = leadingTagsRe.FindString(strings.TrimSpace(ev.text)) + assEscapeText(strings.TrimSpace(out[k]))
+	}
+	return len(idx) - len(warn), warn, writeASS(path, d)
+}
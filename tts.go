@@ -0,0 +1,582 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ttsOptions carries the TTS flags to whichever engine runs.
+type ttsOptions struct {
+	engine      string
+	bin         string // engine CLI (local engines)
+	model       string
+	speaker     string
+	speakerWav  string
+	lang        string
+	cuda        bool
+	voice       string        // voice name/id for edge and the API engines
+	format      string        // response format for openai
+	retries     int           // extra attempts after a transient failure
+	fallback    *ttsOptions   // tried once when this voice fails (-ttsFallback)
+	cpuFallback bool          // coqui: rerun on the CPU after a CUDA OOM
+	server      string        // coqui: tts-server base URL used instead of the CLI
+	stall       time.Duration // CLI engines: kill after this long without output (0 -> never)
+	work        string        // scratch directory for files handed to the engine
+}
+
+// ttsRunner synthesizes text into outPath.
+type ttsRunner func(ctx context.Context, o *ttsOptions, text, outPath string, to time.Duration) error
+
+var ttsEngines = map[string]ttsRunner{
+	"coqui":      runCoquiTTS,
+	"edge":       runEdgeTTS,
+	"elevenlabs": runElevenLabsTTS,
+	"openai":     runOpenAITTS,
+	"piper":      runPiperTTS,
+}
+
+// ttsRemote marks engines that synthesize over the network.
+var ttsRemote = map[string]bool{"edge": true, "elevenlabs": true, "openai": true}
+
+// argMaxChars keeps text passed as a command-line argument under Linux's
+// 128 KiB per-argument limit even at four bytes per rune.
+const argMaxChars = 32000
+
+// longTextChars is the length above which text leaves argv where the
+// engine can read it from a file, and is logged as a character count.
+const longTextChars = 2000
+
+// ttsCharLimits caps the chunk size for engines with a per-request limit
+// or that can only take the text as an argument.
+var ttsCharLimits = map[string]int{
+	"coqui":      argMaxChars,
+	"elevenlabs": elevenMaxChars,
+	"openai":     openaiMaxChars,
+}
+
+func ttsEngineNames() []string {
+	names := make([]string, 0, len(ttsEngines))
+	for n := range ttsEngines {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ttsBackoff is the wait before the first retry; it doubles per attempt.
+const ttsBackoff = 2 * time.Second
+
+// runTTS synthesizes with the selected engine, retrying transient failures
+// up to o.retries times, and checks the result is a readable, non-empty
+// audio file before anything else consumes it. If that fails for a reason
+// other than time or the text itself, o.fallback gets one go within
+// whatever is left of to, so a chunk never takes longer than to overall.
+func runTTS(ctx context.Context, o *ttsOptions, text, outPath string, to time.Duration) error {
+	start := time.Now()
+	err := runTTSEngine(ctx, o, text, outPath, to)
+	if err == nil || o.fallback == nil || ctx.Err() != nil ||
+		errors.Is(err, context.DeadlineExceeded) || ttsBadInputRe.MatchString(err.Error()) {
+		return err
+	}
+	left := to
+	if to > 0 {
+		if left = to - time.Since(start); left <= 0 {
+			return fmt.Errorf("%w\nno time left for the fallback voice", err)
+		}
+	}
+	fb := o.fallback
+	fmt.Fprintf(os.Stderr, "WARNING: %s failed; using fallback voice %s %s\n", o.engine, fb.engine, fb.model)
+	_ = os.Remove(outPath)
+	if ferr := runTTSEngine(ctx, fb, text, outPath, left); ferr != nil {
+		return fmt.Errorf("%w\nfallback %s also failed: %v", err, fb.engine, ferr)
+	}
+	ttsFallbackUses.Add(1)
+	return nil
+}
+
+// ttsFallbackUses counts chunks rendered by a -ttsFallback voice, so the
+// run can say its narration is not the intended one.
+var ttsFallbackUses atomic.Int32
+
+// ttsBadInputRe recognizes failures caused by the text itself, which a
+// different engine would not fix.
+var ttsBadInputRe = regexp.MustCompile(`(?i)(empty|invalid) (text|input)|text is empty|no (valid )?characters`)
+
+// parseTTSFallback reads -ttsFallback as engine:model (piper:/voices/amy.onnx,
+// coqui:tts_models/en/ljspeech/vits) and returns primary's options with
+// that engine and model and the engine's default binary.
+func parseTTSFallback(spec string, primary *ttsOptions) (*ttsOptions, error) {
+	engine, model, ok := strings.Cut(spec, ":")
+	if !ok || model == "" {
+		return nil, fmt.Errorf("want engine:model, got %q", spec)
+	}
+	if _, known := ttsEngines[engine]; !known {
+		return nil, fmt.Errorf("unknown engine %q (known: %s)", engine, strings.Join(ttsEngineNames(), ", "))
+	}
+	o := *primary
+	o.engine, o.model, o.fallback = engine, model, nil
+	switch engine {
+	case "piper":
+		o.bin = "piper"
+	case "edge":
+		o.bin = "edge-tts"
+	case "coqui":
+		if primary.engine != "coqui" {
+			bin, _, err := findCoquiTTS()
+			if err != nil {
+				return nil, err
+			}
+			o.bin = bin
+		}
+	}
+	return &o, checkTTS(&o)
+}
+
+// runTTSEngine runs one engine with its retries and validates the output.
+func runTTSEngine(ctx context.Context, o *ttsOptions, text, outPath string, to time.Duration) error {
+	run, ok := ttsEngines[o.engine]
+	if !ok {
+		return fmt.Errorf("unknown tts engine %q", o.engine)
+	}
+	wait := ttsBackoff
+	for attempt := 1; ; attempt++ {
+		err := run(ctx, o, text, outPath, to)
+		if err == nil {
+			break
+		}
+		if attempt > o.retries || !ttsTransient(ctx, err, outPath) {
+			if attempt > 1 {
+				return fmt.Errorf("after %d attempts: %w", attempt, err)
+			}
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "tts: attempt %d/%d failed (%v); retrying in %v\n", attempt, o.retries+1, err, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return stageError(ctx, "tts", to, err, "")
+		}
+		wait *= 2
+	}
+	d, err := probeDuration(ctx, outPath)
+	if err != nil {
+		return fmt.Errorf("%s produced an unreadable %s: %w", o.engine, outPath, err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("%s produced an empty %s", o.engine, outPath)
+	}
+	return nil
+}
+
+// ttsTransient reports whether a failed attempt is worth repeating: the
+// tool exited non-zero without leaving output or was killed as stalled,
+// and neither a timeout nor cancellation ended it (ctx is the overall run,
+// which has budget left).
+func ttsTransient(ctx context.Context, err error, outPath string) bool {
+	var exit *exec.ExitError
+	return ctx.Err() == nil && (errors.As(err, &exit) && !pathExists(outPath) || errors.Is(err, errStalled))
+}
+
+// checkTTS verifies the engine's binary, model or credentials before any
+// work is done.
+func checkTTS(o *ttsOptions) error {
+	switch o.engine {
+	case "elevenlabs":
+		if o.voice == "" {
+			return fmt.Errorf("-ttsEngine=elevenlabs needs -elevenVoiceID")
+		}
+		if os.Getenv(elevenKeyEnv) == "" {
+			return fmt.Errorf("-ttsEngine=elevenlabs needs the API key in $%s", elevenKeyEnv)
+		}
+		return nil
+	case "openai":
+		if !openaiFormats[o.format] {
+			return fmt.Errorf("-ttsFormat must be mp3|opus|aac|flac|wav, got %q", o.format)
+		}
+		if os.Getenv(openaiKeyEnv) == "" {
+			return fmt.Errorf("-ttsEngine=openai needs the API key in $%s", openaiKeyEnv)
+		}
+		return nil
+	case "edge":
+		if err := ensureCallable(o.bin, "--version"); err != nil {
+			return fmt.Errorf("edge-tts not callable: %v", err)
+		}
+		return nil
+	case "coqui":
+		if o.server != "" {
+			if u, err := url.Parse(o.server); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("-ttsServer must be an http(s) URL, got %q", o.server)
+			}
+			return nil
+		}
+	case "piper":
+		if !strings.HasSuffix(o.model, ".onnx") {
+			return fmt.Errorf("-ttsModel must be a piper .onnx voice, got %q", o.model)
+		}
+		if !pathExists(o.model) {
+			return fmt.Errorf("piper model not found: %s", o.model)
+		}
+	}
+	if _, err := exec.LookPath(o.bin); err != nil {
+		return fmt.Errorf("tts not found at %s: %v", o.bin, err)
+	}
+	return nil
+}
+
+// cudaOOMRe matches the stderr of a Coqui run that ran out of GPU memory.
+var cudaOOMRe = regexp.MustCompile(`(?i)cuda out of memory|OutOfMemoryError|CUBLAS_STATUS_ALLOC_FAILED|cublas.*(alloc|memory)`)
+
+// runCoquiTTS runs the Coqui CLI, or posts to -ttsServer when set. If it
+// runs out of GPU memory and o.cpuFallback is set, it is run once more on
+// the CPU.
+func runCoquiTTS(ctx context.Context, o *ttsOptions, text, outPath string, to time.Duration) error {
+	if o.server != "" {
+		return runCoquiServer(ctx, o, text, outPath, to)
+	}
+	stderr, err := runCoquiOnce(ctx, o, o.cuda, text, outPath, to)
+	if err == nil || !o.cuda || !o.cpuFallback || ctx.Err() != nil || !cudaOOMRe.MatchString(stderr) {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "NOTE: tts ran out of GPU memory; retrying on the CPU (-ttsCPUFallback=false to fail instead)")
+	_ = os.Remove(outPath)
+	_, err = runCoquiOnce(ctx, o, false, text, outPath, to)
+	return err
+}
+
+// runCoquiOnce makes one Coqui call and also returns the tail of its
+// stderr.
+func runCoquiOnce(ctx context.Context, o *ttsOptions, cuda bool, text, outPath string, to time.Duration) (string, error) {
+	args := []string{
+		"--text", text,
+		"--model_name", o.model,
+		"--out_path", outPath,
+	}
+	if o.speaker != "" {
+		args = append(args, "--speaker_idx", o.speaker)
+	}
+	if wavs := speakerWavs(o.speakerWav); len(wavs) > 0 {
+		// one flag, several values: the CLI's --speaker_wav is nargs="+"
+		args = append(append(args, "--speaker_wav"), wavs...)
+	}
+	if o.lang != "" {
+		args = append(args, "--language_idx", o.lang)
+	}
+	args = append(args, "--use_cuda", strconv.FormatBool(cuda))
+
+	// the Coqui CLI has no file or stdin input; chunking keeps the
+	// argument under argMaxChars
+	fmt.Printf("running: %s %s\n", o.bin, strings.Join(quote(logArgs(args)), " "))
+	ctx, cancel := stageContext(ctx, to)
+	defer cancel()
+
+	sctx, stall := watchStall(ctx, o.stall, outPath)
+	cmd := newCommand(sctx, o.bin, args...)
+	var dl atomic.Bool
+	stderr := &tailBuffer{max: 16 << 10}
+	cmd.Stdout = stall.writer(&downloadWatch{w: os.Stdout, seen: &dl})
+	cmd.Stderr = stall.writer(&downloadWatch{w: io.MultiWriter(os.Stderr, stderr), seen: &dl})
+
+	if err := cmd.Run(); err != nil {
+		if serr := stall.err("tts"); serr != nil {
+			return stderr.String(), serr
+		}
+		if ctx.Err() != nil {
+			return stderr.String(), stageError(ctx, "tts", to, err, downloadHint(&dl))
+		}
+		return stderr.String(), ttsToolError("tts", err, stderr.String())
+	}
+	if _, err := os.Stat(outPath); err != nil {
+		return stderr.String(), fmt.Errorf("tts did not produce %s", outPath)
+	}
+	return stderr.String(), nil
+}
+
+// logArgs returns args for a "running:" line, with a long --text value
+// replaced by its length.
+func logArgs(args []string) []string {
+	out := append([]string(nil), args...)
+	for i := 1; i < len(out); i++ {
+		if out[i-1] == "--text" && len(out[i]) > longTextChars {
+			out[i] = fmt.Sprintf("<%d chars>", len([]rune(out[i])))
+		}
+	}
+	return out
+}
+
+// runPiperTTS feeds text to piper on stdin. model is the .onnx voice; a
+// numeric speaker selects a voice in multi-speaker models. Piper runs on
+// the CPU, so cuda and the XTTS options do not apply.
+func runPiperTTS(ctx context.Context, o *ttsOptions, text, outPath string, to time.Duration) error {
+	args := []string{"--model", o.model, "--output_file", outPath}
+	if _, err := strconv.Atoi(o.speaker); err == nil {
+		args = append(args, "--speaker", o.speaker)
+	}
+	fmt.Printf("running: %s %s\n", o.bin, strings.Join(quote(args), " "))
+	ctx, cancel := stageContext(ctx, to)
+	defer cancel()
+
+	sctx, stall := watchStall(ctx, o.stall, outPath)
+	cmd := newCommand(sctx, o.bin, args...)
+	cmd.Stdin = strings.NewReader(text)
+	stderr := &tailBuffer{max: 16 << 10}
+	cmd.Stdout = stall.writer(os.Stdout)
+	cmd.Stderr = stall.writer(io.MultiWriter(os.Stderr, stderr))
+	if err := cmd.Run(); err != nil {
+		if serr := stall.err("piper"); serr != nil {
+			return serr
+		}
+		if ctx.Err() != nil {
+			return stageError(ctx, "piper", to, err, "")
+		}
+		return ttsToolError("piper", err, stderr.String())
+	}
+	if _, err := os.Stat(outPath); err != nil {
+		return fmt.Errorf("piper did not produce %s\n%s", outPath, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// findCoquiTTS looks for Coqui's `tts` CLI when -ttsBin is not given:
+// PATH first, then the usual virtualenv spots. Each candidate must answer
+// --help. It returns the binary and every place it looked.
+func findCoquiTTS() (string, []string, error) {
+	var tried []string
+	try := func(p string) bool {
+		tried = append(tried, p)
+		return ensureCallable(p, "--help") == nil
+	}
+	if p, err := exec.LookPath("tts"); err == nil {
+		if try(p) {
+			return p, tried, nil
+		}
+	} else {
+		tried = append(tried, "tts in $PATH")
+	}
+	cands := []string{filepath.Join(".venv", "bin", "tts")}
+	if home, err := os.UserHomeDir(); err == nil {
+		m, _ := filepath.Glob(filepath.Join(home, "TTS", ".venv*", "bin", "tts"))
+		sort.Sort(sort.Reverse(sort.StringSlice(m))) // newest python first
+		cands = append(cands, m...)
+		if len(m) == 0 {
+			tried = append(tried, filepath.Join(home, "TTS", ".venv*", "bin", "tts"))
+		}
+	}
+	for _, p := range cands {
+		if !pathExists(p) {
+			tried = append(tried, p)
+			continue
+		}
+		if try(p) {
+			return p, tried, nil
+		}
+	}
+	return "", tried, fmt.Errorf("coqui tts not found; looked at:\n  %s\ninstall it (pip install TTS) or pass -ttsBin=/path/to/venv/bin/tts",
+		strings.Join(tried, "\n  "))
+}
+
+// preflightText is what the TTS preflight synthesizes.
+const preflightText = "Hello there."
+
+// preflightChars is the story length above which -ttsPreflight checks the
+// voices before committing to the full synthesis.
+const preflightChars = 400
+
+// preflightTTS synthesizes a tiny sample with o, exactly as the story
+// would be, so a bad model, speaker or reference WAV fails in seconds with
+// the engine's own error. runTTS verifies the sample; it is then removed.
+// With a fallback configured, a failing primary is reported and the
+// fallback is checked instead.
+func preflightTTS(ctx context.Context, o *ttsOptions, work string, to time.Duration) error {
+	wav := filepath.Join(work, "tts-preflight.wav")
+	defer os.Remove(wav)
+	err := runTTSEngine(ctx, o, preflightText, wav, to)
+	if err == nil || o.fallback == nil || ctx.Err() != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: tts check: %s failed, the fallback will be used: %v\n", o.engine, err)
+	_ = os.Remove(wav)
+	if ferr := runTTSEngine(ctx, o.fallback, preflightText, wav, to); ferr != nil {
+		return fmt.Errorf("%w\nfallback %s also failed: %v", err, o.fallback.engine, ferr)
+	}
+	return nil
+}
+
+// atempoChain builds an atempo filter for speed, chaining stages because
+// each one only accepts 0.5..2.0.
+func atempoChain(speed float64) string {
+	var parts []string
+	for speed > 2.0 {
+		parts = append(parts, "atempo=2.0")
+		speed /= 2.0
+	}
+	for speed < 0.5 {
+		parts = append(parts, "atempo=0.5")
+		speed /= 0.5
+	}
+	parts = append(parts, fmt.Sprintf("atempo=%.6g", speed))
+	return strings.Join(parts, ",")
+}
+
+// changeSpeed retimes the WAV at path in place without changing pitch.
+func changeSpeed(ctx context.Context, path string, speed float64, work string, to time.Duration) error {
+	tmp := filepath.Join(work, "voice-tempo.wav")
+	args := []string{"-y", "-v", "error", "-i", path, "-filter:a", atempoChain(speed), "-c:a", "pcm_s16le", tmp}
+	if err := runFFmpegErr(ctx, args, to); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return moveFile(tmp, path)
+}
+
+// minVoiceDur is the shortest voice trimSilence accepts as a result.
+const minVoiceDur = 0.1
+
+// trimSilence removes leading and trailing audio quieter than db (dBFS)
+// from the WAV at path in place and returns how much was cut from the
+// start. The ends are trimmed in separate passes so the lead is known.
+// A voice that is quiet throughout is an error rather than an empty file.
+func trimSilence(ctx context.Context, path string, db float64, work string, to time.Duration) (float64, error) {
+	before, err := probeDuration(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	rm := fmt.Sprintf("silenceremove=start_periods=1:start_threshold=%gdB:start_silence=0.05", db)
+	lead := filepath.Join(work, "voice-trim-lead.wav")
+	args := []string{"-y", "-v", "error", "-i", path, "-filter:a", rm, "-c:a", "pcm_s16le", lead}
+	if err := runFFmpegErr(ctx, args, to); err != nil {
+		return 0, err
+	}
+	mid, err := probeDuration(ctx, lead)
+	if err != nil || mid < minVoiceDur {
+		_ = os.Remove(lead)
+		return 0, fmt.Errorf("voice is quieter than %gdB throughout (%.2fs); check the TTS output or lower -voiceTrimDb", db, before)
+	}
+	both := filepath.Join(work, "voice-trim.wav")
+	args = []string{"-y", "-v", "error", "-i", lead, "-filter:a", "areverse," + rm + ",areverse", "-c:a", "pcm_s16le", both}
+	err = runFFmpegErr(ctx, args, to)
+	_ = os.Remove(lead)
+	if err != nil {
+		return 0, err
+	}
+	if after, err := probeDuration(ctx, both); err != nil || after < minVoiceDur {
+		_ = os.Remove(both)
+		return 0, fmt.Errorf("trimming left %.2fs of voice; lower -voiceTrimDb or use -voiceTrim=false", after)
+	}
+	return before - mid, moveFile(both, path)
+}
+
+// ttsErrLines is how much of a failed tool's stderr goes into the error.
+const ttsErrLines = 30
+
+// ttsHints recognizes common TTS failures in stderr and says what to do.
+var ttsHints = []struct {
+	re   *regexp.Regexp
+	hint string
+}{
+	{cudaOOMRe, "the GPU ran out of memory; free it or run with -ttsCUDA=false"},
+	{regexp.MustCompile(`(?i)speaker.{0,40}(not found|invalid|out of range|not in)|speaker_idx|KeyError: '[^']*'.*speaker`),
+		"the speaker is not in this model; list them with `tts --model_name <model> --list_speaker_idxs` or set -ttsSpeakerFallback"},
+	{regexp.MustCompile(`(?i)model.{0,40}(not found|does not exist|no such)|not a valid model|\.onnx.*(not found|no such)`),
+		"check -ttsModel (`tts --list_models` lists Coqui's names)"},
+}
+
+// ttsToolError wraps a failed TTS process's error (keeping the
+// *exec.ExitError reachable) with the last lines of its stderr and a hint
+// when the failure is a familiar one.
+func ttsToolError(name string, err error, stderr string) error {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	if len(lines) > ttsErrLines {
+		lines = lines[len(lines)-ttsErrLines:]
+	}
+	tail := strings.Join(lines, "\n")
+	for _, h := range ttsHints {
+		if h.re.MatchString(stderr) {
+			return fmt.Errorf("%s: %w\n%s\nhint: %s", name, err, tail, h.hint)
+		}
+	}
+	return fmt.Errorf("%s: %w\n%s", name, err, tail)
+}
+
+// tailBuffer keeps the last max bytes written, for quoting a tool's
+// stderr in an error without holding all of it.
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.max; over > 0 {
+		t.buf = t.buf[over:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string { return string(t.buf) }
+
+// edgeDefaultVoice is used when -ttsVoice is empty.
+const edgeDefaultVoice = "en-US-AriaNeural"
+
+// runEdgeTTS shells out to the edge-tts CLI, which writes MP3, and
+// transcodes the result to outPath. No GPU is involved.
+func runEdgeTTS(ctx context.Context, o *ttsOptions, text, outPath string, to time.Duration) error {
+	voice := o.voice
+	if voice == "" {
+		voice = edgeDefaultVoice
+	}
+	mp3 := outPath + ".mp3"
+	args := []string{"--voice", voice, "--text", text, "--write-media", mp3}
+	if len(text) > longTextChars {
+		f, err := os.CreateTemp(o.work, "edge-*.txt")
+		if err != nil {
+			return err
+		}
+		txt := f.Name()
+		defer os.Remove(txt)
+		_, err = f.WriteString(text)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		args = []string{"--voice", voice, "--file", txt, "--write-media", mp3}
+		fmt.Printf("running: %s %s (%d chars)\n", o.bin, strings.Join(quote(args), " "), len([]rune(text)))
+	} else {
+		fmt.Printf("running: %s %s\n", o.bin, strings.Join(quote(args), " "))
+	}
+	ctx, cancel := stageContext(ctx, to)
+	defer cancel()
+
+	sctx, stall := watchStall(ctx, o.stall, mp3)
+	cmd := newCommand(sctx, o.bin, args...)
+	stderr := &tailBuffer{max: 16 << 10}
+	cmd.Stdout = stall.writer(os.Stdout)
+	cmd.Stderr = stall.writer(io.MultiWriter(os.Stderr, stderr))
+	defer os.Remove(mp3)
+	if err := cmd.Run(); err != nil {
+		if serr := stall.err("edge-tts"); serr != nil {
+			return serr
+		}
+		if ctx.Err() != nil {
+			return stageError(ctx, "edge-tts", to, err, "")
+		}
+		return ttsToolError("edge-tts", err, stderr.String())
+	}
+	if _, err := os.Stat(mp3); err != nil {
+		return fmt.Errorf("edge-tts did not produce %s", mp3)
+	}
+	return transcodeWAV(ctx, mp3, outPath, 0)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Web API engines. The response audio is saved beside outPath and
+// transcoded to WAV, so whisper and the mux see the same kind of file no
+// matter which engine spoke.
+
+const (
+	elevenKeyEnv       = "ELEVENLABS_API_KEY"
+	elevenDefaultModel = "eleven_multilingual_v2"
+	elevenMaxChars     = 5000 // per-request text limit
+
+	openaiKeyEnv       = "OPENAI_API_KEY"
+	openaiDefaultModel = "tts-1"
+	openaiDefaultVoice = "alloy"
+	openaiMaxChars     = 4096
+	openaiRate         = 44100 // matches the mux's aformat, so resampling is identical
+
+	ttsAPIRetries = 5 // attempts on 429
+)
+
+// openaiFormats are the response_format values -ttsFormat accepts.
+var openaiFormats = map[string]bool{"mp3": true, "opus": true, "aac": true, "flac": true, "wav": true}
+
+func runElevenLabsTTS(ctx context.Context, o *ttsOptions, text, outPath string, to time.Duration) error {
+	body, err := json.Marshal(map[string]string{"text": text, "model_id": o.model})
+	if err != nil {
+		return err
+	}
+	url := "https://api.elevenlabs.io/v1/text-to-speech/" + o.voice + "/stream"
+	hdr := map[string]string{
+		"xi-api-key":   os.Getenv(elevenKeyEnv),
+		"Content-Type": "application/json",
+		"Accept":       "audio/mpeg",
+	}
+	return fetchSpeech(ctx, "elevenlabs", url, hdr, body, ".mp3", outPath, 0, to)
+}
+
+func runOpenAITTS(ctx context.Context, o *ttsOptions, text, outPath string, to time.Duration) error {
+	voice := o.voice
+	if voice == "" {
+		voice = openaiDefaultVoice
+	}
+	body, err := json.Marshal(map[string]string{
+		"model":           o.model,
+		"input":           text,
+		"voice":           voice,
+		"response_format": o.format,
+	})
+	if err != nil {
+		return err
+	}
+	hdr := map[string]string{
+		"Authorization": "Bearer " + os.Getenv(openaiKeyEnv),
+		"Content-Type":  "application/json",
+	}
+	return fetchSpeech(ctx, "openai", "https://api.openai.com/v1/audio/speech", hdr, body, "."+o.format, outPath, openaiRate, to)
+}
+
+// runCoquiServer synthesizes through a running Coqui tts-server, so the
+// model is loaded once rather than per CLI call. Reference clips are
+// passed as paths and must be readable by the server.
+func runCoquiServer(ctx context.Context, o *ttsOptions, text, outPath string, to time.Duration) error {
+	form := url.Values{"text": {text}}
+	if o.speaker != "" {
+		form.Set("speaker_id", o.speaker)
+	}
+	if o.lang != "" {
+		form.Set("language_id", o.lang)
+	}
+	if o.speakerWav != "" {
+		form["speaker_wav"] = speakerWavs(o.speakerWav)
+	}
+	endpoint := strings.TrimRight(o.server, "/") + "/api/tts"
+	hdr := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	err := fetchSpeech(ctx, "tts-server", endpoint, hdr, []byte(form.Encode()), ".wav", outPath, 0, to)
+	var ue *url.Error
+	if errors.As(err, &ue) && !ue.Timeout() {
+		return fmt.Errorf("%w\nhint: is the server running? start it with `tts-server --model_name %s`", err, o.model)
+	}
+	return err
+}
+
+// fetchSpeech POSTs body to url, retrying on 429 as told by Retry-After,
+// saves the returned audio and converts it to WAV at outPath (resampled to
+// rate unless 0).
+func fetchSpeech(ctx context.Context, name, url string, hdr map[string]string, body []byte, ext, outPath string, rate int, to time.Duration) error {
+	ctx, cancel := stageContext(ctx, to)
+	defer cancel()
+	fmt.Printf("requesting: %s %s (%d bytes)\n", name, url, len(body))
+
+	raw := outPath + ext // e.g. story.wav.mp3, removed after conversion
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return stageError(ctx, name, to, err, "")
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < ttsAPIRetries {
+			wait := retryAfter(resp.Header.Get("Retry-After"), attempt)
+			resp.Body.Close()
+			fmt.Printf("%s: rate limited, retrying in %v\n", name, wait)
+			select {
+			case <-time.After(wait):
+				continue
+			case <-ctx.Done():
+				return stageError(ctx, name, to, ctx.Err(), "")
+			}
+		}
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			return fmt.Errorf("%s: HTTP %d: %s", name, resp.StatusCode, strings.TrimSpace(string(msg)))
+		}
+		err = writeBody(resp.Body, raw)
+		resp.Body.Close()
+		if err != nil {
+			return stageError(ctx, name, to, err, "")
+		}
+		break
+	}
+	defer os.Remove(raw)
+	if err := transcodeWAV(ctx, raw, outPath, rate); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// transcodeWAV converts an engine's compressed output to the 16-bit WAV
+// the rest of the pipeline expects, resampling to rate when it is not 0.
+func transcodeWAV(ctx context.Context, src, outPath string, rate int) error {
+	args := []string{"-y", "-v", "error", "-i", src, "-c:a", "pcm_s16le"}
+	if rate > 0 {
+		args = append(args, "-ar", strconv.Itoa(rate))
+	}
+	args = append(args, outPath)
+	if err := runFFmpegErr(ctx, args, 0); err != nil {
+		return fmt.Errorf("convert %s to wav: %w", src, err)
+	}
+	return nil
+}
+
+// retryAfter reads a Retry-After value in seconds, falling back to a
+// growing delay when the header is missing or an HTTP date.
+func retryAfter(h string, attempt int) time.Duration {
+	if n, err := strconv.Atoi(strings.TrimSpace(h)); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second
+	}
+	return time.Duration(attempt*attempt) * time.Second
+}
+
+func writeBody(r io.Reader, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
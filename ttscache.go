@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Synthesized narration is cached under -ttsCache as <key>.wav, with the
+// timeline of its parts next to it in <key>.spans. The key covers everything
+// that changes the audio, so a hit can stand in for the whole TTS stage.
+
+// ttsCacheKey hashes the text parts and the synthesis settings, including
+// each dialogue speaker's voice and a description of the post-processing.
+// Reference WAVs are hashed by content, so re-recording one misses.
+func ttsCacheKey(o *ttsOptions, parts []storyPart, maxChars int, post string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "maxChars=%d\npost=%s\n", maxChars, post)
+	if err := hashVoice(h, o); err != nil {
+		return "", err
+	}
+	for _, p := range parts {
+		fmt.Fprintf(h, "part %q pause=%g sfx=%q speaker=%q\n", p.text, p.pause, p.sfx, p.speaker)
+		if p.tts != nil {
+			if err := hashVoice(h, p.tts); err != nil {
+				return "", err
+			}
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)[:16]), nil
+}
+
+func hashVoice(h io.Writer, o *ttsOptions) error {
+	fmt.Fprintf(h, "engine=%s\nmodel=%s\nspeaker=%s\nlang=%s\nvoice=%s\nformat=%s\n",
+		o.engine, o.model, o.speaker, o.lang, o.voice, o.format)
+	for _, w := range speakerWavs(o.speakerWav) {
+		if err := hashFile(h, "speakerWav", w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hashFile(h io.Writer, label, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	io.WriteString(h, label+"=")
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	io.WriteString(h, "\n")
+	return nil
+}
+
+// loadTTSCache links (or copies) the cached voice for key to out and
+// returns its part spans. ok is false on a miss.
+func loadTTSCache(dir, key, out string) (spans []partSpan, ok bool, err error) {
+	wav := filepath.Join(dir, key+".wav")
+	if !pathExists(wav) {
+		return nil, false, nil
+	}
+	spans, err = readSpans(filepath.Join(dir, key+".spans"))
+	if err != nil {
+		return nil, false, err
+	}
+	_ = os.Remove(out)
+	if os.Link(wav, out) != nil {
+		if err := copyFile(wav, out); err != nil {
+			return nil, false, err
+		}
+	}
+	return spans, true, nil
+}
+
+// storeTTSCache copies voice into the cache under key. Both files are
+// written to temp names and renamed; the WAV goes last, so a reader never
+// sees a WAV without its spans or a partial WAV.
+func storeTTSCache(dir, key, voice string, spans []partSpan) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, sp := range spans {
+		fmt.Fprintf(&b, "%s\t%s\n", fmtSec(sp.start), fmtSec(sp.end))
+	}
+	tmp, err := os.CreateTemp(dir, key+".spans.tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(b.String())
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, key+".spans"))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	tmp, err = os.CreateTemp(dir, key+".wav.tmp-*")
+	if err != nil {
+		return err
+	}
+	name := tmp.Name()
+	_ = tmp.Close()
+	if err := copyFile(voice, name); err != nil {
+		_ = os.Remove(name)
+		return err
+	}
+	if err := os.Rename(name, filepath.Join(dir, key+".wav")); err != nil {
+		_ = os.Remove(name)
+		return err
+	}
+	return nil
+}
+
+func readSpans(path string) ([]partSpan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var spans []partSpan
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		a, b, ok := strings.Cut(sc.Text(), "\t")
+		if !ok {
+			continue
+		}
+		start, err1 := strconv.ParseFloat(a, 64)
+		end, err2 := strconv.ParseFloat(b, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("%s: bad span %q", path, sc.Text())
+		}
+		spans = append(spans, partSpan{start, end})
+	}
+	return spans, sc.Err()
+}
+
+// copyFile copies src over dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
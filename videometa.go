@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// videoMeta is the per-video metadata JSON exported by upload tools.
+// Unknown fields are ignored.
+type videoMeta struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	PublishDate string   `json:"publish_date"`
+	PublishedAt string   `json:"publishedAt"` // YouTube API spelling
+}
+
+func readVideoMeta(path string) (*videoMeta, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m videoMeta
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if m.PublishDate == "" {
+		m.PublishDate = m.PublishedAt
+	}
+	return &m, nil
+}
+
+// containerTags maps the metadata onto MP4 tags. title is the effective
+// -metaTitle, which may come from the file or the command line.
+func (m *videoMeta) containerTags(title string) map[string]string {
+	tags := map[string]string{}
+	if title != "" {
+		tags["title"] = title
+	}
+	if m == nil {
+		return tags
+	}
+	if m.Description != "" {
+		tags["description"] = m.Description
+	}
+	if len(m.Tags) > 0 {
+		tags["keywords"] = strings.Join(m.Tags, ",")
+	}
+	if m.PublishDate != "" {
+		tags["date"] = m.PublishDate
+	}
+	return tags
+}
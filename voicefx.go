@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Voice post-processing applied to the synthesized WAV before whisper and
+// the mux.
+
+// loudnormStats is the part of loudnorm's print_format=json report that
+// the second pass needs. ffmpeg prints the numbers as strings.
+type loudnormStats struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+const (
+	voiceNormTP  = -1.5 // true peak ceiling, dBTP
+	voiceNormLRA = 11   // loudness range target, LU
+)
+
+// normalizeLoudness brings the WAV at path to target LUFS in place with a
+// two-pass loudnorm: the first pass measures, the second applies the
+// measured values linearly. loudnorm resamples internally, so the output
+// is set back to the input's sample rate.
+func normalizeLoudness(ctx context.Context, path string, target float64, work string, to time.Duration) (*loudnormStats, error) {
+	spec := fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g", target, float64(voiceNormTP), float64(voiceNormLRA))
+	mctx, cancel := stageContext(ctx, to)
+	defer cancel()
+	cmd := newCommand(mctx, "ffmpeg", "-hide_banner", "-nostats", "-i", path,
+		"-af", spec+":print_format=json", "-f", "null", "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, stageError(mctx, "loudnorm measure", to, err, "")
+	}
+	out := stderr.String()
+	i, j := strings.LastIndex(out, "{"), strings.LastIndex(out, "}")
+	if i < 0 || j < i {
+		return nil, fmt.Errorf("loudnorm printed no measurement")
+	}
+	var st loudnormStats
+	if err := json.Unmarshal([]byte(out[i:j+1]), &st); err != nil {
+		return nil, fmt.Errorf("parse loudnorm measurement: %w", err)
+	}
+	if strings.Contains(st.InputI, "inf") {
+		return nil, fmt.Errorf("voice is silent; nothing to normalize")
+	}
+
+	rate, err := probeSampleRate(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	af := fmt.Sprintf("%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+		spec, st.InputI, st.InputTP, st.InputLRA, st.InputThresh, st.TargetOffset)
+	tmp := filepath.Join(work, "voice-norm.wav")
+	args := []string{"-y", "-v", "error", "-i", path, "-af", af, "-ar", strconv.Itoa(rate), "-c:a", "pcm_s16le", tmp}
+	if err := runFFmpegErr(ctx, args, to); err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	return &st, moveFile(tmp, path)
+}
+
+// probeSampleRate returns the sample rate of the first audio stream.
+func probeSampleRate(ctx context.Context, path string) (int, error) {
+	out, err := newCommand(ctx, "ffprobe", "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "stream=sample_rate", "-of", "default=noprint_wrappers=1:nokey=1", path).Output()
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(out))
+	rate, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("parse sample rate %q: %w", s, err)
+	}
+	return rate, nil
+}
+
+// parseVoiceEQ turns -voiceEq into an ffmpeg filter chain. Tokens are
+// comma-separated: bass:G and treble:G shelve by G dB, F:G is a
+// one-octave peak of G dB at F Hz, and F:G:Q sets the peak's Q.
+func parseVoiceEQ(s string) (string, error) {
+	var filters []string
+	for _, tok := range splitTrim(s, ",", -1) {
+		f := strings.Split(tok, ":")
+		bad := func(why string) (string, error) { return "", fmt.Errorf("bad -voiceEq token %q: %s", tok, why) }
+		if len(f) < 2 || len(f) > 3 {
+			return bad("want bass:G, treble:G, F:G or F:G:Q")
+		}
+		g, err := strconv.ParseFloat(f[1], 64)
+		if err != nil || g < -24 || g > 24 {
+			return bad("gain must be a number of dB in -24..24")
+		}
+		switch f[0] {
+		case "bass", "treble":
+			if len(f) == 3 {
+				return bad("shelves take no Q")
+			}
+			filters = append(filters, fmt.Sprintf("%s=g=%g", f[0], g))
+			continue
+		}
+		hz, err := strconv.ParseFloat(f[0], 64)
+		if err != nil || hz < 20 || hz > 20000 {
+			return bad("frequency must be bass, treble or 20..20000 Hz")
+		}
+		if len(f) == 2 {
+			filters = append(filters, fmt.Sprintf("equalizer=f=%g:t=o:w=1:g=%g", hz, g))
+			continue
+		}
+		q, err := strconv.ParseFloat(f[2], 64)
+		if err != nil || q < 0.1 || q > 10 {
+			return bad("Q must be in 0.1..10")
+		}
+		filters = append(filters, fmt.Sprintf("equalizer=f=%g:t=q:w=%g:g=%g", hz, q, g))
+	}
+	if len(filters) == 0 {
+		return "", fmt.Errorf("empty -voiceEq")
+	}
+	return strings.Join(filters, ","), nil
+}
+
+// maxPitchShift bounds -voicePitch, in semitones.
+const maxPitchShift = 12
+
+// pitchFilter shifts pitch by semitones while keeping the duration:
+// rubberband when ffmpeg has it, else asetrate+aresample with atempo
+// undoing the speed change.
+func pitchFilter(semitones float64, rate int) string {
+	factor := math.Pow(2, semitones/12)
+	if hasFilter("rubberband") {
+		return fmt.Sprintf("rubberband=pitch=%g", factor)
+	}
+	return fmt.Sprintf("asetrate=%d,aresample=%d,%s", int(math.Round(float64(rate)*factor)), rate, atempoChain(1/factor))
+}
+
+// shapeVoice applies -voicePitch and -voiceEq to the WAV at path: the
+// result is rendered to an intermediate in work and moved over path.
+func shapeVoice(ctx context.Context, path string, semitones float64, eq string, work string, to time.Duration) error {
+	var chain []string
+	if semitones != 0 {
+		rate, err := probeSampleRate(ctx, path)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, pitchFilter(semitones, rate))
+	}
+	if eq != "" {
+		chain = append(chain, eq)
+	}
+	tmp := filepath.Join(work, "voice-fx.wav")
+	args := []string{"-y", "-v", "error", "-i", path, "-af", strings.Join(chain, ","), "-c:a", "pcm_s16le", tmp}
+	if err := runFFmpegErr(ctx, args, to); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return moveFile(tmp, path)
+}
+
+// resampleVoice converts the WAV at path to rate Hz in place, so whisper
+// and the mux see one rate whatever the model emits. It reports whether
+// a conversion was needed.
+func resampleVoice(ctx context.Context, path string, rate int, work string, to time.Duration) (bool, error) {
+	cur, err := probeSampleRate(ctx, path)
+	if err != nil {
+		return false, err
+	}
+	if cur == rate {
+		return false, nil
+	}
+	tmp := filepath.Join(work, "voice-rate.wav")
+	args := []string{"-y", "-v", "error", "-i", path, "-af", "aresample=" + strconv.Itoa(rate), "-c:a", "pcm_s16le", tmp}
+	if err := runFFmpegErr(ctx, args, to); err != nil {
+		_ = os.Remove(tmp)
+		return false, err
+	}
+	return true, moveFile(tmp, path)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Audio watermark: a spread-spectrum pattern added to the final mix. A
+// frame is a sync sequence followed by 32 payload bits, each a keyed
+// pseudo-noise sequence of wmChipsPerBit chips sent as +/-. Frames repeat
+// for the whole program, so the detector folds the audio modulo the frame
+// length and the pattern adds up coherently while the music does not.
+// Chips are timed in seconds, not samples, so the pattern survives
+// resampling; at wmChipRate the energy sits below 4 kHz, which AAC keeps.
+// The level follows the program's short-term loudness, keeping it
+// wmDefaultStrength below the content and absent in silence. Detection
+// needs a few frames of program; noise-like content needs more.
+
+const (
+	wmChipRate        = 4000 // chips per second
+	wmChipsPerBit     = 512  // 0.128 s per bit
+	wmBits            = 32
+	wmFrameChips      = (wmBits + 1) * wmChipsPerBit // sync + payload, ~4.2 s
+	wmDefaultStrength = 0.04                         // about -28 dB under the program
+	wmDefaultKey      = "avmux-watermark-v1"
+	wmDetectRate      = 16000 // detector sample rate
+	wmMinZ            = 6.0   // sync peak (in std devs) needed to report an ID
+)
+
+// watermarkCode derives the 32-bit payload from a free-form ID. Detection
+// reports this code; the embed step prints it next to the ID.
+func watermarkCode(id string) uint32 {
+	h := sha256.Sum256([]byte(id))
+	return binary.BigEndian.Uint32(h[:4])
+}
+
+// jobFingerprint is the default watermark ID: a short hash of the story
+// text and the output name.
+func jobFingerprint(text, out string) string {
+	h := sha256.Sum256([]byte(text + "\x00" + filepath.Base(out)))
+	return fmt.Sprintf("%x", h[:6])
+}
+
+// wmChips returns the frame's chip signs for key and code: the sync
+// sequence followed by each payload bit's sequence, sign-flipped for a 0.
+func wmChips(key string, code uint32) []float32 {
+	h := sha256.Sum256([]byte(key))
+	r := rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(h[:8]))))
+	chips := make([]float32, wmFrameChips)
+	for i := range chips {
+		chips[i] = float32(r.Intn(2)*2 - 1)
+	}
+	for b := 0; b < wmBits; b++ {
+		if code>>(wmBits-1-b)&1 == 0 {
+			seg := chips[(b+1)*wmChipsPerBit : (b+2)*wmChipsPerBit]
+			for i := range seg {
+				seg[i] = -seg[i]
+			}
+		}
+	}
+	return chips
+}
+
+// embedWatermark adds the pattern to interleaved samples in place.
+func embedWatermark(pcm []float32, channels, rate int, key string, code uint32, strength float64) {
+	chips := wmChips(key, code)
+	frames := len(pcm) / channels
+	env := loudnessEnvelope(pcm, channels, rate)
+	for n := 0; n < frames; n++ {
+		chip := chips[int(int64(n)*wmChipRate/int64(rate))%wmFrameChips]
+		v := chip * float32(strength*env[n])
+		for c := 0; c < channels; c++ {
+			pcm[n*channels+c] += v
+		}
+	}
+}
+
+// loudnessEnvelope is the RMS over 50 ms blocks, linearly interpolated per
+// sample frame.
+func loudnessEnvelope(pcm []float32, channels, rate int) []float64 {
+	frames := len(pcm) / channels
+	block := max(1, rate/20)
+	var rms []float64
+	for s := 0; s < frames; s += block {
+		e := min(frames, s+block)
+		sum := 0.0
+		for i := s * channels; i < e*channels; i++ {
+			sum += float64(pcm[i]) * float64(pcm[i])
+		}
+		rms = append(rms, math.Sqrt(sum/float64((e-s)*channels)))
+	}
+	env := make([]float64, frames)
+	for n := range env {
+		pos := (float64(n) - float64(block)/2) / float64(block)
+		i := int(math.Floor(pos))
+		f := pos - float64(i)
+		a := rms[max(0, min(len(rms)-1, i))]
+		b := rms[max(0, min(len(rms)-1, i+1))]
+		env[n] = a + (b-a)*f
+	}
+	return env
+}
+
+// detectWatermark looks for the pattern in mono samples at wmDetectRate.
+// It returns the payload, the sync peak as a z-score, and whether that is
+// strong enough to trust.
+func detectWatermark(mono []float32, key string) (uint32, float64, bool) {
+	const spc = wmDetectRate / wmChipRate // samples per chip
+	frameLen := wmFrameChips * spc
+	if len(mono) < frameLen {
+		return 0, 0, false
+	}
+	// whiten (music is bass-heavy) and fold frames on top of each other
+	folded := make([]float64, frameLen)
+	prev := 0.0
+	for i, s := range mono {
+		x := float64(s)
+		folded[i%frameLen] += x - 0.95*prev
+		prev = x
+	}
+
+	ref := wmChips(key, 0)
+	whitenRef := func(seg []float32) []float64 {
+		out := make([]float64, len(seg)*spc)
+		p := 0.0
+		for i := range out {
+			x := float64(seg[i/spc])
+			out[i] = x - 0.95*p
+			p = x
+		}
+		return out
+	}
+	syncRef := whitenRef(ref[:wmChipsPerBit])
+	corrAt := func(off int, r []float64) float64 {
+		sum := 0.0
+		for i, v := range r {
+			sum += folded[(off+i)%frameLen] * v
+		}
+		return sum
+	}
+
+	best, bestAbs := 0, 0.0
+	var sum, sumSq float64
+	corr := make([]float64, frameLen)
+	for off := 0; off < frameLen; off++ {
+		c := corrAt(off, syncRef)
+		corr[off] = c
+		sum += c
+		sumSq += c * c
+		if math.Abs(c) > bestAbs {
+			best, bestAbs = off, math.Abs(c)
+		}
+	}
+	mean := sum / float64(frameLen)
+	std := math.Sqrt(math.Max(sumSq/float64(frameLen)-mean*mean, 1e-30))
+	z := (bestAbs - math.Abs(mean)) / std
+	polarity := 1.0
+	if corr[best] < 0 {
+		polarity = -1
+	}
+
+	var code uint32
+	for b := 0; b < wmBits; b++ {
+		seg := whitenRef(ref[(b+1)*wmChipsPerBit : (b+2)*wmChipsPerBit])
+		code <<= 1
+		if polarity*corrAt(best+(b+1)*wmChipsPerBit*spc, seg) < 0 { // ref carries all zeros
+			code |= 1
+		}
+	}
+	return code, z, z >= wmMinZ
+}
+
+// applyAudioWatermark writes out from the muxed file src, encoding its
+// audio (PCM, so this is the only lossy pass) to AAC with the watermark
+// added. The video stream is copied untouched and a subtitle stream is
+// converted to subCodec.
+func applyAudioWatermark(ctx context.Context, src, out, subCodec, key string, code uint32, strength float64, to time.Duration) error {
+	const rate, channels = 44100, 2
+	dctx, cancel := stageContext(ctx, to)
+	defer cancel()
+	dec := newCommand(dctx, "ffmpeg", "-v", "error", "-i", src, "-vn",
+		"-f", "f32le", "-ac", strconv.Itoa(channels), "-ar", strconv.Itoa(rate), "-")
+	raw, err := dec.Output()
+	if err != nil {
+		return stageError(dctx, "watermark decode", to, fmt.Errorf("decode audio: %w", err), "")
+	}
+	pcm := make([]float32, len(raw)/4)
+	for i := range pcm {
+		pcm[i] = math.Float32frombits(binary.LittleEndian.Uint32(raw[4*i:]))
+	}
+	embedWatermark(pcm, channels, rate, key, code, strength)
+	for i := range pcm {
+		binary.LittleEndian.PutUint32(raw[4*i:], math.Float32bits(pcm[i]))
+	}
+
+	args := []string{"-y", "-v", "error",
+		"-f", "f32le", "-ar", strconv.Itoa(rate), "-ac", strconv.Itoa(channels), "-i", "-",
+		"-i", src, "-map", "1:v", "-map", "0:a", "-map_metadata", "1", "-c:v", "copy"}
+	if subCodec != "" {
+		args = append(args, "-map", "1:s", "-c:s", subCodec)
+	}
+	args = append(args, "-c:a", "aac", "-b:a", "192k", "-movflags", "+faststart", out)
+	ectx, cancel := stageContext(ctx, to)
+	defer cancel()
+	enc := newCommand(ectx, "ffmpeg", args...)
+	enc.Stdin = bytes.NewReader(raw[:4*len(pcm)])
+	enc.Stderr = os.Stderr
+	if err := enc.Run(); err != nil {
+		return stageError(ectx, "watermark encode", to, err, "")
+	}
+	return nil
+}
+
+// runWatermarkDetect implements `avmux watermark detect <file>`.
+func runWatermarkDetect(ctx context.Context, path, key string) error {
+	out, err := newCommand(ctx, "ffmpeg", "-v", "error", "-i", path, "-vn",
+		"-ac", "1", "-ar", strconv.Itoa(wmDetectRate), "-f", "f32le", "-").Output()
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	mono := make([]float32, len(out)/4)
+	for i := range mono {
+		mono[i] = math.Float32frombits(binary.LittleEndian.Uint32(out[4*i:]))
+	}
+	code, z, ok := detectWatermark(mono, key)
+	confidence := math.Max(0, math.Min(1, (z-4)/6))
+	if !ok {
+		fmt.Printf("watermark: none found (sync z=%.1f)\n", z)
+		return nil
+	}
+	fmt.Printf("watermark: code=%08x confidence=%.2f (sync z=%.1f)\n", code, confidence, z)
+	return nil
+}
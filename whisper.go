@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// whisperOptions configures the Python subtitle generator, which reads
+// them from its environment.
+type whisperOptions struct {
+	model   string
+	compute string
+	device  string // cuda or cpu; auto is resolved by newWhisperOptions
+	lang    string // whisper language code; "" -> detect
+}
+
+func (o *whisperOptions) env() []string {
+	env := []string{
+		"WHISPER_MODEL=" + o.model,
+		"WHISPER_COMPUTE=" + o.compute,
+		"DEVICE=" + o.device,
+	}
+	if o.lang != "" {
+		env = append(env, "WHISPER_LANG="+o.lang)
+	}
+	return env
+}
+
+// whisperLangs are the language codes whisper accepts.
+var whisperLangs = strings.Fields(`af am ar as az ba be bg bn bo br bs ca cs cy da de el en es et
+	eu fa fi fo fr gl gu ha haw he hi hr ht hu hy id is it ja jw ka kk km kn ko la lb ln lo
+	lt lv mg mi mk ml mn mr ms mt my ne nl nn no oc pa pl ps pt ro ru sa sd si sk sl sn so
+	sq sr su sv sw ta te tg th tk tl tr tt uk ur uz vi yi yo yue zh`)
+
+func isWhisperLang(code string) bool {
+	for _, l := range whisperLangs {
+		if l == code {
+			return true
+		}
+	}
+	return false
+}
+
+// whisperLangFor maps a TTS language (en, zh-cn, pt-BR) to whisper's code,
+// or "" when whisper has none.
+func whisperLangFor(ttsLang string) string {
+	base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(ttsLang)), "-")
+	if isWhisperLang(base) {
+		return base
+	}
+	return ""
+}
+
+// whisperCPUCompute is the compute type used on the CPU unless
+// -whisperCompute is given; float16 has no fast CPU kernels.
+const whisperCPUCompute = "int8"
+
+// newWhisperOptions validates -whisperDevice and resolves auto: cuda when
+// nvidia-smi finds a GPU, else cpu. computeSet tells whether
+// -whisperCompute was given explicitly.
+func newWhisperOptions(ctx context.Context, model, compute, device string, computeSet bool) (*whisperOptions, error) {
+	o := &whisperOptions{model: model, compute: compute, device: device}
+	switch device {
+	case "cuda", "cpu":
+	case "auto":
+		if err := probeGPU(ctx); err != nil {
+			fmt.Printf("whisper: no GPU found (%v), using cpu\n", err)
+			o.device = "cpu"
+		} else {
+			o.device = "cuda"
+		}
+	default:
+		return nil, fmt.Errorf("-whisperDevice must be cuda|cpu|auto, got %q", device)
+	}
+	if o.device == "cpu" {
+		if !computeSet {
+			o.compute = whisperCPUCompute
+		} else if strings.Contains(o.compute, "float16") {
+			fmt.Fprintf(os.Stderr, "WARNING: -whisperCompute=%s on the CPU is slow or unsupported; %s is the usual choice\n", o.compute, whisperCPUCompute)
+		}
+	}
+	return o, nil
+}
+
+// probeGPU reports why no usable NVIDIA GPU is present, or nil.
+func probeGPU(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	out, err := newCommand(ctx, "nvidia-smi", "-L").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("nvidia-smi: %v", err)
+	}
+	if !strings.Contains(string(out), "GPU") {
+		return fmt.Errorf("nvidia-smi lists no GPUs")
+	}
+	return nil
+}
+
+func (o *whisperOptions) String() string {
+	return fmt.Sprintf("model %s, %s on %s", o.model, o.compute, o.device)
+}
+
+// Out-of-memory fallbacks (-whisperFallbacks): when the generator runs out
+// of GPU memory it is run again with the next rung of a ladder applied on
+// top of the previous ones, by default a lighter compute type, then a
+// smaller model, then the CPU.
+
+// errWhisperOOM marks a generator failure caused by GPU memory.
+var errWhisperOOM = errors.New("whisper ran out of GPU memory")
+
+// whisperStep is one rung of the ladder: an option and its new value.
+type whisperStep struct{ key, val string }
+
+// parseWhisperFallbacks parses a comma-separated list of compute=X,
+// model=X and device=cpu|cuda steps; "" and "none" disable the ladder.
+func parseWhisperFallbacks(s string) ([]whisperStep, error) {
+	if s == "" || s == "none" {
+		return nil, nil
+	}
+	var steps []whisperStep
+	for _, f := range strings.Split(s, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(f), "=")
+		val = strings.TrimSpace(val)
+		switch {
+		case !ok || val == "":
+			return nil, fmt.Errorf("want key=value, got %q", f)
+		case key == "device" && val != "cpu" && val != "cuda":
+			return nil, fmt.Errorf("device must be cpu|cuda, got %q", val)
+		case key != "compute" && key != "model" && key != "device":
+			return nil, fmt.Errorf("unknown option %q (compute, model or device)", key)
+		}
+		steps = append(steps, whisperStep{key, val})
+	}
+	return steps, nil
+}
+
+// apply returns o with the step's change. Moving to the CPU also moves to
+// the CPU compute type.
+func (s whisperStep) apply(o whisperOptions) whisperOptions {
+	switch s.key {
+	case "compute":
+		o.compute = s.val
+	case "model":
+		o.model = s.val
+	case "device":
+		o.device = s.val
+		if s.val == "cpu" {
+			o.compute = whisperCPUCompute
+		}
+	}
+	return o
+}
+
+// runWhisperLadder calls run with wo and, while it fails with
+// errWhisperOOM, again with each step applied in turn. It returns the
+// options of the last attempt and how many retries were made.
+func runWhisperLadder(ctx context.Context, wo *whisperOptions, steps []whisperStep, run func(*whisperOptions) error) (*whisperOptions, int, error) {
+	cur := *wo
+	err := run(&cur)
+	retries := 0
+	for _, st := range steps {
+		if err == nil || !errors.Is(err, errWhisperOOM) || ctx.Err() != nil {
+			break
+		}
+		next := st.apply(cur)
+		if next == cur {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "NOTE: whisper ran out of GPU memory with %v; retrying with %v (-whisperFallbacks=none to fail instead)\n", &cur, &next)
+		cur = next
+		retries++
+		err = run(&cur)
+	}
+	return &cur, retries, err
+}
+
+// whisperCUDARe matches the errors CTranslate2 prints when it cannot use
+// the GPU.
+var whisperCUDARe = regexp.MustCompile(`(?i)cuda (driver|runtime|error|failed)|no cuda-capable device|libcublas|libcudnn`)
+
+// subsErrLines is how much of the generator's stderr goes into the error.
+const subsErrLines = 40
+
+// subsHints recognizes common generator failures in stderr and says what
+// to do.
+var subsHints = []struct {
+	re   *regexp.Regexp
+	hint string
+}{
+	{regexp.MustCompile(`ModuleNotFoundError: No module named`),
+		"a Python package is missing; install it into the -python environment (e.g. `.venv/bin/pip install faster-whisper`)"},
+	{cudaOOMRe, "the GPU ran out of memory; use a smaller -whisperModel, -whisperCompute=int8_float16 or -whisperDevice=cpu"},
+	{whisperCUDARe, "whisper could not use the GPU; try -whisperDevice=cpu or -whisperDevice=auto"},
+	{regexp.MustCompile(`(?i)ffmpeg.{0,40}(not found|no such file)|(not found|no such file).{0,40}'ffmpeg'`),
+		"the script cannot run ffmpeg; make sure ffmpeg is in the PATH the script sees"},
+	{regexp.MustCompile(`(?i)(FileNotFoundError|no such file).{0,200}\.(wav|mp3|flac|m4a)`),
+		"the script could not open the voice file; check -voiceOut/-voiceIn"},
+}
+
+// subsToolError wraps a failed generator's error with its exit code, the
+// last lines of its stderr and a hint when the failure is a familiar one.
+func subsToolError(err error, stderr string) error {
+	msg := "subtitle generator failed"
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode() >= 0 {
+		msg = fmt.Sprintf("subtitle generator exited with code %d", ee.ExitCode())
+	}
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	if len(lines) > subsErrLines {
+		lines = lines[len(lines)-subsErrLines:]
+	}
+	tail := strings.Join(lines, "\n")
+	for _, h := range subsHints {
+		if h.re.MatchString(stderr) {
+			return fmt.Errorf("%s: %w\n%s\nhint: %s", msg, err, tail, h.hint)
+		}
+	}
+	return fmt.Errorf("%s: %w\n%s", msg, err, tail)
+}
+
+// dirListing names up to max entries of dir, for saying what a tool left
+// there instead of the file expected.
+func dirListing(dir string, max int) string {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return err.Error()
+	}
+	if len(ents) == 0 {
+		return "nothing"
+	}
+	var names []string
+	for _, e := range ents {
+		if len(names) == max {
+			names = append(names, fmt.Sprintf("... (%d more)", len(ents)-max))
+			break
+		}
+		names = append(names, e.Name())
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// whisper.cpp subtitle backend (-subsEngine whispercpp): no Python, just
+// the whisper-cli binary and a ggml model file. The full JSON output has
+// per-token times; tokens are joined into words (a token starting with a
+// space starts a new word) and the ASS is written in Go.
+
+// whisperCppBins are the names whisper.cpp's CLI has shipped under.
+var whisperCppBins = []string{"whisper-cli", "whisper-cpp"}
+
+// findWhisperCpp returns bin if set, else the first whisperCppBins entry
+// in PATH.
+func findWhisperCpp(bin string) (string, error) {
+	if bin != "" {
+		return exec.LookPath(bin)
+	}
+	for _, b := range whisperCppBins {
+		if p, err := exec.LookPath(b); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("none of %s in PATH; set -whisperCppBin", strings.Join(whisperCppBins, ", "))
+}
+
+// whisperCppJSON is the part of whisper.cpp's --output-json-full output
+// that is used.
+type whisperCppJSON struct {
+	Transcription []struct {
+		Tokens []struct {
+			Text    string `json:"text"`
+			Offsets struct {
+				From int `json:"from"` // ms
+				To   int `json:"to"`
+			} `json:"offsets"`
+		} `json:"tokens"`
+	} `json:"transcription"`
+}
+
+// parseWhisperCppJSON turns whisper.cpp full JSON into words.
+func parseWhisperCppJSON(b []byte) ([]timedWord, error) {
+	var doc whisperCppJSON
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Transcription == nil {
+		return nil, fmt.Errorf("no transcription array; not whisper.cpp --output-json-full output")
+	}
+	var words []timedWord
+	for _, seg := range doc.Transcription {
+		for _, t := range seg.Tokens {
+			if strings.HasPrefix(t.Text, "[_") && strings.HasSuffix(t.Text, "]") {
+				continue // [_BEG_], [_TT_42] and other special tokens
+			}
+			from, to := float64(t.Offsets.From)/1000, float64(t.Offsets.To)/1000
+			n := len(words)
+			if n == 0 || strings.HasPrefix(t.Text, " ") || strings.TrimSpace(words[n-1].text) == "" {
+				words = append(words, timedWord{start: from, end: to, text: strings.TrimSpace(t.Text)})
+				continue
+			}
+			words[n-1].text += t.Text
+			words[n-1].end = max(words[n-1].end, to)
+		}
+	}
+	return words, nil
+}
+
+// runWhisperCpp transcribes voice with whisper.cpp and writes word-level
+// ASS to assPath. whisper.cpp wants 16 kHz mono, so voice is converted
+// into work first.
+func runWhisperCpp(ctx context.Context, bin, voice, work, assPath string, wo *whisperOptions, to time.Duration) error {
+	wav := filepath.Join(work, "whisper-16k.wav")
+	if err := runFFmpegErr(ctx, []string{"-y", "-v", "error", "-i", voice, "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", wav}, to); err != nil {
+		return fmt.Errorf("convert voice for whisper.cpp: %w", err)
+	}
+
+	base := filepath.Join(work, "whisper-words")
+	lang := wo.lang
+	if lang == "" {
+		lang = "auto"
+	}
+	args := []string{"-m", wo.model, "-f", wav, "-l", lang, "--output-json-full", "-of", base}
+	if wo.device == "cpu" {
+		args = append(args, "--no-gpu")
+	}
+	fmt.Printf("running: %s %s\n", bin, strings.Join(quote(args), " "))
+	sctx, cancel := stageContext(ctx, to)
+	defer cancel()
+	cmd := newCommand(sctx, bin, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return stageError(sctx, "whisper.cpp", to, err, "")
+	}
+
+	b, err := os.ReadFile(base + ".json")
+	if err != nil {
+		return err
+	}
+	words, err := parseWhisperCppJSON(b)
+	if err != nil {
+		return fmt.Errorf("%s.json: %w", base, err)
+	}
+	d, err := wordsASS(words)
+	if err != nil {
+		return fmt.Errorf("whisper.cpp: %w", err)
+	}
+	return writeASS(assPath, d)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Word-level ASS written in Go, for subtitle sources other than the Python
+// generator. Every word becomes one Dialogue event, as the generator
+// writes them, so the -sub* style flags, smoothing and cue grouping work
+// the same on either path.
+
+// plainASSHeader is the script header for ASS written in Go: a 1080p
+// canvas with one bottom-centred style.
+const plainASSHeader = `[Script Info]
+ScriptType: v4.00+
+PlayResX: 1920
+PlayResY: 1080
+ScaledBorderAndShadow: yes
+WrapStyle: 0
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Arial,64,&H00FFFFFF,&H000000FF,&H00000000,&H80000000,-1,0,0,0,100,100,0,0,1,3,0,2,80,80,60,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`
+
+// timedWord is a recognized word with its times in seconds.
+type timedWord struct {
+	start, end float64
+	text       string
+}
+
+// wordsASS builds a word-level ASS document. Words without text are
+// skipped and each event lasts at least a centisecond.
+func wordsASS(words []timedWord) (*assDoc, error) {
+	d, err := parseASS([]byte(plainASSHeader))
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range words {
+		text := strings.TrimSpace(w.text)
+		if text == "" {
+			continue
+		}
+		start := secToCS(w.start)
+		end := max(secToCS(w.end), start+1)
+		d.events = append(d.events, d.newEvent(start, end, "Default", assEscapeText(text)))
+	}
+	if len(d.events) == 0 {
+		return nil, fmt.Errorf("no words")
+	}
+	return d, nil
+}
+
+// Precomputed word timings (-wordsJSON), as written by openai-whisper,
+// faster-whisper or whisperX with word timestamps:
+//
+//	{"segments": [{"words": [{"word": " Hello", "start": 0.1, "end": 0.4}, ...]}, ...]}
+//
+// A bare array of segments, a top-level "words" array as -wordsOut writes
+// and whisper.cpp --output-json-full output are accepted too.
+
+type wordsJSONSegment struct {
+	Words *[]struct {
+		Word  *string  `json:"word"`
+		Text  *string  `json:"text"`
+		Start *float64 `json:"start"`
+		End   *float64 `json:"end"`
+	} `json:"words"`
+}
+
+// wordsJSONSlack is how far past the end of the voice the last word may
+// end before the timings are taken to belong to another recording.
+const wordsJSONSlack = 1.0 // seconds
+
+func parseWordsJSON(b []byte) ([]timedWord, error) {
+	b = bytes.TrimSpace(b)
+	var segs []wordsJSONSegment
+	if bytes.HasPrefix(b, []byte("[")) {
+		if err := json.Unmarshal(b, &segs); err != nil {
+			return nil, err
+		}
+	} else {
+		var doc struct {
+			wordsJSONSegment
+			Segments      *[]wordsJSONSegment `json:"segments"`
+			Transcription json.RawMessage     `json:"transcription"`
+		}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return nil, err
+		}
+		switch {
+		case doc.Segments != nil:
+			segs = *doc.Segments
+		case doc.Words != nil:
+			segs = []wordsJSONSegment{doc.wordsJSONSegment}
+		case doc.Transcription != nil:
+			return parseWhisperCppJSON(b)
+		default:
+			return nil, fmt.Errorf("unknown schema: want a \"segments\" array (whisper), \"words\" (-wordsOut) or \"transcription\" (whisper.cpp)")
+		}
+	}
+	var words []timedWord
+	for i, seg := range segs {
+		if seg.Words == nil {
+			return nil, fmt.Errorf("segment %d has no \"words\"; transcribe with word timestamps", i+1)
+		}
+		for j, w := range *seg.Words {
+			text := w.Word
+			if text == nil {
+				text = w.Text
+			}
+			if text == nil || w.Start == nil || w.End == nil {
+				return nil, fmt.Errorf("segment %d word %d: need word (or text), start and end", i+1, j+1)
+			}
+			if *w.Start < 0 || *w.End < *w.Start {
+				return nil, fmt.Errorf("segment %d word %d: bad times %g..%g", i+1, j+1, *w.Start, *w.End)
+			}
+			words = append(words, timedWord{start: *w.Start, end: *w.End, text: *text})
+		}
+	}
+	return words, nil
+}
+
+// readWordsJSON loads -wordsJSON as a word-level ASS document.
+func readWordsJSON(path string) (*assDoc, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	words, err := parseWordsJSON(b)
+	if err == nil {
+		var d *assDoc
+		if d, err = wordsASS(words); err == nil {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", path, err)
+}
+
+// lastEnd returns the latest event end of d, in centiseconds.
+func (d *assDoc) lastEnd() int {
+	end := 0
+	for _, ev := range d.events {
+		end = max(end, ev.end)
+	}
+	return end
+}
+
+// Word timings sidecar (-wordsOut): the word events of the generated ASS
+// as JSON, for tools that want the timings without parsing ASS. Times are
+// relative to the voice, as -wordsJSON reads them, so feeding the file
+// back reproduces the word-level ASS; voice_delay says where the voice
+// starts in the video.
+
+type wordsOutWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+type wordsOutDoc struct {
+	Language   string         `json:"language,omitempty"`
+	Duration   float64        `json:"duration"`
+	VoiceDelay float64        `json:"voice_delay"`
+	Words      []wordsOutWord `json:"words"`
+}
+
+// writeWordsOut writes the one-word Dialogue events of the ASS file at
+// assPath to path and returns how many there were.
+func writeWordsOut(assPath, path, lang string, duration, voiceDelay float64) (int, error) {
+	d, err := readASS(assPath)
+	if err != nil {
+		return 0, err
+	}
+	doc := wordsOutDoc{Language: lang, Duration: duration, VoiceDelay: voiceDelay, Words: []wordsOutWord{}}
+	for _, i := range wordEvents(d) {
+		ev := &d.events[i]
+		doc.Words = append(doc.Words, wordsOutWord{
+			Word:  strings.TrimSpace(plainText(ev.text)),
+			Start: float64(ev.start) / 100,
+			End:   float64(ev.end) / 100,
+		})
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	return len(doc.Words), os.WriteFile(path, append(b, '\n'), 0o644)
+}
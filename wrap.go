@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Text measurement for burned text. Widths are approximations in ems for a
+// typical sans-serif subtitle font, grouped by script class. They only need
+// to be good enough to make stable layout decisions, not pixel-exact.
+
+func runeEm(r rune) float64 {
+	switch {
+	case unicode.Is(unicode.Mn, r) || r == '\u200b' || r == '\u200d':
+		return 0
+	case isWideRune(r):
+		return 1.0
+	case r == ' ':
+		return 0.28
+	case strings.ContainsRune("il.,;:'!|`", r):
+		return 0.28
+	case strings.ContainsRune("mwMW@", r):
+		return 0.85
+	case unicode.IsUpper(r):
+		return 0.68
+	case unicode.IsDigit(r) || unicode.IsLower(r):
+		return 0.55
+	case unicode.IsPunct(r):
+		return 0.35
+	default:
+		return 0.6
+	}
+}
+
+func textEm(s string) float64 {
+	w := 0.0
+	for _, r := range s {
+		w += runeEm(r)
+	}
+	return w
+}
+
+// isWideRune reports whether r is a full-width CJK character. These are
+// laid out one em wide and may be broken between without a space.
+func isWideRune(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		(r >= 0x3000 && r <= 0x303f) || // CJK symbols and punctuation
+		(r >= 0xff01 && r <= 0xff60) // full-width forms
+}
+
+// isClosingPunct reports whether a line must not start with r.
+func isClosingPunct(r rune) bool {
+	return strings.ContainsRune("、。，．：；？！）」』】〉》〕］｝〙〗ー…・", r) ||
+		strings.ContainsRune(".,;:?!)]}", r)
+}
+
+type wrapToken struct {
+	text  string
+	space bool // preceded by whitespace in the source
+}
+
+// wrapTokens splits s into unbreakable pieces: space-separated words, with
+// runs of CJK characters broken into single characters. Closing
+// punctuation sticks to the piece before it.
+func wrapTokens(s string) []wrapToken {
+	var toks []wrapToken
+	var cur strings.Builder
+	space := false
+	flush := func() {
+		if cur.Len() > 0 {
+			toks = append(toks, wrapToken{text: cur.String(), space: space})
+			cur.Reset()
+			space = false
+		}
+	}
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+			space = len(toks) > 0
+		case isClosingPunct(r) && cur.Len() == 0 && len(toks) > 0 && !space:
+			toks[len(toks)-1].text += string(r)
+		case isWideRune(r):
+			flush()
+			cur.WriteRune(r)
+			flush()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return toks
+}
+
+// wrapText greedily breaks s into lines no wider than maxEm. Words are
+// never split, so a single word wider than maxEm gets a line of its own.
+func wrapText(s string, maxEm float64) []string {
+	var lines []string
+	line := ""
+	for _, t := range wrapTokens(s) {
+		cand := t.text
+		if line != "" {
+			if t.space {
+				cand = line + " " + t.text
+			} else {
+				cand = line + t.text
+			}
+		}
+		if line == "" || textEm(cand) <= maxEm {
+			line = cand
+			continue
+		}
+		lines = append(lines, line)
+		line = t.text
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}